@@ -0,0 +1,442 @@
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// Severity is the severity of a vulnerability, ordered from least to most severe.
+type Severity int
+
+const (
+	SeverityNone Severity = iota // no severity threshold
+	SeverityUnknown
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = []string{"none", "unknown", "low", "medium", "high", "critical"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ParseSeverity parses a severity threshold: low, medium (or moderate), high, critical,
+// or any to include vulnerabilities without a severity.
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return SeverityNone, nil
+	case "any", "unknown":
+		return SeverityUnknown, nil
+	case "low":
+		return SeverityLow, nil
+	case "medium", "moderate":
+		return SeverityMedium, nil
+	case "high":
+		return SeverityHigh, nil
+	case "critical":
+		return SeverityCritical, nil
+	default:
+		return SeverityNone, fmt.Errorf("unknown severity %q, must be one of: any, low, medium, high, critical", s)
+	}
+}
+
+// Vulnerability is a vulnerability of a module version required by a chain.
+type Vulnerability struct {
+	ID       string   `json:"id"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Summary  string   `json:"summary,omitempty"`
+	Module   string   `json:"module"`
+	Version  string   `json:"version"`
+	Fixed    string   `json:"fixed,omitempty"` // first fixed version, if any
+	Severity Severity `json:"severity"`
+}
+
+// VulnDB is a database of vulnerabilities of go modules, loaded from a directory of OSV entries,
+// e.g. an extracted snapshot of https://vuln.go.dev or the osv.dev Go ecosystem export.
+type VulnDB struct {
+	byModule map[string][]*osvEntry
+}
+
+type osvEntry struct {
+	ID               string        `json:"id"`
+	Summary          string        `json:"summary"`
+	Aliases          []string      `json:"aliases"`
+	Withdrawn        string        `json:"withdrawn"`
+	Severity         []osvSeverity `json:"severity"`
+	Affected         []osvAffected `json:"affected"`
+	DatabaseSpecific osvDBSeverity `json:"database_specific"`
+}
+
+type osvSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type osvDBSeverity struct {
+	Severity string `json:"severity"`
+}
+
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges []struct {
+		Type   string `json:"type"`
+		Events []struct {
+			Introduced   string `json:"introduced"`
+			Fixed        string `json:"fixed"`
+			LastAffected string `json:"last_affected"`
+		} `json:"events"`
+	} `json:"ranges"`
+	Versions         []string      `json:"versions"`
+	Severity         []osvSeverity `json:"severity"`
+	DatabaseSpecific osvDBSeverity `json:"database_specific"`
+}
+
+// LoadVulnDB loads the OSV entries of the Go ecosystem from the JSON files in dir and its subdirectories.
+// Other JSON files, e.g. indexes, are ignored.
+func LoadVulnDB(dir string) (*VulnDB, error) {
+	db := &VulnDB{byModule: make(map[string][]*osvEntry)}
+	var entries int
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		bz, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		entry := new(osvEntry)
+		if err := json.Unmarshal(bz, entry); err != nil || entry.ID == "" || entry.Withdrawn != "" {
+			return nil
+		}
+		goEntry := false
+		for _, affected := range entry.Affected {
+			if affected.Package.Ecosystem != "Go" {
+				continue
+			}
+			db.byModule[affected.Package.Name] = append(db.byModule[affected.Package.Name], entry)
+			goEntry = true
+		}
+		if goEntry {
+			entries++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading vulnerability database: %w", err)
+	}
+	if entries == 0 {
+		return nil, fmt.Errorf("no OSV entries of Go modules found in vulnerability database %s", dir)
+	}
+	return db, nil
+}
+
+// AuditReport is the outcome of auditing the go modules of a chain and the go version it is built with.
+type AuditReport struct {
+	// Vulnerabilities are the known vulnerabilities, most severe first.
+	Vulnerabilities []Vulnerability `json:"vulnerabilities"`
+	// Unaudited are the modules replaced with local directories, which have no version to audit.
+	Unaudited []ModuleVersion `json:"unaudited,omitempty"`
+}
+
+// goModules are the modules of the go vulnerability database for the standard library and the go command.
+var goModules = []string{"stdlib", "toolchain"}
+
+// Audit returns the vulnerabilities of the modules required by the go.mod and go.work members of files and,
+// if goVersion is set, of the standard library and toolchain of that go version. Replaced modules are
+// checked at the version of their replacement, modules replaced with a local directory are reported as unaudited.
+func (db *VulnDB) Audit(files ChainModFiles, goVersion string) AuditReport {
+	report := AuditReport{Vulnerabilities: []Vulnerability{}}
+	for _, m := range workspaceModules(files) {
+		path, version := m.Path, m.Version
+		if m.Replaced() {
+			if m.ReplaceVersion == "" {
+				report.Unaudited = append(report.Unaudited, m)
+				continue
+			}
+			path, version = m.ReplacePath, m.ReplaceVersion
+		}
+		report.Vulnerabilities = append(report.Vulnerabilities, db.moduleVulnerabilities(path, version)...)
+	}
+	if goVersion != "" {
+		for _, module := range goModules {
+			report.Vulnerabilities = append(report.Vulnerabilities, db.moduleVulnerabilities(module, "v"+goVersion)...)
+		}
+	}
+	vulns := report.Vulnerabilities
+	sort.SliceStable(vulns, func(i, j int) bool {
+		if vulns[i].Severity != vulns[j].Severity {
+			return vulns[i].Severity > vulns[j].Severity
+		}
+		if vulns[i].Module != vulns[j].Module {
+			return vulns[i].Module < vulns[j].Module
+		}
+		return vulns[i].ID < vulns[j].ID
+	})
+	return report
+}
+
+// workspaceModules returns the modules required by the go.mod and the go.work members of files, at the highest
+// required version as selected by the go command. Modules of the workspace are not required from elsewhere,
+// and the replacements of the go.work take precedence over those of its members.
+func workspaceModules(files ChainModFiles) []ModuleVersion {
+	modFiles := append([]*modfile.File{files.Mod}, files.Members...)
+	workspace := make(map[string]bool)
+	if files.Work != nil {
+		for _, f := range modFiles {
+			if f.Module != nil {
+				workspace[f.Module.Mod.Path] = true
+			}
+		}
+	}
+
+	var modules []ModuleVersion
+	selected := make(map[string]int)
+	for _, f := range modFiles {
+		for _, m := range RequiredModules(f) {
+			if workspace[m.Path] {
+				continue
+			}
+			i, ok := selected[m.Path]
+			if !ok {
+				selected[m.Path] = len(modules)
+				modules = append(modules, m)
+				continue
+			}
+			if semver.Compare(m.Version, modules[i].Version) > 0 {
+				if !m.Replaced() {
+					m.ReplacePath, m.ReplaceVersion = modules[i].ReplacePath, modules[i].ReplaceVersion
+				}
+				modules[i] = m
+			}
+		}
+	}
+
+	if files.Work != nil {
+		for i, m := range modules {
+			for _, replace := range files.Work.Replace {
+				if replace.Old.Path == m.Path && (replace.Old.Version == "" || replace.Old.Version == m.Version) {
+					modules[i].ReplacePath, modules[i].ReplaceVersion = replace.New.Path, replace.New.Version
+				}
+			}
+		}
+	}
+	return modules
+}
+
+func (db *VulnDB) moduleVulnerabilities(path, version string) []Vulnerability {
+	var vulns []Vulnerability
+	seen := make(map[string]bool)
+	for _, entry := range db.byModule[path] {
+		for _, affected := range entry.Affected {
+			if affected.Package.Ecosystem != "Go" || affected.Package.Name != path || seen[entry.ID] {
+				continue
+			}
+			fixed, ok := affected.affects(version)
+			if !ok {
+				continue
+			}
+			seen[entry.ID] = true
+			vulns = append(vulns, Vulnerability{
+				ID:       entry.ID,
+				Aliases:  entry.Aliases,
+				Summary:  entry.Summary,
+				Module:   path,
+				Version:  version,
+				Fixed:    fixed,
+				Severity: entry.severity(affected),
+			})
+		}
+	}
+	return vulns
+}
+
+// affects returns whether version is affected, and the version it is fixed in, if any.
+// Range events are in ascending version order.
+func (a osvAffected) affects(version string) (string, bool) {
+	for _, v := range a.Versions {
+		if osvVersion(v) == version {
+			return "", true
+		}
+	}
+	for _, r := range a.Ranges {
+		if r.Type != "SEMVER" && r.Type != "ECOSYSTEM" {
+			continue
+		}
+		affected, fixed := false, ""
+		for _, e := range r.Events {
+			switch {
+			case e.Introduced != "":
+				if e.Introduced == "0" || semver.Compare(version, osvVersion(e.Introduced)) >= 0 {
+					affected, fixed = true, ""
+				}
+			case e.Fixed != "":
+				if semver.Compare(version, osvVersion(e.Fixed)) >= 0 {
+					affected = false
+				} else if affected && fixed == "" {
+					fixed = osvVersion(e.Fixed)
+				}
+			case e.LastAffected != "":
+				if semver.Compare(version, osvVersion(e.LastAffected)) > 0 {
+					affected = false
+				}
+			}
+		}
+		if affected {
+			return fixed, true
+		}
+	}
+	return "", false
+}
+
+// osvVersion returns the go module version of an OSV version, which has no v prefix.
+func osvVersion(v string) string {
+	if strings.HasPrefix(v, "v") {
+		return v
+	}
+	return "v" + v
+}
+
+// severity returns the severity rated by the database for the affected package or the entry,
+// falling back to the highest CVSS v3 base score.
+func (e *osvEntry) severity(affected osvAffected) Severity {
+	for _, rating := range []string{affected.DatabaseSpecific.Severity, e.DatabaseSpecific.Severity} {
+		if s, err := ParseSeverity(rating); err == nil && s > SeverityUnknown {
+			return s
+		}
+	}
+	severity := SeverityUnknown
+	for _, s := range append(affected.Severity, e.Severity...) {
+		if s.Type != "CVSS_V3" {
+			continue
+		}
+		score, err := cvss3BaseScore(s.Score)
+		if err != nil {
+			continue
+		}
+		severity = max(severity, cvssSeverity(score))
+	}
+	return severity
+}
+
+// cvssSeverity returns the qualitative severity of a CVSS base score.
+func cvssSeverity(score float64) Severity {
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	default:
+		return SeverityUnknown
+	}
+}
+
+var cvss3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"PR": {"N": 0.85, "L": 0.62, "H": 0.27},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// cvss3BaseScore returns the base score of a CVSS v3 vector, e.g. CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H.
+func cvss3BaseScore(vector string) (float64, error) {
+	parts := strings.Split(vector, "/")
+	if len(parts) == 0 || !strings.HasPrefix(parts[0], "CVSS:3") {
+		return 0, fmt.Errorf("not a CVSS v3 vector: %s", vector)
+	}
+	metrics := make(map[string]string)
+	for _, part := range parts[1:] {
+		k, v, ok := strings.Cut(part, ":")
+		if !ok {
+			return 0, fmt.Errorf("invalid CVSS metric %q", part)
+		}
+		metrics[k] = v
+	}
+	if metrics["S"] != "U" && metrics["S"] != "C" {
+		return 0, errors.New("missing or invalid CVSS base metric S")
+	}
+	scopeChanged := metrics["S"] == "C"
+	weights := make(map[string]float64)
+	for metric, values := range cvss3Weights {
+		w, ok := values[metrics[metric]]
+		if !ok {
+			return 0, errors.New("missing or invalid CVSS base metric " + metric)
+		}
+		weights[metric] = w
+	}
+	if scopeChanged {
+		// privileges required weigh more if the scope changes.
+		weights["PR"] = map[string]float64{"N": 0.85, "L": 0.68, "H": 0.5}[metrics["PR"]]
+	}
+
+	iss := 1 - (1-weights["C"])*(1-weights["I"])*(1-weights["A"])
+	impact := 6.42 * iss
+	if scopeChanged {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	if impact <= 0 {
+		return 0, nil
+	}
+	exploitability := 8.22 * weights["AV"] * weights["AC"] * weights["PR"] * weights["UI"]
+	if scopeChanged {
+		return cvssRoundUp(math.Min(1.08*(impact+exploitability), 10)), nil
+	}
+	return cvssRoundUp(math.Min(impact+exploitability, 10)), nil
+}
+
+// cvssRoundUp rounds up to one decimal, as specified by CVSS v3.1.
+func cvssRoundUp(x float64) float64 {
+	i := int(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return (math.Floor(float64(i)/10000) + 1) / 10
+}
+
+// VulnerabilitiesAtLeast returns the vulnerabilities with at least severity threshold, none if threshold is
+// SeverityNone.
+func VulnerabilitiesAtLeast(vulns []Vulnerability, threshold Severity) []Vulnerability {
+	if threshold == SeverityNone {
+		return nil
+	}
+	var found []Vulnerability
+	for _, v := range vulns {
+		if v.Severity >= threshold {
+			found = append(found, v)
+		}
+	}
+	return found
+}
@@ -0,0 +1,150 @@
+package builder_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/modfile"
+)
+
+func writeOSV(t *testing.T, dir, name, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+}
+
+func TestVulnDBAudit(t *testing.T) {
+	dir := t.TempDir()
+	// go vulndb entries have no severity.
+	writeOSV(t, dir, "ID/GO-2024-0001.json", `{
+  "id": "GO-2024-0001", "summary": "sdk bug", "aliases": ["CVE-2024-0001"],
+  "affected": [{"package": {"ecosystem": "Go", "name": "github.com/cosmos/cosmos-sdk"},
+    "ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "0.47.6"}, {"introduced": "0.50.0"}, {"fixed": "0.50.2"}]}]}]
+}`)
+	writeOSV(t, dir, "GHSA-aaaa.json", `{
+  "id": "GHSA-aaaa", "summary": "ibc bug",
+  "database_specific": {"severity": "HIGH"},
+  "affected": [{"package": {"ecosystem": "Go", "name": "github.com/cosmos/ibc-go/v8"},
+    "ranges": [{"type": "SEMVER", "events": [{"introduced": "8.0.0"}, {"last_affected": "8.1.0"}]}]}]
+}`)
+	writeOSV(t, dir, "GHSA-bbbb.json", `{
+  "id": "GHSA-bbbb", "summary": "fork bug",
+  "severity": [{"type": "CVSS_V3", "score": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"}],
+  "affected": [{"package": {"ecosystem": "Go", "name": "github.com/example/cometbft"}, "versions": ["0.38.2"]}]
+}`)
+	writeOSV(t, dir, "GHSA-cccc.json", `{
+  "id": "GHSA-cccc", "withdrawn": "2024-01-01T00:00:00Z",
+  "affected": [{"package": {"ecosystem": "Go", "name": "github.com/cosmos/ibc-go/v8"}, "versions": ["8.0.0"]}]
+}`)
+	writeOSV(t, dir, "index/modules.json", `[{"path": "github.com/cosmos/cosmos-sdk"}]`)
+
+	db, err := builder.LoadVulnDB(dir)
+	require.NoError(t, err)
+
+	modFile, err := modfile.Parse("go.mod", []byte(`module example.com/chain
+
+require (
+	github.com/cosmos/cosmos-sdk v0.50.1
+	github.com/cosmos/ibc-go/v8 v8.0.0
+	github.com/cometbft/cometbft v0.38.0
+)
+
+replace github.com/cometbft/cometbft => github.com/example/cometbft v0.38.2
+`), nil)
+	require.NoError(t, err)
+
+	vulns := db.Audit(builder.ChainModFiles{Mod: modFile}, "").Vulnerabilities
+	require.Equal(t, []builder.Vulnerability{
+		{ID: "GHSA-bbbb", Summary: "fork bug", Module: "github.com/example/cometbft", Version: "v0.38.2", Severity: builder.SeverityCritical},
+		{ID: "GHSA-aaaa", Summary: "ibc bug", Module: "github.com/cosmos/ibc-go/v8", Version: "v8.0.0", Severity: builder.SeverityHigh},
+		{ID: "GO-2024-0001", Aliases: []string{"CVE-2024-0001"}, Summary: "sdk bug", Module: "github.com/cosmos/cosmos-sdk", Version: "v0.50.1", Fixed: "v0.50.2", Severity: builder.SeverityUnknown},
+	}, vulns)
+
+	require.Len(t, builder.VulnerabilitiesAtLeast(vulns, builder.SeverityHigh), 2)
+	require.Len(t, builder.VulnerabilitiesAtLeast(vulns, builder.SeverityUnknown), 3)
+	require.Empty(t, builder.VulnerabilitiesAtLeast(vulns, builder.SeverityNone))
+
+	// fixed versions are not affected.
+	modFile, err = modfile.Parse("go.mod", []byte("module example.com/chain\n\nrequire github.com/cosmos/cosmos-sdk v0.47.6\n"), nil)
+	require.NoError(t, err)
+	require.Empty(t, db.Audit(builder.ChainModFiles{Mod: modFile}, "").Vulnerabilities)
+
+	_, err = builder.LoadVulnDB(t.TempDir())
+	require.ErrorContains(t, err, "no OSV entries of Go modules found")
+
+	// entries of other ecosystems are not counted.
+	otherDir := t.TempDir()
+	writeOSV(t, otherDir, "RUSTSEC-2024-0001.json", `{"id": "RUSTSEC-2024-0001", "affected": [{"package": {"ecosystem": "crates.io", "name": "tokio"}}]}`)
+	_, err = builder.LoadVulnDB(otherDir)
+	require.ErrorContains(t, err, "no OSV entries of Go modules found")
+}
+
+func TestVulnDBAuditWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	writeOSV(t, dir, "GO-2024-0002.json", `{
+  "id": "GO-2024-0002", "summary": "net/http bug",
+  "affected": [{"package": {"ecosystem": "Go", "name": "stdlib"},
+    "ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "1.22.5"}]}]}]
+}`)
+	writeOSV(t, dir, "GO-2024-0003.json", `{
+  "id": "GO-2024-0003", "summary": "store bug",
+  "affected": [{"package": {"ecosystem": "Go", "name": "cosmossdk.io/store"},
+    "ranges": [{"type": "SEMVER", "events": [{"introduced": "1.1.0"}, {"fixed": "1.1.1"}]}]}]
+}`)
+	db, err := builder.LoadVulnDB(dir)
+	require.NoError(t, err)
+
+	modFile, err := modfile.Parse("go.mod", []byte(`module example.com/chain
+
+require (
+	cosmossdk.io/store v1.0.0
+	example.com/chain/api v0.1.0
+)
+`), nil)
+	require.NoError(t, err)
+	member, err := modfile.Parse("api/go.mod", []byte(`module example.com/chain/api
+
+require (
+	cosmossdk.io/store v1.1.0
+	github.com/cometbft/cometbft v0.38.0
+)
+
+replace github.com/cometbft/cometbft => ../cometbft
+`), nil)
+	require.NoError(t, err)
+	work, err := modfile.ParseWork("go.work", []byte("go 1.22\n\nuse (\n\t.\n\t./api\n)\n"), nil)
+	require.NoError(t, err)
+
+	// the highest version required by the workspace is audited, the workspace modules are not.
+	report := db.Audit(builder.ChainModFiles{Mod: modFile, Work: work, Members: []*modfile.File{member}}, "1.22.4")
+	require.Equal(t, []builder.Vulnerability{
+		{ID: "GO-2024-0003", Summary: "store bug", Module: "cosmossdk.io/store", Version: "v1.1.0", Fixed: "v1.1.1", Severity: builder.SeverityUnknown},
+		{ID: "GO-2024-0002", Summary: "net/http bug", Module: "stdlib", Version: "v1.22.4", Fixed: "v1.22.5", Severity: builder.SeverityUnknown},
+	}, report.Vulnerabilities)
+	require.Equal(t, []builder.ModuleVersion{
+		{Path: "github.com/cometbft/cometbft", Version: "v0.38.0", ReplacePath: "../cometbft"},
+	}, report.Unaudited)
+
+	// fixed go versions are not affected.
+	report = db.Audit(builder.ChainModFiles{Mod: modFile, Work: work, Members: []*modfile.File{member}}, "1.22.5")
+	require.Len(t, report.Vulnerabilities, 1)
+	require.Equal(t, "cosmossdk.io/store", report.Vulnerabilities[0].Module)
+}
+
+func TestParseSeverity(t *testing.T) {
+	for s, want := range map[string]builder.Severity{
+		"":         builder.SeverityNone,
+		"any":      builder.SeverityUnknown,
+		"moderate": builder.SeverityMedium,
+		"HIGH":     builder.SeverityHigh,
+		"critical": builder.SeverityCritical,
+	} {
+		got, err := builder.ParseSeverity(s)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+	_, err := builder.ParseSeverity("severe")
+	require.Error(t, err)
+}
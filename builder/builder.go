@@ -2,29 +2,28 @@ package builder
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
-	"os/signal"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
-	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
-	"github.com/go-git/go-git/v5/storage/memory"
-	internalssh "golang.org/x/crypto/ssh"
 	"golang.org/x/mod/modfile"
 
 	"github.com/strangelove-ventures/heighliner/docker"
 	"github.com/strangelove-ventures/heighliner/dockerfile"
+	"github.com/strangelove-ventures/heighliner/github"
+	"github.com/strangelove-ventures/heighliner/releases"
 )
 
 type HeighlinerBuilder struct {
@@ -37,11 +36,8 @@ type HeighlinerBuilder struct {
 	buildIndex   int
 	buildIndexMu sync.Mutex
 
-	errors     []error
-	errorsLock sync.Mutex
-
-	tmpDirsToRemove map[string]bool
-	tmpDirMapMu     sync.Mutex
+	registry *docker.RegistryClient
+	skipped  BuildResults
 }
 
 func NewHeighlinerBuilder(
@@ -50,18 +46,43 @@ func NewHeighlinerBuilder(
 	local bool,
 	race bool,
 ) *HeighlinerBuilder {
-	return &HeighlinerBuilder{
+	if buildConfig.Backend == nil {
+		if buildConfig.UseBuildKit {
+			buildConfig.Backend = docker.NewBuildKitBuilder(buildConfig.BuildKitAddr)
+		} else {
+			buildConfig.Backend = docker.NewDockerBuilder("")
+		}
+	}
+	// the buildkit Dockerfiles and secret mounts are used if the backend supports them.
+	buildConfig.UseBuildKit = buildConfig.Backend.Capabilities().BuildKit
+	if buildConfig.Progress == nil {
+		buildConfig.Progress = os.Stdout
+	}
+
+	h := &HeighlinerBuilder{
 		buildConfig: buildConfig,
 		parallel:    parallel,
 		local:       local,
 		race:        race,
-
-		tmpDirsToRemove: make(map[string]bool),
 	}
+	if buildConfig.SkipExisting {
+		h.registry = docker.NewRegistryClient()
+	}
+	return h
 }
 
-func (h *HeighlinerBuilder) AddToQueue(chainBuilds ...HeighlinerQueuedChainBuilds) {
-	h.queue = append(h.queue, chainBuilds...)
+// AddToQueue queues the chain builds. If the build config has SkipExisting set,
+// builds whose image tags already exist in the container registry are not queued.
+// The registry lookups are cancelled with ctx.
+func (h *HeighlinerBuilder) AddToQueue(ctx context.Context, chainBuilds ...HeighlinerQueuedChainBuilds) {
+	for _, chainQueuedBuilds := range chainBuilds {
+		if h.skipExisting() {
+			var skipped BuildResults
+			chainQueuedBuilds, skipped = h.withoutExisting(ctx, chainQueuedBuilds)
+			h.skipped = append(h.skipped, skipped...)
+		}
+		h.queue = append(h.queue, chainQueuedBuilds)
+	}
 }
 
 func (h *HeighlinerBuilder) QueueLen() int {
@@ -88,38 +109,124 @@ func deriveTagFromRef(version string) string {
 	return strings.ReplaceAll(version, "/", "-")
 }
 
+// shortCommitLength is the length of abbreviated commits in image tags.
+const shortCommitLength = 7
+
+// revisionTag returns the image tag of a branch build at commit, e.g. main-1a2b3c4, or an empty string
+// if refName is not a branch.
+func revisionTag(refName plumbing.ReferenceName, commit string) string {
+	if !refName.IsBranch() || len(commit) < shortCommitLength {
+		return ""
+	}
+	return deriveTagFromRef(refName.Short()) + "-" + commit[:shortCommitLength]
+}
+
+// dockerfileType returns the dockerfile type for a chain config, replacing deprecated values.
+// If warn is set, deprecation warnings are written to it.
+func dockerfileType(build ChainNodeConfig, warn io.Writer) DockerfileType {
+	dockerfile := build.Dockerfile
+
+	// DEPRECATION HANDLING
+	if build.Language != "" {
+		if warn != nil {
+			fmt.Fprintf(warn, "'language' chain config property is deprecated, please use 'dockerfile' instead\n")
+		}
+		if dockerfile == "" {
+			dockerfile = build.Language
+		}
+	}
+
+	for _, rep := range deprecationReplacements {
+		if dockerfile == rep[0] {
+			if warn != nil {
+				fmt.Fprintf(warn, "'dockerfile' value of '%s' is deprecated, please use '%s' instead\n", rep[0], rep[1])
+			}
+			dockerfile = rep[1]
+		}
+	}
+	// END DEPRECATION HANDLING
+
+	return dockerfile
+}
+
+// imageName returns the docker image name for a chain, prefixed with the container registry if provided.
+func (h *HeighlinerBuilder) imageName(chainName string) string {
+	if h.buildConfig.ContainerRegistry == "" {
+		return chainName
+	}
+	return fmt.Sprintf("%s/%s", h.buildConfig.ContainerRegistry, chainName)
+}
+
+// imageTags returns the docker image tags for a chain build, with a -race suffix for race detector builds.
+// The revision tag of branch builds is added if not empty and no tag is requested.
+func (h *HeighlinerBuilder) imageTags(chainConfig *ChainNodeDockerBuildConfig, race bool, revisionTag string) []string {
+	imageName := h.imageName(chainConfig.Build.Name)
+	tag := imageTag(chainConfig.Ref, chainConfig.Tag, h.local)
+
+	imageTags := []string{fmt.Sprintf("%s:%s", imageName, tag)}
+	if revisionTag != "" && chainConfig.Tag == "" {
+		imageTags = append(imageTags, fmt.Sprintf("%s:%s", imageName, revisionTag))
+	}
+	if chainConfig.Latest {
+		imageTags = append(imageTags, fmt.Sprintf("%s:latest", imageName))
+	}
+	if race {
+		for i, imageTag := range imageTags {
+			imageTags[i] = imageTag + "-race"
+		}
+	}
+	return imageTags
+}
+
+// buildPlatforms returns the requested platforms (comma separated) which are supported by the chain.
+func buildPlatforms(build ChainNodeConfig, requested string) ([]string, error) {
+	requestedPlatforms := strings.Split(requested, ",")
+	if len(build.Platforms) == 0 {
+		return requestedPlatforms, nil
+	}
+	platforms := []string{}
+	for _, supportedPlatform := range build.Platforms {
+		for _, requestedPlatform := range requestedPlatforms {
+			if supportedPlatform == requestedPlatform {
+				platforms = append(platforms, requestedPlatform)
+			}
+		}
+	}
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("no requested platforms are supported for this chain: %s. requested: %s, supported: %s", build.Name, requested, strings.Join(build.Platforms, ","))
+	}
+	return platforms, nil
+}
+
 // dockerfileEmbeddedOrLocal attempts to find Dockerfile within current working directory.
-// Returns embedded Dockerfile if local file is not found or cannot be read.
-func dockerfileEmbeddedOrLocal(dockerfile string, embedded []byte) []byte {
+// Returns embedded Dockerfile if local file is not found or cannot be read,
+// along with a description of which Dockerfile is used.
+func dockerfileEmbeddedOrLocal(dockerfile string, embedded []byte) ([]byte, string) {
 	cwd, err := os.Getwd()
 	if err != nil {
-		fmt.Printf("Using embedded %s due to working directory not found\n", dockerfile)
-		return embedded
+		return embedded, fmt.Sprintf("embedded %s due to working directory not found", dockerfile)
 	}
 
 	absDockerfile := filepath.Join(cwd, "dockerfile", dockerfile)
 	if _, err := os.Stat(absDockerfile); err != nil {
-		fmt.Printf("Using embedded %s due to local dockerfile not found\n", dockerfile)
-		return embedded
+		return embedded, fmt.Sprintf("embedded %s due to local dockerfile not found", dockerfile)
 	}
 
 	df, err := os.ReadFile(absDockerfile)
 	if err != nil {
-		fmt.Printf("Using embedded %s due to failure to read local dockerfile\n", dockerfile)
-		return embedded
+		return embedded, fmt.Sprintf("embedded %s due to failure to read local dockerfile", dockerfile)
 	}
 
-	fmt.Printf("Using local %s\n", dockerfile)
-	return df
+	return df, "local " + dockerfile
 }
 
-// dockerfileAndTag returns the appropriate dockerfile as bytes and the docker image tag
-// based on the input configuration.
+// rawDockerfile returns the appropriate dockerfile as bytes based on the input configuration,
+// along with a description of which Dockerfile is used.
 func rawDockerfile(
 	dockerfileType DockerfileType,
 	useBuildKit bool,
 	local bool,
-) []byte {
+) ([]byte, string) {
 	switch dockerfileType {
 	case DockerfileTypeImported:
 		return dockerfileEmbeddedOrLocal("imported/Dockerfile", dockerfile.Imported)
@@ -135,7 +242,7 @@ func rawDockerfile(
 			if useBuildKit {
 				return dockerfileEmbeddedOrLocal("cosmos/localcross.Dockerfile", dockerfile.CosmosLocalCross)
 			}
-			return dockerfile.CosmosLocal
+			return dockerfile.CosmosLocal, "embedded cosmos/local.Dockerfile"
 		}
 		if useBuildKit {
 			return dockerfileEmbeddedOrLocal("cosmos/Dockerfile", dockerfile.Cosmos)
@@ -151,203 +258,202 @@ func rawDockerfile(
 	}
 }
 
-func getModFile(
-	repoHost string,
-	organization string,
-	repoName string,
-	cloneKey string,
-	ref string,
-	buildDir string,
-	local bool,
-) (*modfile.File, error) {
-	var goModBz []byte
-	var err error
+// gitURLScheme is the scheme of the urls of remote repos, replaced in tests to use local repos.
+var gitURLScheme = "https://"
 
-	goModPath := "go.mod"
-	if buildDir != "" {
-		goModPath = filepath.Join(buildDir, goModPath)
+// repoURL returns the url of the remote repo of a chain, an ssh url if auth is ssh auth.
+func repoURL(build ChainNodeConfig, repoHost string, auth transport.AuthMethod) string {
+	// ssh auth, from a clone key or ssh-agent, needs an ssh url
+	if _, ok := auth.(ssh.AuthMethod); ok {
+		return fmt.Sprintf("git@%s:%s/%s.git", repoHost, build.GithubOrganization, build.GithubRepo)
 	}
+	return fmt.Sprintf("%s%s/%s/%s", gitURLScheme, repoHost, build.GithubOrganization, build.GithubRepo)
+}
 
+// repoFilesystem returns the files of the repo at ref, along with the commit and the name of the reference
+// ref resolves to, or the current working directory and its checked out commit, if any, for local builds.
+// Files of remote repos are fetched when read.
+func repoFilesystem(
+	ctx context.Context,
+	progress io.Writer,
+	build ChainNodeConfig,
+	repoHost string,
+	auth transport.AuthMethod,
+	ref string,
+	local bool,
+) (fs.FS, string, plumbing.ReferenceName, error) {
 	if local {
-		goModBz, err = os.ReadFile(goModPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read %s for local build: %w", goModPath, err)
-		}
-	} else {
-		// single branch depth 1 clone to only fetch most recent state of files
-		cloneOpts := &git.CloneOptions{
-			URL:          fmt.Sprintf("https://%s/%s/%s", repoHost, organization, repoName),
-			SingleBranch: true,
-			Depth:        1,
-		}
-		// Try as tag ref first
-		cloneOpts.ReferenceName = plumbing.NewTagReferenceName(ref)
-		// if there is a clone key, decode and use it to authenticate
-		if cloneKey != "" {
-			cloneKeyBz, err := base64.StdEncoding.DecodeString(cloneKey)
-			if err != nil {
-				return nil, errors.New("failed to decode clone key")
+		commit := ""
+		if repo, err := git.PlainOpenWithOptions(".", &git.PlainOpenOptions{DetectDotGit: true}); err == nil {
+			if head, err := repo.Head(); err == nil {
+				commit = head.Hash().String()
 			}
-
-			key, err := ssh.NewPublicKeys("git", cloneKeyBz, "")
-			if err != nil {
-				return nil, errors.New("failed to generate public key")
-			}
-			key.HostKeyCallback = internalssh.InsecureIgnoreHostKey()
-
-			cloneOpts.URL = fmt.Sprintf("git@%s:%s/%s.git", repoHost, organization, repoName)
-			cloneOpts.Auth = key
-		}
-
-		// Clone into memory
-		fs := memfs.New()
-
-		_, err = git.Clone(memory.NewStorage(), fs, cloneOpts)
-		if err != nil {
-			// In error case, try as branch ref
-			cloneOpts.ReferenceName = plumbing.NewBranchReferenceName(ref)
-
-			_, err := git.Clone(memory.NewStorage(), fs, cloneOpts)
-			if err != nil {
-				return nil, fmt.Errorf("failed to clone go.mod file to determine go version: %w", err)
-			}
-		}
-
-		goModFile, err := fs.Open(goModPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open go.mod file: %w", err)
-		}
-
-		goModBz, err = io.ReadAll(goModFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read go.mod file: %w", err)
 		}
+		return os.DirFS("."), commit, "", nil
 	}
 
-	goMod, err := modfile.Parse("go.mod", goModBz, nil)
+	url := repoURL(build, repoHost, auth)
+	commit, refName, err := ResolveRef(ctx, url, auth, ref)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse go.mod file: %w", err)
+		return nil, "", "", fmt.Errorf("failed to resolve ref %s: %w", ref, err)
 	}
 
-	return goMod, nil
-}
-
-func trimWasmvmVersionSuffix(repo string) string {
-	repo = strings.TrimSuffix(repo, "/v2")
-	repo = strings.TrimSuffix(repo, "/v3")
-	return repo
-}
-
-// getWasmvmVersion will get the wasmvm version from the mod file
-func getWasmvmVersion(modFile *modfile.File) string {
-	const defaultWasmvmRepo = "github.com/CosmWasm/wasmvm"
-	wasmvmRepo := defaultWasmvmRepo
-	wasmvmVersion := ""
-
-	// First check all the "requires"
-	for _, item := range modFile.Require {
-		// Must have 2 tokens, repo & version
-		if (len(item.Syntax.Token) == 2) && (strings.Contains(item.Syntax.Token[0], wasmvmRepo)) {
-			wasmvmRepo = trimWasmvmVersionSuffix(item.Syntax.Token[0])
-			wasmvmVersion = item.Syntax.Token[1]
+	files := &RepoFiles{URL: url, Auth: auth, Commit: commit, CacheDir: RepoFilesCacheDir(), Progress: progress}
+	// raw file urls of known hosts only serve public repos, private repos are fetched with git.
+	if auth == nil {
+		files.RawURL = rawFileURL(build, repoHost, commit)
+		// github.com raw files are fetched with the shared github client, for its authentication,
+		// rate limit handling and response cache.
+		if strings.HasPrefix(files.RawURL, github.DefaultRawURL+"/") {
+			files.GitHub = github.Shared(github.DefaultAPIURL)
 		}
 	}
+	return files.FS(ctx), commit, refName, nil
+}
 
-	// Then, check all the "replaces"
-	for _, item := range modFile.Replace {
-		// Must have 3 or more tokens
-		if (len(item.Syntax.Token) > 2) && (strings.Contains(item.Syntax.Token[0], wasmvmRepo)) {
-			wasmvmRepo = trimWasmvmVersionSuffix(item.Syntax.Token[len(item.Syntax.Token)-2])
-			wasmvmVersion = item.Syntax.Token[len(item.Syntax.Token)-1]
-		}
-	}
-
-	fmt.Printf("WasmVM from go.mod: repo: %s, version: %s\n", wasmvmRepo, wasmvmVersion)
-
-	if wasmvmVersion == "" {
+// rawFileURL returns the url of the raw files of the repo at commit, or an empty string if the host type
+// has no raw file urls.
+func rawFileURL(build ChainNodeConfig, repoHost, commit string) string {
+	hostType := build.RepoType
+	if hostType == "" {
+		hostType = releases.DefaultHostType(repoHost)
+	}
+	repo := build.GithubOrganization + "/" + build.GithubRepo
+	switch {
+	case hostType == releases.HostTypeGitHub && repoHost == "github.com":
+		return github.Shared(github.DefaultAPIURL).RawFileURL(build.GithubOrganization, build.GithubRepo, commit)
+	case hostType == releases.HostTypeGitLab:
+		return fmt.Sprintf("https://%s/%s/-/raw/%s", repoHost, repo, commit)
+	case hostType == releases.HostTypeGitea:
+		return fmt.Sprintf("https://%s/%s/raw/commit/%s", repoHost, repo, commit)
+	default:
 		return ""
 	}
-
-	return wasmvmRepo + " " + wasmvmVersion
 }
 
-// buildChainNodeDockerImage builds the requested chain node docker image
-// based on the input configuration.
-func (h *HeighlinerBuilder) buildChainNodeDockerImage(
-	chainConfig *ChainNodeDockerBuildConfig,
-) error {
-	buildCfg := h.buildConfig
-	dockerfile := chainConfig.Build.Dockerfile
-
-	// DEPRECATION HANDLING
-	if chainConfig.Build.Language != "" {
-		fmt.Printf("'language' chain config property is deprecated, please use 'dockerfile' instead\n")
-		if dockerfile == "" {
-			dockerfile = chainConfig.Build.Language
-		}
+// ReleaseSource returns the source of the releases of the chain. Releases of git hosts are listed with the
+// clone key of the chain or secrets, or with the git token, so that private repositories can be built.
+func ReleaseSource(chain ChainNodeConfig, secrets BuildSecrets) (releases.Source, error) {
+	repoHost := chain.RepoHost
+	if repoHost == "" {
+		repoHost = "github.com"
 	}
-
-	for _, rep := range deprecationReplacements {
-		if dockerfile == rep[0] {
-			fmt.Printf("'dockerfile' value of '%s' is deprecated, please use '%s' instead\n", rep[0], rep[1])
-			dockerfile = rep[1]
+	hostType := chain.RepoType
+	if hostType == "" {
+		hostType = releases.DefaultHostType(repoHost)
+	}
+	var auth transport.AuthMethod
+	if hostType == releases.HostTypeGit {
+		var err error
+		if auth, err = secrets.withChainConfig(chain).gitAuth(repoHost, &knownHosts{}); err != nil {
+			return nil, err
 		}
 	}
-	// END DEPRECATION HANDLING
+	return releases.NewSource(hostType, repoHost, auth)
+}
 
-	df := rawDockerfile(dockerfile, buildCfg.UseBuildKit, h.local)
+// getModFiles returns the go.mod of the build dir, the go.work at the root of the repo and the go.mod files
+// of its members. The go.work is nil if the repo does not have one.
+func getModFiles(repoFS fs.FS, buildDir string) (ChainModFiles, error) {
+	goModPath := path.Join(buildDir, "go.mod")
 
-	tag := imageTag(chainConfig.Ref, chainConfig.Tag, h.local)
-
-	cwd, err := os.Getwd()
+	goModBz, err := fs.ReadFile(repoFS, goModPath)
 	if err != nil {
-		return fmt.Errorf("error getting working directory: %w", err)
+		return ChainModFiles{}, fmt.Errorf("failed to read %s file: %w", goModPath, err)
 	}
-
-	dir, err := os.MkdirTemp(cwd, "heighliner")
+	goMod, err := modfile.Parse("go.mod", goModBz, nil)
 	if err != nil {
-		return fmt.Errorf("error making temporary directory for dockerfile: %w", err)
+		return ChainModFiles{}, fmt.Errorf("failed to parse go.mod file: %w", err)
 	}
 
-	// queue removal on ctrl+c
-	h.queueTmpDirRemoval(dir, true)
-	defer func() {
-		// this build is done, so don't need removal on ctrl+c anymore since we are removing now.
-		h.queueTmpDirRemoval(dir, false)
-		_ = os.RemoveAll(dir)
-	}()
-
-	reldir, err := filepath.Rel(cwd, dir)
+	goWorkBz, err := fs.ReadFile(repoFS, "go.work")
+	if errors.Is(err, fs.ErrNotExist) {
+		return ChainModFiles{Mod: goMod}, nil
+	}
 	if err != nil {
-		return fmt.Errorf("error finding relative path for dockerfile working directory: %w", err)
+		return ChainModFiles{}, fmt.Errorf("failed to read go.work file: %w", err)
 	}
-
-	dfilepath := filepath.Join(reldir, "Dockerfile")
-	if err := os.WriteFile(dfilepath, df, 0644); err != nil {
-		return fmt.Errorf("error writing temporary dockerfile: %w", err)
+	goWork, err := modfile.ParseWork("go.work", goWorkBz, nil)
+	if err != nil {
+		return ChainModFiles{}, fmt.Errorf("failed to parse go.work file: %w", err)
 	}
 
-	var imageName string
-	if buildCfg.ContainerRegistry == "" {
-		imageName = chainConfig.Build.Name
-	} else {
-		imageName = fmt.Sprintf("%s/%s", buildCfg.ContainerRegistry, chainConfig.Build.Name)
+	files := ChainModFiles{Mod: goMod, Work: goWork}
+	for _, use := range goWork.Use {
+		dir := path.Clean(use.Path)
+		// members outside of the repo are not in the build context, and the build dir is already read.
+		if !fs.ValidPath(dir) || dir == path.Clean(buildDir) {
+			continue
+		}
+		memberPath := path.Join(dir, "go.mod")
+		bz, err := fs.ReadFile(repoFS, memberPath)
+		if err != nil {
+			return ChainModFiles{}, fmt.Errorf("failed to read go.work member %s file: %w", memberPath, err)
+		}
+		member, err := modfile.Parse(memberPath, bz, nil)
+		if err != nil {
+			return ChainModFiles{}, fmt.Errorf("failed to parse go.work member %s file: %w", memberPath, err)
+		}
+		files.Members = append(files.Members, member)
 	}
+	return files, nil
+}
 
-	imageTags := []string{fmt.Sprintf("%s:%s", imageName, tag)}
-	if chainConfig.Latest {
-		imageTags = append(imageTags, fmt.Sprintf("%s:latest", imageName))
+// firstVersion returns the first set version of candidates, which are pairs of a version and where it is
+// configured, along with where it is configured. Returns empty strings if no version is set.
+func firstVersion(candidates ...[2]string) (version string, source string) {
+	for _, c := range candidates {
+		if c[0] != "" {
+			return c[0], c[1]
+		}
 	}
+	return "", ""
+}
 
-	buildFrom := "ref: " + chainConfig.Ref
-	if h.local {
-		buildFrom = "current working directory source"
+// resolveBuild resolves everything needed to build the requested chain node docker image,
+// without connecting to docker or buildkit. If warn is set, deprecated config values and
+// insecure secrets are warned about on it.
+func (h *HeighlinerBuilder) resolveBuild(
+	ctx context.Context,
+	chainConfig *ChainNodeDockerBuildConfig,
+	warn io.Writer,
+) (*BuildPlan, error) {
+	buildCfg := h.buildConfig
+	build := chainConfig.Build
+	dockerfile := dockerfileType(build, warn)
+	for _, constraint := range chainConfig.VersionConstraints {
+		fmt.Fprintf(buildCfg.Progress, "Using %s build config for versions %s for ref: %s\n", build.Name, constraint, chainConfig.Ref)
+	}
+
+	plan := &BuildPlan{
+		Chain:          build.Name,
+		Ref:            chainConfig.Ref,
+		Tag:            imageTag(chainConfig.Ref, chainConfig.Tag, h.local),
+		DockerfileType: dockerfile,
+		UseBuildKit:    buildCfg.UseBuildKit,
+		Builder:        buildCfg.Backend.Name(),
+		Push:           buildCfg.ContainerRegistry != "" && !buildCfg.SkipPush,
+		Tags:           h.imageTags(chainConfig, false, ""),
+		Secrets:        buildCfg.Secrets.withChainConfig(build),
+	}
+	plan.Dockerfile, plan.DockerfileSource = rawDockerfile(dockerfile, buildCfg.UseBuildKit, h.local)
+
+	plan.MultiPlatform = buildCfg.Backend.Capabilities().MultiPlatform
+	if plan.MultiPlatform {
+		platforms, err := buildPlatforms(build, buildCfg.Platform)
+		if err != nil {
+			return plan, err
+		}
+		plan.Platforms = platforms
+	}
+	if err := docker.CheckCapabilities(buildCfg.Backend, docker.BuildRequest{Platforms: plan.Platforms, TarExport: buildCfg.TarExportPath}); err != nil {
+		return plan, err
 	}
 
 	buildEnv := ""
 
 	buildTagsEnvVar := ""
-	for _, envVar := range chainConfig.Build.BuildEnv {
+	for _, envVar := range build.BuildEnv {
 		envVarSplit := strings.Split(envVar, "=")
 		if envVarSplit[0] == "BUILD_TAGS" {
 			buildTagsEnvVar = envVar
@@ -356,15 +462,15 @@ func (h *HeighlinerBuilder) buildChainNodeDockerImage(
 		}
 	}
 
-	binaries := strings.Join(chainConfig.Build.Binaries, ",")
+	binaries := strings.Join(build.Binaries, ",")
 
-	libraries := strings.Join(chainConfig.Build.Libraries, " ")
+	libraries := strings.Join(build.Libraries, " ")
 
-	targetLibraries := strings.Join(chainConfig.Build.TargetLibraries, " ")
+	targetLibraries := strings.Join(build.TargetLibraries, " ")
 
-	directories := strings.Join(chainConfig.Build.Directories, " ")
+	directories := strings.Join(build.Directories, " ")
 
-	repoHost := chainConfig.Build.RepoHost
+	repoHost := build.RepoHost
 	if repoHost == "" {
 		repoHost = "github.com"
 	}
@@ -375,45 +481,103 @@ func (h *HeighlinerBuilder) buildChainNodeDockerImage(
 	}
 
 	var gv GoVersion
-	var wasmvmVersion string
+	nativeBuildArgs := make(map[string]string)
 	race := ""
 
-	modFile, err := getModFile(
-		repoHost, chainConfig.Build.GithubOrganization, chainConfig.Build.GithubRepo,
-		chainConfig.Build.CloneKey, chainConfig.Ref, chainConfig.Build.BuildDir, h.local,
-	)
+	var auth transport.AuthMethod
+	verifiedHosts := &knownHosts{}
+	if !h.local {
+		var err error
+		if auth, err = plan.Secrets.gitAuth(repoHost, verifiedHosts); err != nil {
+			return plan, err
+		}
+	}
+
+	repoFS, commit, refName, repoErr := repoFilesystem(ctx, buildCfg.Progress, build, repoHost, auth, chainConfig.Ref, h.local)
+	plan.Commit = commit
+	plan.KnownHosts = verifiedHosts.String()
+	revTag := revisionTag(refName, commit)
+	plan.Tags = h.imageTags(chainConfig, false, revTag)
+	var modFiles ChainModFiles
+	err := repoErr
+	if err == nil {
+		modFiles, err = getModFiles(repoFS, build.BuildDir)
+	}
+	modFile := modFiles.Mod
 
-	goVersion := buildCfg.GoVersion
-	if goVersion == "" && err == nil {
-		goVersion = modFile.Go.Version
+	if err == nil {
+		plan.GoModVersion, plan.GoModVersionSource = ResolveGoVersion(modFile, modFiles.Work)
+	}
+	// flags take precedence over the chain config, which takes precedence over go.mod and go.work.
+	goVersion, goVersionSource := firstVersion(
+		[2]string{buildCfg.GoVersion, "--go-version flag"},
+		[2]string{build.GoVersion, "chain config go-version"},
+		[2]string{plan.GoModVersion, plan.GoModVersionSource},
+	)
+	alpineVersion, alpineVersionSource := firstVersion(
+		[2]string{buildCfg.AlpineVersion, "--alpine-version flag"},
+		[2]string{build.AlpineVersion, "chain config alpine-version"},
+	)
+	if alpineVersionSource == "" {
+		alpineVersionSource = "go version catalog"
 	}
 	if goVersion != "" {
-		gv = GetImageAndVersionForGoVersion(goVersion, buildCfg.AlpineVersion)
+		gv = GetImageAndVersionForGoVersion(goVersion, alpineVersion)
+		plan.GoVersion = gv
+		plan.GoVersionSource = goVersionSource
+		plan.AlpineVersionSource = alpineVersionSource
+	}
+	if err == nil && buildCfg.VulnDB != nil {
+		// the standard library is audited at the go version of the build image, if resolved.
+		audit := buildCfg.VulnDB.Audit(modFiles, gv.Version)
+		plan.Vulnerabilities, plan.UnauditedModules = audit.Vulnerabilities, audit.Unaudited
+	}
+	var repoRustToolchain, repoRustToolchainSource string
+	if dockerfile == DockerfileTypeCargo && repoErr == nil {
+		repoRustToolchain, repoRustToolchainSource, err = FindRustToolchain(repoFS, build.BuildDir)
+		if err != nil {
+			return plan, fmt.Errorf("error getting rust toolchain: %w", err)
+		}
+	}
+	// flags take precedence over the chain config, which takes precedence over the repo toolchain file.
+	plan.RustToolchain, plan.RustToolchainSource = firstVersion(
+		[2]string{buildCfg.RustToolchain, "--rust-toolchain flag"},
+		[2]string{build.RustToolchain, "chain config rust-toolchain"},
+		[2]string{repoRustToolchain, repoRustToolchainSource},
+	)
+	rustVersion := ""
+	if dockerfile == DockerfileTypeCargo {
+		rustVersion = RustImageVersion(plan.RustToolchain)
+		plan.RustImage = "rust:" + rustVersion + "-bullseye"
 	}
 
 	if dockerfile == DockerfileTypeCosmos || dockerfile == DockerfileTypeAvalanche {
 		if err != nil {
-			return fmt.Errorf("error getting mod file: %w", err)
+			return plan, fmt.Errorf("error getting mod file: %w", err)
 		}
 
-		wasmvmVersion = getWasmvmVersion(modFile)
+		plan.NativeDependencies, err = DetectNativeDependencies(ctx, modFile, build.NativeDeps, build.NativeDepsUnverified)
+		if err != nil {
+			return plan, err
+		}
+		for _, dep := range plan.NativeDependencies {
+			for k, v := range dep.BuildArgs {
+				nativeBuildArgs[k] = v
+			}
+		}
+		plan.WasmvmVersion = nativeBuildArgs["WASMVM_VERSION"]
 
 		if h.race {
 			race = "true"
 			buildEnv += " GOFLAGS=-race"
-			for i, imageTag := range imageTags {
-				imageTags[i] = imageTag + "-race"
-			}
+			plan.Tags = h.imageTags(chainConfig, true, revTag)
 		}
-
-		fmt.Printf("Go version from go.mod: %s, will build with version: %s image: %s\n", modFile.Go.Version, gv.Version, gv.Image)
 	}
 
-	fmt.Printf("Building image from %s, resulting docker image tags: +%v\n", buildFrom, imageTags)
-
 	// If build dir is empty, add a "." for dockerfile compatibility
-	if chainConfig.Build.BuildDir == "" {
-		chainConfig.Build.BuildDir = "."
+	buildDir := build.BuildDir
+	if buildDir == "" {
+		buildDir = "."
 	}
 
 	vendor := "false"
@@ -421,68 +585,159 @@ func (h *HeighlinerBuilder) buildChainNodeDockerImage(
 		vendor = "true"
 	}
 
-	buildArgs := map[string]string{
+	// the caches are derived from the final tags, including the revision and -race tags.
+	if buildCfg.Backend.Capabilities().Cache {
+		plan.CacheFrom, plan.CacheTo = h.buildCache(build, plan, warn)
+	}
+
+	// commit refs, including abbreviated ones, are fetched by the resolved commit, there is no branch or tag to clone.
+	fetchCommit := ""
+	if repoErr == nil && refName == "" && plan.Commit != "" && !h.local {
+		fetchCommit = "true"
+	}
+
+	plan.BuildArgs = map[string]string{
 		"VERSION":             chainConfig.Ref,
+		"COMMIT":              plan.Commit,
+		"FETCH_COMMIT":        fetchCommit,
+		"KNOWN_HOSTS":         plan.KnownHosts,
 		"BASE_VERSION":        gv.Image,
-		"NAME":                chainConfig.Build.Name,
-		"BASE_IMAGE":          chainConfig.Build.BaseImage,
+		"NAME":                build.Name,
+		"BASE_IMAGE":          build.BaseImage,
 		"REPO_HOST":           repoHost,
-		"GITHUB_ORGANIZATION": chainConfig.Build.GithubOrganization,
-		"GITHUB_REPO":         chainConfig.Build.GithubRepo,
-		"CLONE_KEY":           chainConfig.Build.CloneKey,
-		"BUILD_TARGET":        chainConfig.Build.BuildTarget,
+		"GITHUB_ORGANIZATION": build.GithubOrganization,
+		"GITHUB_REPO":         build.GithubRepo,
+		"BUILD_TARGET":        build.BuildTarget,
 		"BINARIES":            binaries,
 		"LIBRARIES":           libraries,
 		"TARGET_LIBRARIES":    targetLibraries,
 		"DIRECTORIES":         directories,
-		"PRE_BUILD":           chainConfig.Build.PreBuild,
-		"FINAL_IMAGE":         chainConfig.Build.FinalImage,
+		"PRE_BUILD":           build.PreBuild,
+		"FINAL_IMAGE":         build.FinalImage,
 		"BUILD_ENV":           buildEnv,
 		"BUILD_TAGS":          buildTagsEnvVar,
-		"BUILD_DIR":           chainConfig.Build.BuildDir,
+		"BUILD_DIR":           buildDir,
 		"VENDOR":              vendor,
 		"BUILD_TIMESTAMP":     buildTimestamp,
 		"GO_VERSION":          gv.Version,
-		"WASMVM_VERSION":      wasmvmVersion,
+		"RUST_TOOLCHAIN":      plan.RustToolchain,
+		"RUST_VERSION":        rustVersion,
+		"NATIVE_DOWNLOADS":    nativeDownloadsBuildArg(plan.NativeDependencies),
 		"RACE":                race,
 	}
+	for k, v := range nativeBuildArgs {
+		plan.BuildArgs[k] = v
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Minute*180))
-	defer cancel()
+	// buildkit builds receive secrets as mounts, native builds only support the clone key as a build arg.
+	if !buildCfg.UseBuildKit {
+		cloneKey, err := plan.Secrets.buildArgCloneKey(warn)
+		if err != nil {
+			return plan, err
+		}
+		plan.BuildArgs["CLONE_KEY"] = cloneKey
+	}
 
-	push := buildCfg.ContainerRegistry != "" && !buildCfg.SkipPush
+	return plan, nil
+}
 
+// buildChainNodeDockerImage builds the requested chain node docker image
+// based on the input configuration.
+func (h *HeighlinerBuilder) buildChainNodeDockerImage(
+	ctx context.Context,
+	chainConfig *ChainNodeDockerBuildConfig,
+	result *BuildResult,
+) error {
+	progress := h.buildConfig.Progress
+	plan, err := h.resolveBuild(ctx, chainConfig, progress)
+	if err != nil {
+		return err
+	}
+
+	result.Tags = plan.Tags
+	result.Platforms = plan.Platforms
+	result.Pushed = plan.Push
+	result.Commit = plan.Commit
+	result.GoVersion = plan.GoVersion.Version
+	result.WasmvmVersion = plan.WasmvmVersion
+
+	fmt.Fprintf(progress, "Using %s\n", plan.DockerfileSource)
+	for _, line := range plan.toolchainSummary() {
+		fmt.Fprintln(progress, line)
+	}
+	if failing := VulnerabilitiesAtLeast(plan.Vulnerabilities, h.buildConfig.FailOnSeverity); len(failing) > 0 {
+		return fmt.Errorf("%d vulnerabilities of severity %s or higher in go.mod, e.g. %s in %s %s",
+			len(failing), h.buildConfig.FailOnSeverity, failing[0].ID, failing[0].Module, failing[0].Version)
+	}
+
+	buildFrom := "ref: " + chainConfig.Ref
+	if plan.Commit != "" && plan.Commit != chainConfig.Ref {
+		buildFrom += " (commit " + plan.Commit + ")"
+	}
+	if h.local {
+		buildFrom = "current working directory source"
+	}
+	fmt.Fprintf(progress, "Building image from %s with %s, resulting docker image tags: +%v\n", buildFrom, plan.Builder, plan.Tags)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("error getting working directory: %w", err)
+	}
+
+	dir, err := os.MkdirTemp(cwd, "heighliner")
+	if err != nil {
+		return fmt.Errorf("error making temporary directory for dockerfile: %w", err)
+	}
+
+	// removed when the build is done, including when cancelled.
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	reldir, err := filepath.Rel(cwd, dir)
+	if err != nil {
+		return fmt.Errorf("error finding relative path for dockerfile working directory: %w", err)
+	}
+
+	dfilepath := filepath.Join(reldir, "Dockerfile")
+	if err := os.WriteFile(dfilepath, plan.Dockerfile, 0644); err != nil {
+		return fmt.Errorf("error writing temporary dockerfile: %w", err)
+	}
+
+	buildCfg := h.buildConfig
+	if buildCfg.BuildTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, buildCfg.BuildTimeout)
+		defer cancel()
+	}
+
+	req := docker.BuildRequest{
+		Dockerfile: dfilepath,
+		Tags:       plan.Tags,
+		Push:       plan.Push,
+		TarExport:  buildCfg.TarExportPath,
+		BuildArgs:  plan.BuildArgs,
+		Platforms:  plan.Platforms,
+		NoCache:    buildCfg.NoCache,
+		CacheFrom:  plan.CacheFrom,
+		CacheTo:    plan.CacheTo,
+		Progress:   progress,
+	}
 	if buildCfg.UseBuildKit {
-		buildKitOptions := docker.GetDefaultBuildKitOptions()
-		buildKitOptions.Address = buildCfg.BuildKitAddr
-		supportedPlatforms := chainConfig.Build.Platforms
-
-		if len(supportedPlatforms) > 0 {
-			platforms := []string{}
-			requestedPlatforms := strings.Split(buildCfg.Platform, ",")
-			for _, supportedPlatform := range supportedPlatforms {
-				for _, requestedPlatform := range requestedPlatforms {
-					if supportedPlatform == requestedPlatform {
-						platforms = append(platforms, requestedPlatform)
-					}
-				}
-			}
-			if len(platforms) == 0 {
-				return fmt.Errorf("no requested platforms are supported for this chain: %s. requested: %s, supported: %s", chainConfig.Build.Name, buildCfg.Platform, strings.Join(supportedPlatforms, ","))
-			}
-			buildKitOptions.Platform = strings.Join(platforms, ",")
-		} else {
-			buildKitOptions.Platform = buildCfg.Platform
-		}
-		buildKitOptions.NoCache = buildCfg.NoCache
-		if err := docker.BuildDockerImageWithBuildKit(ctx, reldir, imageTags, push, buildCfg.TarExportPath, buildArgs, buildKitOptions); err != nil {
-			return err
-		}
-	} else {
-		if err := docker.BuildDockerImage(ctx, dfilepath, imageTags, push, buildArgs, buildCfg.NoCache); err != nil {
+		sshPaths, secrets, cleanup, err := plan.Secrets.buildKit(plan.BuildArgs["REPO_HOST"])
+		if err != nil {
 			return err
 		}
+		defer cleanup()
+		req.SSH = sshPaths
+		req.Secrets = secrets
 	}
+	image, err := buildCfg.Backend.Build(ctx, req)
+	if err != nil {
+		return err
+	}
+	result.Digest = image.Digest
+	result.PlatformDigests = image.PlatformDigests
 	return nil
 }
 
@@ -490,83 +745,60 @@ func (h *HeighlinerBuilder) buildChainNodeDockerImage(
 func (h *HeighlinerBuilder) getNextQueueItem() *ChainNodeDockerBuildConfig {
 	h.buildIndexMu.Lock()
 	defer h.buildIndexMu.Unlock()
-	j := 0
-	for i := 0; true; i++ {
-		foundForThisIndex := false
-		for _, queuedChainBuilds := range h.queue {
-			if i < len(queuedChainBuilds.ChainConfigs) {
-				if j == h.buildIndex {
-					h.buildIndex++
-					return &queuedChainBuilds.ChainConfigs[i]
-				}
-				j++
-				foundForThisIndex = true
-			}
-		}
-		if !foundForThisIndex {
-			// all done
-			return nil
-		}
+	queue := h.queueOrder()
+	if h.buildIndex >= len(queue) {
+		// all done
+		return nil
 	}
-	return nil
+	h.buildIndex++
+	return queue[h.buildIndex-1]
 }
 
-func (h *HeighlinerBuilder) buildNextImage(wg *sync.WaitGroup) {
-	chainConfig := h.getNextQueueItem()
-	if chainConfig == nil {
-		wg.Done()
-		return
+// buildImage builds a queued chain node docker image and returns the outcome.
+func (h *HeighlinerBuilder) buildImage(ctx context.Context, chainConfig *ChainNodeDockerBuildConfig) BuildResult {
+	result := BuildResult{
+		Chain: chainConfig.Build.Name,
+		Ref:   chainConfig.Ref,
 	}
-
-	go func() {
-		if err := h.buildChainNodeDockerImage(chainConfig); err != nil {
-			h.errorsLock.Lock()
-			h.errors = append(h.errors, fmt.Errorf("error building docker image for %s from ref: %s - %v\n", chainConfig.Build.Name, chainConfig.Ref, err))
-			h.errorsLock.Unlock()
-		}
-		h.buildNextImage(wg)
-	}()
-}
-
-func (h *HeighlinerBuilder) queueTmpDirRemoval(tmpDir string, start bool) {
-	h.tmpDirMapMu.Lock()
-	defer h.tmpDirMapMu.Unlock()
-	if start {
-		h.tmpDirsToRemove[tmpDir] = true
-	} else {
-		delete(h.tmpDirsToRemove, tmpDir)
+	start := time.Now()
+	if err := h.buildChainNodeDockerImage(ctx, chainConfig, &result); err != nil {
+		result.Err = fmt.Errorf("error building docker image for %s from ref: %s - %w", chainConfig.Build.Name, chainConfig.Ref, err)
 	}
+	result.Duration = time.Since(start)
+	return result
 }
 
-// registerSigIntHandler will delete tmp dirs on ctrl+c
-func (h *HeighlinerBuilder) registerSigIntHandler() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		h.tmpDirMapMu.Lock()
-		defer h.tmpDirMapMu.Unlock()
-		for dir := range h.tmpDirsToRemove {
-			_ = os.RemoveAll(dir)
-		}
-
-		os.Exit(1)
-	}()
-}
-
-func (h *HeighlinerBuilder) BuildImages() {
-	h.registerSigIntHandler()
+// BuildImages builds all queued images, running up to parallel builds at once.
+// Builds which have not started when ctx is cancelled are not started, and cancellation is
+// propagated to running builds. A result is returned for every queued build and for every
+// build skipped because its image already exists, along with an error joining the errors of all failed builds.
+func (h *HeighlinerBuilder) BuildImages(ctx context.Context) (BuildResults, error) {
+	results := append(BuildResults(nil), h.skipped...)
+	var resultsMu sync.Mutex
 
 	wg := new(sync.WaitGroup)
 	for i := int16(0); i < h.parallel; i++ {
 		wg.Add(1)
-		h.buildNextImage(wg)
+		go func() {
+			defer wg.Done()
+			for chainConfig := h.getNextQueueItem(); chainConfig != nil; chainConfig = h.getNextQueueItem() {
+				var result BuildResult
+				if err := ctx.Err(); err != nil {
+					result = BuildResult{
+						Chain: chainConfig.Build.Name,
+						Ref:   chainConfig.Ref,
+						Err:   fmt.Errorf("build for %s from ref: %s not started - %w", chainConfig.Build.Name, chainConfig.Ref, err),
+					}
+				} else {
+					result = h.buildImage(ctx, chainConfig)
+				}
+				resultsMu.Lock()
+				results = append(results, result)
+				resultsMu.Unlock()
+			}
+		}()
 	}
 	wg.Wait()
-	if len(h.errors) > 0 {
-		for _, err := range h.errors {
-			fmt.Println(err)
-		}
-		panic("Some images failed to build")
-	}
+
+	return results, results.Err()
 }
@@ -0,0 +1,89 @@
+package builder_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/strangelove-ventures/heighliner/docker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildImagesCancelled(t *testing.T) {
+	h := builder.NewHeighlinerBuilder(builder.HeighlinerDockerBuildConfig{}, 2, false, false)
+	h.AddToQueue(
+		context.Background(),
+		builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{
+			{Build: builder.ChainNodeConfig{Name: "gaia"}, Ref: "v15.0.0"},
+			{Build: builder.ChainNodeConfig{Name: "gaia"}, Ref: "v14.0.0"},
+		}},
+		builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{
+			{Build: builder.ChainNodeConfig{Name: "osmosis"}, Ref: "v23.0.0"},
+		}},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := h.BuildImages(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Len(t, results, 3)
+	require.Len(t, results.Failed(), 3)
+	for _, result := range results {
+		require.True(t, errors.Is(result.Err, context.Canceled), result.Err)
+	}
+}
+
+func TestBuildImagesBackend(t *testing.T) {
+	queued := builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{{
+		Build: builder.ChainNodeConfig{
+			Name:       "penumbra",
+			Dockerfile: builder.DockerfileTypeImported,
+			BaseImage:  "ghcr.io/penumbra-zone/penumbra",
+			Platforms:  []string{"linux/amd64"},
+			Binaries:   []string{"/bin/pd"},
+		},
+		Ref: "v0.80.0",
+	}}}
+
+	backend := &docker.FakeBuilder{
+		Caps:            docker.Capabilities{BuildKit: true, MultiPlatform: true},
+		Digest:          "sha256:1234",
+		PlatformDigests: map[string]string{"linux/amd64": "sha256:5678"},
+	}
+	h := builder.NewHeighlinerBuilder(builder.HeighlinerDockerBuildConfig{
+		ContainerRegistry: "ghcr.io/strangelove-ventures/heighliner",
+		Platform:          "linux/amd64,linux/arm64",
+		Backend:           backend,
+	}, 1, true, false)
+	h.AddToQueue(context.Background(), queued)
+
+	results, err := h.BuildImages(context.Background())
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "sha256:1234", results[0].Digest)
+	require.Equal(t, map[string]string{"linux/amd64": "sha256:5678"}, results[0].PlatformDigests)
+	require.Equal(t, []string{"linux/amd64"}, results[0].Platforms)
+
+	builds := backend.Builds()
+	require.Len(t, builds, 1)
+	require.Equal(t, []string{"ghcr.io/strangelove-ventures/heighliner/penumbra:v0.80.0"}, builds[0].Tags)
+	require.Equal(t, []string{"linux/amd64"}, builds[0].Platforms)
+	require.True(t, builds[0].Push)
+	require.Equal(t, "/bin/pd", builds[0].BuildArgs["BINARIES"])
+	require.NotEmpty(t, builds[0].DockerfileContents)
+
+	// unsupported features fail before building.
+	backend = &docker.FakeBuilder{}
+	h = builder.NewHeighlinerBuilder(builder.HeighlinerDockerBuildConfig{
+		TarExportPath: "penumbra.tar",
+		Backend:       backend,
+	}, 1, true, false)
+	h.AddToQueue(context.Background(), queued)
+
+	results, err = h.BuildImages(context.Background())
+	require.ErrorContains(t, err, "the fake builder can not export images as tarballs")
+	require.Len(t, results.Failed(), 1)
+	require.Empty(t, backend.Builds())
+}
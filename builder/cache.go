@@ -0,0 +1,130 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/strangelove-ventures/heighliner/docker"
+)
+
+// BuildCacheMode is where builds import their build cache from and export it to,
+// for backends which support build caches.
+type BuildCacheMode string
+
+const (
+	// BuildCacheNone only uses the local cache of the backend.
+	BuildCacheNone BuildCacheMode = "none"
+	// BuildCacheRegistry imports from and, when pushing, exports all layers to a cache image in the registry,
+	// <image>:buildcache-<tag> unless build-cache-ref is set.
+	BuildCacheRegistry BuildCacheMode = "registry"
+	// BuildCacheInline embeds the cache of the final image in it, and imports it from the previously pushed image tag.
+	BuildCacheInline BuildCacheMode = "inline"
+	// BuildCacheLocal imports from and exports all layers to a directory per chain and tag.
+	BuildCacheLocal BuildCacheMode = "local"
+)
+
+var knownBuildCacheModes = []BuildCacheMode{BuildCacheNone, BuildCacheRegistry, BuildCacheInline, BuildCacheLocal}
+
+// registryCacheTag is the tag prefix of registry caches derived from the image name.
+const registryCacheTag = "buildcache"
+
+// ParseBuildCacheMode parses a build cache mode, the empty mode if s is empty.
+func ParseBuildCacheMode(s string) (BuildCacheMode, error) {
+	for _, mode := range knownBuildCacheModes {
+		if s == string(mode) {
+			return mode, nil
+		}
+	}
+	if s == "" {
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown build cache %q, must be one of: %s", s, joinBuildCacheModes())
+}
+
+func joinBuildCacheModes() string {
+	modes := make([]string, len(knownBuildCacheModes))
+	for i, mode := range knownBuildCacheModes {
+		modes[i] = string(mode)
+	}
+	return strings.Join(modes, ", ")
+}
+
+// DefaultBuildCacheDir returns the directory of local build caches, within the user cache directory.
+func DefaultBuildCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "heighliner", "buildcache")
+}
+
+// buildCache returns the caches to import and export for the plan. The build cache of the build config
+// overrides the one of the chain, and the additional caches of the build config are always used.
+// Derived caches are per image tag, so that parallel builds of a chain do not overwrite each other's cache,
+// while a build-cache-ref is shared by all builds of the chain. If warn is set, caches which can not be
+// derived are warned about on it.
+func (h *HeighlinerBuilder) buildCache(build ChainNodeConfig, plan *BuildPlan, warn io.Writer) (from, to []docker.CacheOptions) {
+	buildCfg := h.buildConfig
+	mode := build.BuildCache
+	if buildCfg.BuildCache != "" {
+		mode = buildCfg.BuildCache
+	}
+
+	// the first tag is that of the ref, with a -race suffix for race builds.
+	tag := ""
+	if len(plan.Tags) > 0 {
+		tag = plan.Tags[0][strings.LastIndex(plan.Tags[0], ":")+1:]
+	}
+
+	registryCache := func(ref string) docker.CacheOptions {
+		return docker.CacheOptions{Type: "registry", Attrs: map[string]string{"ref": ref}}
+	}
+
+	switch mode {
+	case BuildCacheRegistry:
+		ref := build.BuildCacheRef
+		if ref == "" && buildCfg.ContainerRegistry != "" {
+			ref = h.imageName(build.Name) + ":" + registryCacheTag + "-" + tag
+		}
+		if ref == "" {
+			if warn != nil {
+				fmt.Fprintf(warn, "Not using a registry build cache for %s, it requires a container registry or build-cache-ref\n", build.Name)
+			}
+			break
+		}
+		from = append(from, registryCache(ref))
+		// the cache is only pushed along with the image, so that local builds do not overwrite it.
+		if plan.Push {
+			export := registryCache(ref)
+			export.Attrs["mode"] = "max"
+			to = append(to, export)
+		}
+	case BuildCacheInline:
+		ref := build.BuildCacheRef
+		if ref == "" && buildCfg.ContainerRegistry != "" && len(plan.Tags) > 0 {
+			ref = plan.Tags[0]
+		}
+		if ref != "" {
+			from = append(from, registryCache(ref))
+		}
+		to = append(to, docker.CacheOptions{Type: "inline"})
+	case BuildCacheLocal:
+		dir := buildCfg.BuildCacheDir
+		if dir == "" {
+			dir = DefaultBuildCacheDir()
+		}
+		dir = filepath.Join(dir, build.Name, tag)
+		// buildkit fails to import a local cache which was never exported.
+		if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+			from = append(from, docker.CacheOptions{Type: "local", Attrs: map[string]string{"src": dir}})
+		}
+		to = append(to, docker.CacheOptions{Type: "local", Attrs: map[string]string{"dest": dir, "mode": "max"}})
+	}
+
+	from = append(from, buildCfg.CacheFrom...)
+	to = append(to, buildCfg.CacheTo...)
+	return from, to
+}
@@ -0,0 +1,471 @@
+package builder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"github.com/strangelove-ventures/heighliner/releases"
+	"golang.org/x/mod/semver"
+	"gopkg.in/yaml.v3"
+)
+
+// ChainsFile is the raw content of a chains yaml file, along with the path
+// it was read from for error reporting.
+type ChainsFile struct {
+	Path    string
+	Content []byte
+}
+
+// ConfigError is a problem found in a chains yaml file.
+type ConfigError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// ConfigErrors is the list of all problems found while loading chain configs.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// validOS and validArch are the platform components accepted in `platforms`.
+var (
+	validOS   = []string{"linux", "windows", "darwin", "freebsd"}
+	validArch = []string{"amd64", "arm64", "arm", "386", "ppc64le", "s390x", "riscv64", "mips64le"}
+)
+
+// knownDockerfileTypes are the accepted values for `dockerfile` and `language`.
+var knownDockerfileTypes = []DockerfileType{
+	DockerfileTypeCosmos,
+	DockerfileTypeAvalanche,
+	DockerfileTypeCargo,
+	DockerfileTypeImported,
+	DockerfileTypeNone,
+	DockerfileTypeGo,
+	DockerfileTypeRust,
+}
+
+// chainEntry is a single chain config along with where it was declared.
+type chainEntry struct {
+	file   string
+	node   *yaml.Node
+	config ChainNodeConfig
+}
+
+// name returns the name of the entry as declared in the yaml.
+func (e *chainEntry) name() string {
+	if v := mappingValue(e.node, "name"); v != nil {
+		return v.Value
+	}
+	return ""
+}
+
+// line returns the line of the value for key, or of the entry itself if key is not set.
+func (e *chainEntry) line(key string) int {
+	if v := mappingValue(e.node, key); v != nil {
+		return v.Line
+	}
+	return e.node.Line
+}
+
+// itemLine returns the line of item i of the list value for key.
+func (e *chainEntry) itemLine(key string, i int) int {
+	if v := mappingValue(e.node, key); v != nil && v.Kind == yaml.SequenceNode && i < len(v.Content) {
+		return v.Content[i].Line
+	}
+	return e.line(key)
+}
+
+func (e *chainEntry) errorf(key string, format string, args ...any) *ConfigError {
+	return e.errorfAt(e.line(key), format, args...)
+}
+
+func (e *chainEntry) errorfAt(line int, format string, args ...any) *ConfigError {
+	msg := fmt.Sprintf(format, args...)
+	if e.config.Name != "" {
+		msg = fmt.Sprintf("chain %q: %s", e.config.Name, msg)
+	}
+	return &ConfigError{File: e.file, Line: line, Msg: msg}
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// hostKeyFingerprint matches SHA256 ssh host key fingerprints.
+var hostKeyFingerprint = regexp.MustCompile(`^SHA256:[A-Za-z0-9+/]{43}$`)
+
+// notVersioned are the keys of a chain config which can not be overridden in `versions`.
+var notVersioned = []string{"name", "repo-type", "repo-host-fingerprints", "prereleases", "release-constraint", "release-tag-pattern", "extends", "template", "versions"}
+
+// yamlFieldNames returns the yaml keys accepted for struct type t, including those of inlined structs.
+func yamlFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, opts, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if opts == "inline" {
+			names = append(names, yamlFieldNames(t.Field(i).Type)...)
+			continue
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// versionFieldNames returns the yaml keys accepted for entries of `versions`.
+func versionFieldNames() []string {
+	return slices.DeleteFunc(yamlFieldNames(reflect.TypeOf(ChainNodeVersionConfig{})), func(name string) bool {
+		return slices.Contains(notVersioned, name)
+	})
+}
+
+// unknownKeys returns errors for the keys of mapping node which are not in known.
+func unknownKeys(file string, node *yaml.Node, known []string) ConfigErrors {
+	var errs ConfigErrors
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if slices.Contains(known, key.Value) {
+			continue
+		}
+		msg := fmt.Sprintf("unknown key %q", key.Value)
+		if s := suggestKey(key.Value, known); s != "" {
+			msg += fmt.Sprintf(", did you mean %q?", s)
+		}
+		errs = append(errs, &ConfigError{File: file, Line: key.Line, Msg: msg})
+	}
+	return errs
+}
+
+// suggestKey returns a known key that key is likely a typo of, or "".
+func suggestKey(key string, known []string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+	for _, k := range known {
+		if levenshtein(normalized, k) <= 2 || (len(normalized) >= 5 && strings.HasPrefix(k, normalized[:5])) {
+			return k
+		}
+	}
+	return ""
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// typeErrorLine splits the line number from a yaml type error message.
+func typeErrorLine(msg string) (int, string) {
+	var line int
+	if _, err := fmt.Sscanf(msg, "line %d:", &line); err != nil {
+		return 0, msg
+	}
+	_, rest, _ := strings.Cut(msg, ": ")
+	return line, rest
+}
+
+// parseChainsFile parses the entries of a chains yaml file, rejecting unknown keys.
+func parseChainsFile(f ChainsFile) ([]*chainEntry, ConfigErrors) {
+	var doc yaml.Node
+	dec := yaml.NewDecoder(bytes.NewReader(f.Content))
+	if err := dec.Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			// empty file or comments only
+			return nil, nil
+		}
+		return nil, ConfigErrors{{File: f.Path, Msg: err.Error()}}
+	}
+
+	root := &doc
+	if root.Kind == yaml.DocumentNode {
+		if len(root.Content) == 0 {
+			return nil, nil
+		}
+		root = root.Content[0]
+	}
+	if root.Kind == yaml.ScalarNode && root.Tag == "!!null" {
+		return nil, nil
+	}
+	if root.Kind != yaml.SequenceNode {
+		return nil, ConfigErrors{{File: f.Path, Line: root.Line, Msg: "expected a list of chain configs"}}
+	}
+
+	known := yamlFieldNames(reflect.TypeOf(ChainNodeConfig{}))
+	knownVersion := versionFieldNames()
+
+	var entries []*chainEntry
+	var errs ConfigErrors
+	for _, item := range root.Content {
+		if item.Kind != yaml.MappingNode {
+			errs = append(errs, &ConfigError{File: f.Path, Line: item.Line, Msg: "expected a chain config mapping"})
+			continue
+		}
+		entry := &chainEntry{file: f.Path, node: item}
+
+		errs = append(errs, unknownKeys(f.Path, item, known)...)
+		if versions := mappingValue(item, "versions"); versions != nil && versions.Kind == yaml.SequenceNode {
+			for _, v := range versions.Content {
+				if v.Kind == yaml.MappingNode {
+					errs = append(errs, unknownKeys(f.Path, v, knownVersion)...)
+				}
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, errs
+}
+
+// decode decodes the (resolved) node of the entry into its config.
+func (e *chainEntry) decode() ConfigErrors {
+	if err := e.node.Decode(&e.config); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			var errs ConfigErrors
+			for _, msg := range typeErr.Errors {
+				line, msg := typeErrorLine(msg)
+				errs = append(errs, &ConfigError{File: e.file, Line: line, Msg: msg})
+			}
+			return errs
+		}
+		return ConfigErrors{{File: e.file, Line: e.node.Line, Msg: err.Error()}}
+	}
+	return nil
+}
+
+// validateChainEntry checks the values of a single chain config.
+func validateChainEntry(e *chainEntry) ConfigErrors {
+	var errs ConfigErrors
+	c := e.config
+
+	if c.Name == "" {
+		errs = append(errs, e.errorf("name", "name is required"))
+	}
+
+	for _, key := range []string{"dockerfile", "language"} {
+		dockerfile := c.Dockerfile
+		if key == "language" {
+			dockerfile = c.Language
+		}
+		if dockerfile == "" {
+			continue
+		}
+		if !slices.Contains(knownDockerfileTypes, dockerfile) {
+			errs = append(errs, e.errorf(key, "unknown %s %q, must be one of: %s", key, dockerfile, joinDockerfileTypes(knownDockerfileTypes)))
+		}
+	}
+
+	if !releases.ValidHostType(c.RepoType) {
+		errs = append(errs, e.errorf("repo-type", "unknown repo-type %q, must be one of: %s", c.RepoType, releases.JoinHostTypes()))
+	}
+	for i, fingerprint := range c.RepoHostFingerprints {
+		if !hostKeyFingerprint.MatchString(fingerprint) {
+			errs = append(errs, e.errorfAt(e.itemLine("repo-host-fingerprints", i),
+				"invalid repo-host-fingerprints %q, must be a SHA256 fingerprint as shown by ssh-keygen -lf, e.g. SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU", fingerprint))
+		}
+	}
+	if c.ReleaseConstraint != "" {
+		if _, err := version.NewConstraint(c.ReleaseConstraint); err != nil {
+			errs = append(errs, e.errorf("release-constraint", "invalid release-constraint %q: %v", c.ReleaseConstraint, err))
+		}
+	}
+	if c.ReleaseTagPattern != "" {
+		if _, err := regexp.Compile(c.ReleaseTagPattern); err != nil {
+			errs = append(errs, e.errorf("release-tag-pattern", "invalid release-tag-pattern %q: %v", c.ReleaseTagPattern, err))
+		}
+	}
+
+	if c.GoVersion != "" && !semver.IsValid("v"+c.GoVersion) {
+		errs = append(errs, e.errorf("go-version", "invalid go-version %q, must be a go version, e.g. 1.21 or 1.21.13", c.GoVersion))
+	}
+	if c.AlpineVersion != "" && !semver.IsValid("v"+c.AlpineVersion) {
+		errs = append(errs, e.errorf("alpine-version", "invalid alpine-version %q, must be an alpine version, e.g. 3.20", c.AlpineVersion))
+	}
+
+	for i, platform := range c.Platforms {
+		if err := validatePlatform(platform); err != nil {
+			errs = append(errs, e.errorfAt(e.itemLine("platforms", i), "%v", err))
+		}
+	}
+
+	for i, binary := range c.Binaries {
+		if err := validateBinary(binary); err != nil {
+			errs = append(errs, e.errorfAt(e.itemLine("binaries", i), "%v", err))
+		}
+	}
+
+	for i, dep := range c.NativeDeps {
+		if dep != NativeDepsNone && !slices.Contains(NativeDependencyDetectors(), dep) {
+			errs = append(errs, e.errorfAt(e.itemLine("native-deps", i),
+				"unknown native-deps %q, must be one of: %s, %s", dep, strings.Join(NativeDependencyDetectors(), ", "), NativeDepsNone))
+		}
+	}
+
+	if c.BuildCache != "" && !slices.Contains(knownBuildCacheModes, c.BuildCache) {
+		errs = append(errs, e.errorf("build-cache", "unknown build-cache %q, must be one of: %s", c.BuildCache, joinBuildCacheModes()))
+	}
+
+	versions := mappingValue(e.node, "versions")
+	for i, v := range c.Versions {
+		versionEntry := &chainEntry{file: e.file, node: versions.Content[i], config: v.ChainNodeConfig}
+		versionEntry.config.Name = c.Name
+		if v.Constraint == "" {
+			errs = append(errs, versionEntry.errorf("constraint", "versions constraint is required"))
+		} else if _, err := version.NewConstraint(v.Constraint); err != nil {
+			errs = append(errs, versionEntry.errorf("constraint", "invalid versions constraint %q: %v", v.Constraint, err))
+		}
+		errs = append(errs, validateChainEntry(versionEntry)...)
+	}
+
+	return errs
+}
+
+// validatePlatform checks that platform is a valid os/arch[/variant] pair.
+func validatePlatform(platform string) error {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("invalid platform %q, must be os/arch[/variant]", platform)
+	}
+	if !slices.Contains(validOS, parts[0]) {
+		return fmt.Errorf("invalid platform %q, unknown os %q", platform, parts[0])
+	}
+	if !slices.Contains(validArch, parts[1]) {
+		return fmt.Errorf("invalid platform %q, unknown arch %q", platform, parts[1])
+	}
+	if len(parts) == 3 && parts[2] == "" {
+		return fmt.Errorf("invalid platform %q, empty variant", platform)
+	}
+	return nil
+}
+
+// validateBinary checks that a `binaries` entry is in the form src[:dest].
+func validateBinary(binary string) error {
+	parts := strings.Split(binary, ":")
+	if len(parts) > 2 {
+		return fmt.Errorf("invalid binary %q, must be src[:dest]", binary)
+	}
+	for _, p := range parts {
+		if strings.TrimSpace(p) == "" {
+			return fmt.Errorf("invalid binary %q, must be src[:dest]", binary)
+		}
+	}
+	return nil
+}
+
+// LoadChainNodeConfigs strictly parses and validates the chain configs in files.
+// All problems found are returned together as ConfigErrors.
+func LoadChainNodeConfigs(files ...ChainsFile) ([]ChainNodeConfig, error) {
+	var entries []*chainEntry
+	var errs ConfigErrors
+	for _, f := range files {
+		fileEntries, fileErrs := parseChainsFile(f)
+		entries = append(entries, fileEntries...)
+		errs = append(errs, fileErrs...)
+	}
+
+	declared := make(map[string]*chainEntry)
+	for _, e := range entries {
+		name := e.name()
+		if name == "" {
+			continue
+		}
+		if prev, ok := declared[name]; ok {
+			errs = append(errs, &ConfigError{
+				File: e.file,
+				Line: e.line("name"),
+				Msg:  fmt.Sprintf("chain %q: duplicate chain name, previously declared at %s:%d", name, prev.file, prev.line("name")),
+			})
+			continue
+		}
+		declared[name] = e
+	}
+
+	errs = append(errs, resolveExtends(entries, declared)...)
+
+	// entries that fail to decode are not validated further,
+	// but entries with unknown keys are so that all problems are reported at once.
+	var decoded []*chainEntry
+	for _, e := range entries {
+		if decodeErrs := e.decode(); len(decodeErrs) > 0 {
+			errs = append(errs, decodeErrs...)
+			continue
+		}
+		errs = append(errs, validateChainEntry(e)...)
+		decoded = append(decoded, e)
+	}
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			if errs[i].File != errs[j].File {
+				return errs[i].File < errs[j].File
+			}
+			return errs[i].Line < errs[j].Line
+		})
+		return nil, errs
+	}
+
+	// templates are only used to be extended, not built.
+	var chains []ChainNodeConfig
+	for _, e := range decoded {
+		if !e.config.Template {
+			chains = append(chains, e.config)
+		}
+	}
+	return chains, nil
+}
+
+func joinDockerfileTypes(types []DockerfileType) string {
+	s := make([]string, len(types))
+	for i, t := range types {
+		s[i] = string(t)
+	}
+	return strings.Join(s, ", ")
+}
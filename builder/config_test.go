@@ -0,0 +1,213 @@
+package builder_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadChainNodeConfigs(t *testing.T) {
+	chains, err := builder.LoadChainNodeConfigs(builder.ChainsFile{Path: "gaia.yaml", Content: []byte(`
+- name: gaia
+  github-organization: cosmos
+  github-repo: gaia
+  dockerfile: cosmos
+  platforms:
+    - linux/amd64
+  binaries:
+    - /go/bin/gaiad:/bin/gaiad
+`)})
+	require.NoError(t, err)
+	require.Len(t, chains, 1)
+	require.Equal(t, builder.DockerfileTypeCosmos, chains[0].Dockerfile)
+
+	_, err = builder.LoadChainNodeConfigs(
+		builder.ChainsFile{Path: "a.yaml", Content: []byte(`
+- name: gaia
+  build_target: make install
+  dockerfile: cosmoss
+  repo-type: bitbucket
+  repo-host-fingerprints:
+    - SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU
+    - MD5:16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48
+  release-tag-pattern: "^v("
+  platforms:
+    - linux/amd64
+    - linux
+  binaries:
+    - /go/bin/a:b:c
+  native-deps:
+    - wasmvm
+    - libfoo
+`)},
+		builder.ChainsFile{Path: "b.yaml", Content: []byte(`
+- name: gaia
+  binaries: /go/bin/gaiad
+- name: osmosis
+`)},
+	)
+	var configErrs builder.ConfigErrors
+	require.ErrorAs(t, err, &configErrs)
+	require.Equal(t, []string{
+		`a.yaml:3: unknown key "build_target", did you mean "build-target"?`,
+		`a.yaml:4: chain "gaia": unknown dockerfile "cosmoss", must be one of: cosmos, avalanche, cargo, imported, none, go, rust`,
+		`a.yaml:5: chain "gaia": unknown repo-type "bitbucket", must be one of: github, gitlab, gitea, git`,
+		`a.yaml:8: chain "gaia": invalid repo-host-fingerprints "MD5:16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48", must be a SHA256 fingerprint as shown by ssh-keygen -lf, e.g. SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU`,
+		`a.yaml:9: chain "gaia": invalid release-tag-pattern "^v(": error parsing regexp: missing closing ): ` + "`^v(`",
+		`a.yaml:12: chain "gaia": invalid platform "linux", must be os/arch[/variant]`,
+		`a.yaml:14: chain "gaia": invalid binary "/go/bin/a:b:c", must be src[:dest]`,
+		`a.yaml:17: chain "gaia": unknown native-deps "libfoo", must be one of: wasmvm, wasmvm-shared, none`,
+		`b.yaml:2: chain "gaia": duplicate chain name, previously declared at a.yaml:2`,
+		"b.yaml:3: cannot unmarshal !!str `/go/bin...` into []string",
+	}, errorStrings(configErrs))
+
+	_, err = builder.LoadChainNodeConfigs(
+		builder.ChainsFile{Path: "a.yaml", Content: []byte("- name: gaia\n")},
+		builder.ChainsFile{Path: "b.yaml", Content: []byte("# comment only\n")},
+		builder.ChainsFile{Path: "c.yaml", Content: []byte("- name: osmosis\n- name: gaia\n")},
+	)
+	require.ErrorAs(t, err, &configErrs)
+	require.Equal(t, []string{
+		`c.yaml:2: chain "gaia": duplicate chain name, previously declared at a.yaml:1`,
+	}, errorStrings(configErrs))
+}
+
+func TestLoadChainNodeConfigsExtends(t *testing.T) {
+	chains, err := builder.LoadChainNodeConfigs(
+		builder.ChainsFile{Path: "gaia.yaml", Content: []byte(`
+- name: gaia
+  extends: cosmos-default
+  github-organization: cosmos
+  github-repo: gaia
+  build-env:
+    - BUILD_TAGS=muslc,ledger
+  binaries:
+    - /go/bin/gaiad
+- name: gaia-fork
+  extends: gaia
+  github-organization: strangelove-ventures
+  binaries: !replace
+    - /go/bin/gaiad:/bin/gaiad-fork
+`)},
+		builder.ChainsFile{Path: "templates.yaml", Content: []byte(`
+- name: cosmos-default
+  template: true
+  dockerfile: cosmos
+  build-target: make install
+  build-env:
+    - LEDGER_ENABLED=false
+    - BUILD_TAGS=muslc
+`)},
+	)
+	require.NoError(t, err)
+	require.Len(t, chains, 2)
+
+	gaia, fork := chains[0], chains[1]
+	require.Equal(t, "gaia", gaia.Name)
+	require.Equal(t, builder.DockerfileTypeCosmos, gaia.Dockerfile)
+	require.Equal(t, "make install", gaia.BuildTarget)
+	require.Equal(t, []string{"LEDGER_ENABLED=false", "BUILD_TAGS=muslc,ledger"}, gaia.BuildEnv)
+
+	require.Equal(t, "gaia-fork", fork.Name)
+	require.Equal(t, "strangelove-ventures", fork.GithubOrganization)
+	require.Equal(t, "gaia", fork.GithubRepo)
+	require.Equal(t, gaia.BuildEnv, fork.BuildEnv)
+	require.Equal(t, []string{"/go/bin/gaiad:/bin/gaiad-fork"}, fork.Binaries)
+
+	_, err = builder.LoadChainNodeConfigs(builder.ChainsFile{Path: "a.yaml", Content: []byte(`
+- name: a
+  extends: b
+- name: b
+  extends: a
+- name: c
+  extends: unknown
+`)})
+	var configErrs builder.ConfigErrors
+	require.ErrorAs(t, err, &configErrs)
+	require.Equal(t, []string{
+		`a.yaml:5: chain "b": extends cycle: a -> b -> a`,
+		`a.yaml:7: chain "c": extends unknown chain or template "unknown"`,
+	}, errorStrings(configErrs))
+}
+
+func TestChainNodeConfigForRef(t *testing.T) {
+	chains, err := builder.LoadChainNodeConfigs(builder.ChainsFile{Path: "gaia.yaml", Content: []byte(`
+- name: gaia
+  dockerfile: cosmos
+  build-target: make install
+  pre-build: make deps
+  build-env:
+    - BUILD_TAGS=muslc
+  binaries:
+    - /go/bin/gaiad
+  versions:
+    - constraint: "< v15.0.0"
+      binaries:
+        - build/gaiad
+    - constraint: "< v10.0.0"
+      build-dir: app
+    - constraint: "< v5.0.0"
+      pre-build: ""
+      build-env: []
+`)})
+	require.NoError(t, err)
+	gaia := chains[0]
+
+	for _, tc := range []struct {
+		ref      string
+		binaries []string
+		buildDir string
+		preBuild string
+		buildEnv []string
+	}{
+		{ref: "v15.0.0", binaries: []string{"/go/bin/gaiad"}, preBuild: "make deps", buildEnv: []string{"BUILD_TAGS=muslc"}},
+		{ref: "v15.0.0-rc1", binaries: []string{"/go/bin/gaiad"}, preBuild: "make deps", buildEnv: []string{"BUILD_TAGS=muslc"}},
+		{ref: "v14.2.0", binaries: []string{"build/gaiad"}, preBuild: "make deps", buildEnv: []string{"BUILD_TAGS=muslc"}},
+		{ref: "v9.1.1", binaries: []string{"build/gaiad"}, buildDir: "app", preBuild: "make deps", buildEnv: []string{"BUILD_TAGS=muslc"}},
+		// empty values clear the chain config.
+		{ref: "v4.2.1", binaries: []string{"build/gaiad"}, buildDir: "app", buildEnv: []string{}},
+		{ref: "main", binaries: []string{"/go/bin/gaiad"}, preBuild: "make deps", buildEnv: []string{"BUILD_TAGS=muslc"}},
+	} {
+		cfg := gaia.ForRef(tc.ref)
+		require.Equal(t, tc.binaries, cfg.Binaries, tc.ref)
+		require.Equal(t, tc.buildDir, cfg.BuildDir, tc.ref)
+		require.Equal(t, "make install", cfg.BuildTarget, tc.ref)
+		require.Equal(t, tc.preBuild, cfg.PreBuild, tc.ref)
+		require.Equal(t, tc.buildEnv, cfg.BuildEnv, tc.ref)
+		require.Nil(t, cfg.Versions, tc.ref)
+	}
+
+	_, err = builder.LoadChainNodeConfigs(builder.ChainsFile{Path: "gaia.yaml", Content: []byte(`
+- name: gaia
+  versions:
+    - constraint: "<< v15"
+    - build-dir: app
+      name: gaia2
+`)})
+	var configErrs builder.ConfigErrors
+	require.ErrorAs(t, err, &configErrs)
+	require.Equal(t, []string{
+		`gaia.yaml:4: chain "gaia": invalid versions constraint "<< v15": Malformed constraint: << v15`,
+		`gaia.yaml:5: chain "gaia": versions constraint is required`,
+		`gaia.yaml:6: unknown key "name"`,
+	}, errorStrings(configErrs))
+}
+
+func TestChainsJSONSchemaUpToDate(t *testing.T) {
+	schema, err := builder.ChainsJSONSchema()
+	require.NoError(t, err)
+
+	published, err := os.ReadFile("../chains.schema.json")
+	require.NoError(t, err)
+	require.Equal(t, string(schema), string(published), "chains.schema.json is out of date, regenerate with `heighliner validate --schema > chains.schema.json`")
+}
+
+func errorStrings(errs builder.ConfigErrors) []string {
+	s := make([]string, len(errs))
+	for i, err := range errs {
+		s[i] = err.Error()
+	}
+	return s
+}
@@ -0,0 +1,89 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// skipExisting returns whether builds whose image tags already exist in the
+// container registry should be skipped, which only applies when pushing.
+func (h *HeighlinerBuilder) skipExisting() bool {
+	return h.registry != nil && !h.local &&
+		h.buildConfig.ContainerRegistry != "" && !h.buildConfig.SkipPush
+}
+
+// withoutExisting returns chainBuilds without the builds whose image tag already exists in the
+// container registry with all platforms that would be built, and the results of the skipped builds.
+// Branches move, so branch builds are only skipped if the revision tag of their current commit exists.
+// Builds are kept if the ref or registry lookup fails, so that they are attempted anyway.
+func (h *HeighlinerBuilder) withoutExisting(ctx context.Context, chainBuilds HeighlinerQueuedChainBuilds) (HeighlinerQueuedChainBuilds, BuildResults) {
+	var skipped BuildResults
+	filtered := HeighlinerQueuedChainBuilds{ChainConfigs: []ChainNodeDockerBuildConfig{}}
+	for _, chainConfig := range chainBuilds.ChainConfigs {
+		image := h.imageName(chainConfig.Build.Name)
+		tag := imageTag(chainConfig.Ref, chainConfig.Tag, h.local)
+		if chainConfig.Tag == "" {
+			commit, refName, err := h.resolveChainRef(ctx, chainConfig.Build, chainConfig.Ref)
+			if err != nil {
+				fmt.Fprintf(h.buildConfig.Progress, "Unable to resolve %s %s to check for an existing image, building anyway: %v\n", chainConfig.Build.Name, chainConfig.Ref, err)
+				filtered.ChainConfigs = append(filtered.ChainConfigs, chainConfig)
+				continue
+			}
+			if revTag := revisionTag(refName, commit); revTag != "" {
+				tag = revTag
+			}
+		}
+		if dockerfile := dockerfileType(chainConfig.Build, nil); h.race && (dockerfile == DockerfileTypeCosmos || dockerfile == DockerfileTypeAvalanche) {
+			tag += "-race"
+		}
+
+		// builds without platforms are for the host platform of the backend, so only the tag is checked.
+		var platforms []string
+		if h.buildConfig.Backend.Capabilities().MultiPlatform {
+			var err error
+			if platforms, err = buildPlatforms(chainConfig.Build, h.buildConfig.Platform); err != nil {
+				filtered.ChainConfigs = append(filtered.ChainConfigs, chainConfig)
+				continue
+			}
+		}
+
+		missing, exists, err := h.registry.MissingPlatforms(ctx, image, tag, platforms)
+		switch {
+		case err != nil:
+			fmt.Fprintf(h.buildConfig.Progress, "Unable to check for existing image %s:%s, building anyway: %v\n", image, tag, err)
+		case !exists:
+			// build
+		case len(missing) > 0:
+			fmt.Fprintf(h.buildConfig.Progress, "Image %s:%s exists but is missing platforms %s, rebuilding\n", image, tag, strings.Join(missing, ","))
+		default:
+			fmt.Fprintf(h.buildConfig.Progress, "Image %s:%s already exists, skipping build\n", image, tag)
+			skipped = append(skipped, BuildResult{
+				Chain:     chainConfig.Build.Name,
+				Ref:       chainConfig.Ref,
+				Tags:      []string{image + ":" + tag},
+				Platforms: platforms,
+				Skipped:   true,
+			})
+			continue
+		}
+		filtered.ChainConfigs = append(filtered.ChainConfigs, chainConfig)
+	}
+	return filtered, skipped
+}
+
+// resolveChainRef returns the commit of ref in the remote repo of a chain and the name of the reference
+// it resolved, authenticating with the secrets of the build config and chain.
+func (h *HeighlinerBuilder) resolveChainRef(ctx context.Context, build ChainNodeConfig, ref string) (string, plumbing.ReferenceName, error) {
+	repoHost := build.RepoHost
+	if repoHost == "" {
+		repoHost = "github.com"
+	}
+	auth, err := h.buildConfig.Secrets.withChainConfig(build).gitAuth(repoHost, &knownHosts{})
+	if err != nil {
+		return "", "", err
+	}
+	return ResolveRef(ctx, repoURL(build, repoHost, auth), auth, ref)
+}
@@ -0,0 +1,69 @@
+package builder_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/strangelove-ventures/heighliner/docker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipExistingBranch(t *testing.T) {
+	url, commit := initRepo(t, false)
+	dir := strings.TrimPrefix(url, "file://")
+	builder.SetGitURLScheme(t, "file://")
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	existing := map[string]bool{"release": true, "v1.0.0": true}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !existing[strings.TrimPrefix(r.URL.Path, "/v2/heighliner/chain/manifests/")] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"manifests":[{"digest":"sha256:abc","platform":{"os":"linux","architecture":"amd64"}}]}`))
+	}))
+	defer srv.Close()
+
+	chain := builder.ChainNodeConfig{
+		Name:               "chain",
+		Dockerfile:         builder.DockerfileTypeImported,
+		RepoHost:           filepath.Dir(filepath.Dir(dir)),
+		GithubOrganization: filepath.Base(filepath.Dir(dir)),
+		GithubRepo:         filepath.Base(dir),
+	}
+	queued := func() []string {
+		h := builder.NewHeighlinerBuilder(builder.HeighlinerDockerBuildConfig{
+			ContainerRegistry: strings.TrimPrefix(srv.URL, "https://") + "/heighliner",
+			SkipExisting:      true,
+			Backend:           &docker.FakeBuilder{},
+			Progress:          io.Discard,
+		}, 1, false, false)
+		h.SetRegistry(&docker.RegistryClient{
+			HTTPClient:  srv.Client(),
+			Credentials: func(string) (docker.RegistryCredentials, error) { return docker.RegistryCredentials{}, nil },
+		})
+		h.AddToQueue(context.Background(), builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{
+			{Build: chain, Ref: "release"},
+			{Build: chain, Ref: "v1.0.0"},
+		}})
+		plans, err := h.Plan(context.Background())
+		require.NoError(t, err)
+		var refs []string
+		for _, plan := range plans {
+			refs = append(refs, plan.Ref)
+		}
+		return refs
+	}
+
+	// the branch tag exists, but not for the current commit of the branch.
+	require.Equal(t, []string{"release"}, queued())
+
+	existing["release-"+commit[:7]] = true
+	require.Empty(t, queued())
+}
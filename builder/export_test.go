@@ -0,0 +1,19 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/docker"
+)
+
+// SetGitURLScheme sets the scheme of remote repo urls for the duration of the test, e.g. to file:// for local repos.
+func SetGitURLScheme(t *testing.T, scheme string) {
+	old := gitURLScheme
+	gitURLScheme = scheme
+	t.Cleanup(func() { gitURLScheme = old })
+}
+
+// SetRegistry sets the registry client which existing images are looked up with.
+func (h *HeighlinerBuilder) SetRegistry(registry *docker.RegistryClient) {
+	h.registry = registry
+}
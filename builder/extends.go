@@ -0,0 +1,186 @@
+package builder
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// replaceTag can be set on a list or map value of a chain config which extends
+// another to replace the inherited value instead of merging with it.
+const replaceTag = "!replace"
+
+// notInherited are the keys of a chain config which are never inherited through `extends`.
+var notInherited = []string{"name", "extends", "template"}
+
+// resolveExtends replaces the node of each entry which extends another chain config
+// or template with the deep merge of the extended config and its own values.
+func resolveExtends(entries []*chainEntry, declared map[string]*chainEntry) ConfigErrors {
+	var errs ConfigErrors
+	resolved := make(map[*chainEntry]bool)
+	failed := make(map[*chainEntry]bool)
+
+	var resolve func(e *chainEntry, visiting []*chainEntry) bool
+	resolve = func(e *chainEntry, visiting []*chainEntry) bool {
+		if resolved[e] {
+			return true
+		}
+		if failed[e] {
+			return false
+		}
+
+		ext := mappingValue(e.node, "extends")
+		if ext == nil || ext.Value == "" {
+			clearReplaceTags(e.node)
+			resolved[e] = true
+			return true
+		}
+
+		parent, ok := declared[ext.Value]
+		if !ok {
+			errs = append(errs, &ConfigError{
+				File: e.file,
+				Line: ext.Line,
+				Msg:  fmt.Sprintf("chain %q: extends unknown chain or template %q", e.name(), ext.Value),
+			})
+			failed[e] = true
+			return false
+		}
+		i := slices.Index(visiting, parent)
+		if parent == e {
+			i = len(visiting)
+		}
+		if i >= 0 {
+			var names []string
+			for _, v := range append(visiting[i:], e, parent) {
+				names = append(names, v.name())
+			}
+			errs = append(errs, &ConfigError{
+				File: e.file,
+				Line: ext.Line,
+				Msg:  fmt.Sprintf("chain %q: extends cycle: %s", e.name(), strings.Join(names, " -> ")),
+			})
+			failed[e] = true
+			return false
+		}
+
+		// errors for the parent are reported for the parent itself.
+		if !resolve(parent, append(visiting, e)) {
+			failed[e] = true
+			return false
+		}
+
+		e.node = mergeNodes(inheritedNode(parent.node, ext.Line), e.node)
+		resolved[e] = true
+		return true
+	}
+
+	for _, e := range entries {
+		resolve(e, nil)
+	}
+
+	return errs
+}
+
+// inheritedNode returns a copy of a chain config mapping node without the keys that are
+// not inherited. Values are reported at line, the line of the `extends` key of the child,
+// so that errors in inherited values point to where they were inherited.
+func inheritedNode(node *yaml.Node, line int) *yaml.Node {
+	inherited := &yaml.Node{Kind: yaml.MappingNode, Tag: node.Tag, Line: line, Column: node.Column}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if slices.Contains(notInherited, node.Content[i].Value) {
+			continue
+		}
+		inherited.Content = append(inherited.Content, copyNode(node.Content[i], line), copyNode(node.Content[i+1], line))
+	}
+	return inherited
+}
+
+// copyNode deep copies node, setting the line of all nodes.
+func copyNode(node *yaml.Node, line int) *yaml.Node {
+	n := *node
+	n.Line = line
+	n.Content = make([]*yaml.Node, len(node.Content))
+	for i, c := range node.Content {
+		n.Content[i] = copyNode(c, line)
+	}
+	return &n
+}
+
+// mergeNodes deep merges override onto base. Maps are merged by key and lists are
+// appended to, except for KEY=VALUE items which replace the base item with the same KEY.
+// Scalars, and values tagged !replace, in override replace those in base.
+func mergeNodes(base, override *yaml.Node) *yaml.Node {
+	if override.Tag == replaceTag || base.Kind != override.Kind {
+		clearReplaceTags(override)
+		return override
+	}
+
+	switch override.Kind {
+	case yaml.MappingNode:
+		merged := *override
+		merged.Content = slices.Clone(base.Content)
+		for i := 0; i+1 < len(override.Content); i += 2 {
+			key, value := override.Content[i], override.Content[i+1]
+			found := false
+			for j := 0; j+1 < len(merged.Content); j += 2 {
+				if merged.Content[j].Value == key.Value {
+					merged.Content[j+1] = mergeNodes(merged.Content[j+1], value)
+					found = true
+					break
+				}
+			}
+			if !found {
+				clearReplaceTags(value)
+				merged.Content = append(merged.Content, key, value)
+			}
+		}
+		return &merged
+
+	case yaml.SequenceNode:
+		merged := *override
+		merged.Content = slices.Clone(base.Content)
+		for _, item := range override.Content {
+			clearReplaceTags(item)
+			merged.Content = mergeSequenceItem(merged.Content, item)
+		}
+		return &merged
+
+	default:
+		return override
+	}
+}
+
+// mergeSequenceItem adds item to items, replacing an item with the same KEY
+// for KEY=VALUE items, and skipping items which are already present.
+func mergeSequenceItem(items []*yaml.Node, item *yaml.Node) []*yaml.Node {
+	if item.Kind != yaml.ScalarNode {
+		return append(items, item)
+	}
+	key, _, isEnv := strings.Cut(item.Value, "=")
+	for i, existing := range items {
+		if existing.Kind != yaml.ScalarNode {
+			continue
+		}
+		if existing.Value == item.Value {
+			return items
+		}
+		if existingKey, _, ok := strings.Cut(existing.Value, "="); isEnv && ok && existingKey == key {
+			items[i] = item
+			return items
+		}
+	}
+	return append(items, item)
+}
+
+// clearReplaceTags removes !replace tags so that the node can be decoded.
+func clearReplaceTags(node *yaml.Node) {
+	if node.Tag == replaceTag {
+		node.Tag = ""
+	}
+	for _, c := range node.Content {
+		clearReplaceTags(c)
+	}
+}
@@ -0,0 +1,278 @@
+package builder
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+)
+
+// GoReleasesURL lists the supported go releases, see https://go.dev/doc/devel/release.
+const GoReleasesURL = "https://go.dev/dl/?mode=json"
+
+//go:embed go_versions.json
+var embeddedGoCatalog []byte
+
+// GoCatalog is the catalog of go versions and the alpine versions of their golang images.
+type GoCatalog struct {
+	// LatestAlpine is the alpine version for go versions which are not in the catalog,
+	// and for new go minor versions added by Update.
+	LatestAlpine string           `json:"latestAlpine"`
+	Versions     []GoCatalogEntry `json:"versions"`
+}
+
+// GoCatalogEntry is the latest patch release of a go minor version.
+type GoCatalogEntry struct {
+	Minor   string `json:"minor"`   // e.g. 1.23
+	Version string `json:"version"` // e.g. 1.23.12
+	Alpine  string `json:"alpine"`  // e.g. 3.22, golang:<version>-alpine<alpine> must exist
+}
+
+// EmbeddedGoCatalog returns the catalog released with heighliner.
+func EmbeddedGoCatalog() (GoCatalog, error) {
+	return ParseGoCatalog(embeddedGoCatalog)
+}
+
+// ParseGoCatalog parses and validates a JSON go catalog.
+func ParseGoCatalog(bz []byte) (GoCatalog, error) {
+	var c GoCatalog
+	if err := json.Unmarshal(bz, &c); err != nil {
+		return c, fmt.Errorf("error parsing go versions: %w", err)
+	}
+	return c, c.Validate()
+}
+
+// Validate checks that every entry is the patch release of its minor version, with an alpine version.
+func (c GoCatalog) Validate() error {
+	if c.LatestAlpine == "" {
+		return errors.New("latestAlpine is required")
+	}
+	if len(c.Versions) == 0 {
+		return errors.New("no go versions")
+	}
+	seen := make(map[string]bool)
+	for _, v := range c.Versions {
+		if !semver.IsValid("v"+v.Minor) || semver.MajorMinor("v"+v.Minor) != "v"+v.Minor {
+			return fmt.Errorf("invalid go minor version %q", v.Minor)
+		}
+		if seen[v.Minor] {
+			return fmt.Errorf("duplicate go minor version %s", v.Minor)
+		}
+		seen[v.Minor] = true
+		if semver.MajorMinor("v"+v.Version) != "v"+v.Minor {
+			return fmt.Errorf("go version %q is not a release of go %s", v.Version, v.Minor)
+		}
+		if v.Alpine == "" {
+			return fmt.Errorf("alpine version is required for go %s", v.Minor)
+		}
+	}
+	return nil
+}
+
+// sorted returns the catalog with versions in ascending order.
+func (c GoCatalog) sorted() GoCatalog {
+	c.Versions = slices.Clone(c.Versions)
+	slices.SortFunc(c.Versions, func(a, b GoCatalogEntry) int {
+		return semver.Compare("v"+a.Minor, "v"+b.Minor)
+	})
+	return c
+}
+
+// Merge returns the catalog with the newer patch releases and minor versions of other.
+func (c GoCatalog) Merge(other GoCatalog) GoCatalog {
+	merged := GoCatalog{LatestAlpine: c.LatestAlpine, Versions: slices.Clone(c.Versions)}
+	if semver.Compare("v"+other.LatestAlpine, "v"+c.LatestAlpine) > 0 {
+		merged.LatestAlpine = other.LatestAlpine
+	}
+	for _, o := range other.Versions {
+		i := slices.IndexFunc(merged.Versions, func(v GoCatalogEntry) bool { return v.Minor == o.Minor })
+		switch {
+		case i < 0:
+			merged.Versions = append(merged.Versions, o)
+		case semver.Compare("v"+o.Version, "v"+merged.Versions[i].Version) > 0:
+			merged.Versions[i] = o
+		}
+	}
+	return merged.sorted()
+}
+
+// goRelease is a release listed by go.dev/dl.
+type goRelease struct {
+	Version string `json:"version"` // e.g. go1.23.12
+	Stable  bool   `json:"stable"`
+}
+
+// FetchGoReleases returns the stable go releases listed at url, e.g. GoReleasesURL, without the go prefix.
+func FetchGoReleases(ctx context.Context, url string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("error building go releases request: %w", err)
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error performing go releases request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading go releases: %w", err)
+	}
+	var releases []goRelease
+	if err := json.Unmarshal(body, &releases); err != nil {
+		return nil, fmt.Errorf("error parsing go releases: %w", err)
+	}
+	var versions []string
+	for _, r := range releases {
+		if r.Stable {
+			versions = append(versions, strings.TrimPrefix(r.Version, "go"))
+		}
+	}
+	return versions, nil
+}
+
+// GoImageExists reports whether the golang:<goVersion>-alpine<alpineVersion> image is published.
+type GoImageExists func(ctx context.Context, goVersion string, alpineVersion string) (bool, error)
+
+// Update returns the catalog with the patch releases in versions. Go minor versions newer than all
+// in the catalog are added with the latest alpine version. Releases whose golang image is not published,
+// as checked with exists, are skipped. Changes describes what was updated or skipped.
+func (c GoCatalog) Update(ctx context.Context, versions []string, exists GoImageExists) (updated GoCatalog, changes []string, err error) {
+	updated = c.sorted()
+	published := func(minor, version, alpine string) (bool, error) {
+		ok, err := exists(ctx, version, alpine)
+		if err != nil {
+			return false, fmt.Errorf("error checking golang image for go %s: %w", version, err)
+		}
+		if !ok {
+			changes = append(changes, fmt.Sprintf("go %s: skipped %s, golang:%s is not published", minor, version, GolangAlpineImage(version, alpine)))
+		}
+		return ok, nil
+	}
+	for _, version := range versions {
+		if !semver.IsValid("v" + version) {
+			continue
+		}
+		minor := strings.TrimPrefix(semver.MajorMinor("v"+version), "v")
+		i := slices.IndexFunc(updated.Versions, func(v GoCatalogEntry) bool { return v.Minor == minor })
+		if i >= 0 {
+			if semver.Compare("v"+version, "v"+updated.Versions[i].Version) > 0 {
+				ok, err := published(minor, version, updated.Versions[i].Alpine)
+				if err != nil {
+					return c, nil, err
+				}
+				if ok {
+					changes = append(changes, fmt.Sprintf("go %s: %s -> %s", minor, updated.Versions[i].Version, version))
+					updated.Versions[i].Version = version
+				}
+			}
+			continue
+		}
+		latest := updated.Versions[len(updated.Versions)-1]
+		if semver.Compare("v"+minor, "v"+latest.Minor) > 0 {
+			ok, err := published(minor, version, updated.LatestAlpine)
+			if err != nil {
+				return c, nil, err
+			}
+			if ok {
+				changes = append(changes, fmt.Sprintf("go %s: added %s with alpine %s", minor, version, updated.LatestAlpine))
+				updated.Versions = append(updated.Versions, GoCatalogEntry{Minor: minor, Version: version, Alpine: updated.LatestAlpine})
+			}
+		}
+	}
+	return updated, changes, nil
+}
+
+// GoCatalogCachePath returns the path `heighliner go-versions update` caches the catalog at.
+func GoCatalogCachePath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "heighliner", "go_versions.json"), nil
+}
+
+// LoadGoCatalog returns the catalog at path if set. Otherwise it returns the embedded catalog,
+// merged with the catalog cached by `heighliner go-versions update`, if any.
+func LoadGoCatalog(path string) (GoCatalog, error) {
+	if path != "" {
+		bz, err := os.ReadFile(path)
+		if err != nil {
+			return GoCatalog{}, fmt.Errorf("error reading go versions: %w", err)
+		}
+		c, err := ParseGoCatalog(bz)
+		if err != nil {
+			return c, fmt.Errorf("%s: %w", path, err)
+		}
+		return c, nil
+	}
+
+	c, err := EmbeddedGoCatalog()
+	if err != nil {
+		return c, err
+	}
+	cachePath, err := GoCatalogCachePath()
+	if err != nil {
+		return c, nil
+	}
+	bz, err := os.ReadFile(cachePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, fmt.Errorf("error reading cached go versions: %w", err)
+	}
+	cached, err := ParseGoCatalog(bz)
+	if err != nil {
+		return c, fmt.Errorf("%s: %w", cachePath, err)
+	}
+	return c.Merge(cached), nil
+}
+
+// Write writes the catalog as indented JSON to path, creating its directory.
+func (c GoCatalog) Write(path string) error {
+	bz, err := json.MarshalIndent(c.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating directory for go versions: %w", err)
+	}
+	if err := os.WriteFile(path, append(bz, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing go versions: %w", err)
+	}
+	return nil
+}
+
+// UseGoCatalog sets the go versions used to pick go images to the catalog.
+func UseGoCatalog(c GoCatalog) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c = c.sorted()
+	images := make(map[string]GoVersion, len(c.Versions))
+	for _, v := range c.Versions {
+		images[v.Minor] = GoVersion{Version: v.Version, AlpineVersion: v.Alpine}
+	}
+	latest := c.Versions[len(c.Versions)-1]
+
+	GoImageForVersion = images
+	LatestAlpineImageVersion = c.LatestAlpine
+	GoDefaultVersion = latest.Version
+	GoDefaultImage = images[latest.Minor].withImage().Image
+	return nil
+}
@@ -0,0 +1,69 @@
+package builder_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoCatalogUpdate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"version": "go1.26.1", "stable": true},
+			{"version": "go1.25.9", "stable": true},
+			{"version": "go1.27rc1", "stable": false}
+		]`))
+	}))
+	t.Cleanup(srv.Close)
+
+	versions, err := builder.FetchGoReleases(context.Background(), srv.URL)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1.26.1", "1.25.9"}, versions)
+
+	catalog, err := builder.EmbeddedGoCatalog()
+	require.NoError(t, err)
+	published := func(ctx context.Context, goVersion string, alpineVersion string) (bool, error) {
+		return goVersion != "1.24.99", nil
+	}
+	updated, changes, err := catalog.Update(context.Background(), append(versions, "1.17.13", "1.24.99"), published)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"go 1.26: added 1.26.1 with alpine " + catalog.LatestAlpine,
+		"go 1.25: 1.25.7 -> 1.25.9",
+		"go 1.24: skipped 1.24.99, golang:1.24.99-alpine" + catalog.LatestAlpine + " is not published",
+	}, changes)
+	require.NoError(t, updated.Validate())
+	require.Len(t, updated.Versions, len(catalog.Versions)+1)
+
+	// the update is cached, and merged with the embedded catalog when loaded.
+	path := filepath.Join(t.TempDir(), "go_versions.json")
+	require.NoError(t, updated.Write(path))
+	loaded, err := builder.LoadGoCatalog(path)
+	require.NoError(t, err)
+	require.Equal(t, updated, loaded)
+	require.Equal(t, updated, catalog.Merge(loaded))
+
+	require.NoError(t, builder.UseGoCatalog(loaded))
+	t.Cleanup(func() { require.NoError(t, builder.UseGoCatalog(catalog)) })
+	require.Equal(t, "1.26.1", builder.GoDefaultVersion)
+	require.Equal(t, "1.26.1-alpine"+catalog.LatestAlpine, builder.GoDefaultImage)
+	goVer := builder.GetImageAndVersionForGoVersion("1.25", "")
+	require.Equal(t, "1.25.9", goVer.Version)
+	require.Equal(t, "1.25.9-alpine3.23", goVer.Image)
+}
+
+func TestGoCatalogValidate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go_versions.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"latestAlpine": "3.23",
+		"versions": [{"minor": "1.25", "version": "1.24.3", "alpine": "3.23"}]
+	}`), 0644))
+	_, err := builder.LoadGoCatalog(path)
+	require.ErrorContains(t, err, `go version "1.24.3" is not a release of go 1.25`)
+}
@@ -1,13 +1,17 @@
 package builder
 
 import (
+	"fmt"
+	"regexp"
 	"sort"
 	"strings"
 
+	"golang.org/x/mod/modfile"
 	"golang.org/x/mod/semver"
 )
 
-// To add a new go version, add to `ADD NEW GO VERSION` [1], [2], [3], and [4]
+// Deprecated: the latest patch release of each go minor version is loaded from the go version catalog,
+// use GoImageForVersion instead. These are the versions of the catalog heighliner was first released with.
 const (
 	Go118Version = "1.18.10"
 	Go119Version = "1.19.13"
@@ -17,16 +21,14 @@ const (
 	Go123Version = "1.23.12"
 	Go124Version = "1.24.13"
 	Go125Version = "1.25.7"
-	// ADD NEW GO VERSION [1] - latest patch release for each major/minor
-
-	// When updating alpine image, ensure all golang build image combinations below exist
-	LatestAlpineImageVersion = "3.23"
 )
 
+// The go versions are loaded from the go version catalog, see go_versions.json and LoadGoCatalog.
 var (
-	// ADD NEW GO VERSION [3] - update GoDefaultVersion to latest; GoDefaultImage is set from map in init()
-	GoDefaultVersion = Go125Version
-	GoDefaultImage   string // default image for cosmos go builds if go.mod parse fails
+	// LatestAlpineImageVersion is the alpine version used for go versions which are not in the catalog.
+	LatestAlpineImageVersion string
+	GoDefaultVersion         string // latest go version in the catalog
+	GoDefaultImage           string // default image for cosmos go builds if go.mod parse fails
 )
 
 func GolangAlpineImage(goVersion, alpineVersion string) string {
@@ -48,20 +50,16 @@ func (v GoVersion) withImage() GoVersion {
 }
 
 // GoImageForVersion maps major.minor (e.g. "1.23") to version info. Store Version+AlpineVersion only; use withImage() when Image is needed.
-var GoImageForVersion = map[string]GoVersion{
-	"1.18": {Version: Go118Version, AlpineVersion: "3.17"},
-	"1.19": {Version: Go119Version, AlpineVersion: "3.18"},
-	"1.20": {Version: Go120Version, AlpineVersion: "3.19"},
-	"1.21": {Version: Go121Version, AlpineVersion: "3.20"},
-	"1.22": {Version: Go122Version, AlpineVersion: "3.21"},
-	"1.23": {Version: Go123Version, AlpineVersion: "3.22"},
-	"1.24": {Version: Go124Version, AlpineVersion: LatestAlpineImageVersion},
-	"1.25": {Version: Go125Version, AlpineVersion: LatestAlpineImageVersion},
-	// ADD NEW GO VERSION [4]
-}
+var GoImageForVersion map[string]GoVersion
 
 func init() {
-	GoDefaultImage = GoImageForVersion["1.25"].withImage().Image
+	catalog, err := EmbeddedGoCatalog()
+	if err != nil {
+		panic(err)
+	}
+	if err := UseGoCatalog(catalog); err != nil {
+		panic(fmt.Errorf("invalid embedded go versions: %w", err))
+	}
 }
 
 // goVersionsDesc returns the go major versions in GoImageForVersion in descending order.
@@ -107,3 +105,52 @@ func GetImageAndVersionForGoVersion(goVersion string, alpineVersion string) GoVe
 	// If unable to find go version in mapping, return default
 	return GoVersion{Version: GoDefaultVersion, Image: GoDefaultImage, AlpineVersion: LatestAlpineImageVersion}
 }
+
+// toolchainVersion matches the go version of a toolchain directive, e.g. go1.22.5 or go1.22.5+auto.
+var toolchainVersion = regexp.MustCompile(`^go(1\.\d+(?:\.\d+)?)(?:$|[-+])`)
+
+// ResolveGoVersion returns the go version declared by the go.mod and go.work files of a build, and which
+// directive it was read from. A go.work takes precedence over go.mod, as the go command uses it in
+// workspace mode. Within a file, the toolchain directive takes precedence over the go directive,
+// unless it is older. Either file may be nil. Returns "" if no go version is declared.
+func ResolveGoVersion(modFile *modfile.File, workFile *modfile.WorkFile) (version string, source string) {
+	if workFile != nil {
+		var goVersion, toolchain string
+		if workFile.Go != nil {
+			goVersion = workFile.Go.Version
+		}
+		if workFile.Toolchain != nil {
+			toolchain = workFile.Toolchain.Name
+		}
+		if version, directive := preferToolchain(goVersion, toolchain); version != "" {
+			return version, "go.work " + directive
+		}
+	}
+	if modFile != nil {
+		var goVersion, toolchain string
+		if modFile.Go != nil {
+			goVersion = modFile.Go.Version
+		}
+		if modFile.Toolchain != nil {
+			toolchain = modFile.Toolchain.Name
+		}
+		if version, directive := preferToolchain(goVersion, toolchain); version != "" {
+			return version, "go.mod " + directive
+		}
+	}
+	return "", ""
+}
+
+// preferToolchain returns the version of the toolchain directive if it is set and not older than
+// the go directive, which the go command would ignore, otherwise the version of the go directive.
+func preferToolchain(goVersion string, toolchain string) (version string, directive string) {
+	if m := toolchainVersion.FindStringSubmatch(toolchain); m != nil {
+		if goVersion == "" || semver.Compare("v"+m[1], "v"+goVersion) >= 0 {
+			return m[1], "toolchain"
+		}
+	}
+	if goVersion != "" {
+		return goVersion, "go"
+	}
+	return "", ""
+}
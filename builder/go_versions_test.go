@@ -5,6 +5,7 @@ import (
 
 	"github.com/strangelove-ventures/heighliner/builder"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/modfile"
 )
 
 func TestGoVersions(t *testing.T) {
@@ -61,3 +62,82 @@ func TestGoVersions(t *testing.T) {
 	require.Equal(t, "1.24.1-alpine3.23", goVer.Image)
 	require.Equal(t, "", goVer.AlpineVersion)
 }
+
+func TestResolveGoVersion(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		goMod      string
+		goWork     string
+		wantVer    string
+		wantSource string
+	}{
+		{
+			name:       "go directive",
+			goMod:      "module chain\n\ngo 1.21\n",
+			wantVer:    "1.21",
+			wantSource: "go.mod go",
+		},
+		{
+			name:       "toolchain directive",
+			goMod:      "module chain\n\ngo 1.22\n\ntoolchain go1.22.5\n",
+			wantVer:    "1.22.5",
+			wantSource: "go.mod toolchain",
+		},
+		{
+			name:       "toolchain with suffix",
+			goMod:      "module chain\n\ngo 1.22.0\n\ntoolchain go1.23.4+auto\n",
+			wantVer:    "1.23.4",
+			wantSource: "go.mod toolchain",
+		},
+		{
+			name:       "toolchain older than go is ignored",
+			goMod:      "module chain\n\ngo 1.23.1\n\ntoolchain go1.22.5\n",
+			wantVer:    "1.23.1",
+			wantSource: "go.mod go",
+		},
+		{
+			name:       "toolchain default is ignored",
+			goMod:      "module chain\n\ngo 1.22.0\n\ntoolchain default\n",
+			wantVer:    "1.22.0",
+			wantSource: "go.mod go",
+		},
+		{
+			name:       "go.work takes precedence",
+			goMod:      "module chain\n\ngo 1.21\n\ntoolchain go1.21.13\n",
+			goWork:     "go 1.22\n\nuse ./app\n",
+			wantVer:    "1.22",
+			wantSource: "go.work go",
+		},
+		{
+			name:       "go.work toolchain",
+			goMod:      "module chain\n\ngo 1.22\n",
+			goWork:     "go 1.22\n\ntoolchain go1.22.8\n\nuse ./app\n",
+			wantVer:    "1.22.8",
+			wantSource: "go.work toolchain",
+		},
+		{
+			name:       "go.work without versions",
+			goMod:      "module chain\n\ngo 1.22\n",
+			goWork:     "use ./app\n",
+			wantVer:    "1.22",
+			wantSource: "go.mod go",
+		},
+		{
+			name:  "no versions",
+			goMod: "module chain\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			modFile, err := modfile.Parse("go.mod", []byte(tt.goMod), nil)
+			require.NoError(t, err)
+			var workFile *modfile.WorkFile
+			if tt.goWork != "" {
+				workFile, err = modfile.ParseWork("go.work", []byte(tt.goWork), nil)
+				require.NoError(t, err)
+			}
+			ver, source := builder.ResolveGoVersion(modFile, workFile)
+			require.Equal(t, tt.wantVer, ver)
+			require.Equal(t, tt.wantSource, source)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package builder
+
+import (
+	"context"
+	"io"
+	"regexp"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/mod/modfile"
+)
+
+// majorVersionSuffix matches the major version suffix of module paths, e.g. /v2.
+var majorVersionSuffix = regexp.MustCompile(`/v[0-9]+$`)
+
+// ModuleVersion is a module required by a go.mod, along with the module it is replaced with, if any.
+// ReplaceVersion is empty for replacements with local directories.
+type ModuleVersion struct {
+	Path           string `json:"path"`
+	Version        string `json:"version"`
+	ReplacePath    string `json:"replacePath,omitempty"`
+	ReplaceVersion string `json:"replaceVersion,omitempty"`
+}
+
+// Replaced returns whether the module is replaced.
+func (m ModuleVersion) Replaced() bool {
+	return m.ReplacePath != ""
+}
+
+// EffectiveVersion returns the version of the module which is built, that of the replacement if replaced.
+func (m ModuleVersion) EffectiveVersion() string {
+	if m.ReplaceVersion != "" {
+		return m.ReplaceVersion
+	}
+	return m.Version
+}
+
+// FindModule returns the module required by modFile with path, of any major version, e.g. github.com/cosmos/ibc-go
+// matches github.com/cosmos/ibc-go/v8, resolving its replace directive.
+func FindModule(modFile *modfile.File, path string) (ModuleVersion, bool) {
+	var found ModuleVersion
+	for _, require := range modFile.Require {
+		if trimMajorVersion(require.Mod.Path) == trimMajorVersion(path) {
+			found = ModuleVersion{Path: require.Mod.Path, Version: require.Mod.Version}
+		}
+	}
+	if found.Path == "" {
+		return found, false
+	}
+	return withReplace(modFile, found), true
+}
+
+// RequiredModules returns all modules required by modFile, with their replace directives resolved.
+func RequiredModules(modFile *modfile.File) []ModuleVersion {
+	modules := make([]ModuleVersion, 0, len(modFile.Require))
+	for _, require := range modFile.Require {
+		modules = append(modules, withReplace(modFile, ModuleVersion{Path: require.Mod.Path, Version: require.Mod.Version}))
+	}
+	return modules
+}
+
+// withReplace sets the replacement of m from the replace directives of modFile.
+func withReplace(modFile *modfile.File, m ModuleVersion) ModuleVersion {
+	for _, replace := range modFile.Replace {
+		if replace.Old.Path == m.Path && (replace.Old.Version == "" || replace.Old.Version == m.Version) {
+			m.ReplacePath = replace.New.Path
+			m.ReplaceVersion = replace.New.Version
+		}
+	}
+	return m
+}
+
+func trimMajorVersion(path string) string {
+	return majorVersionSuffix.ReplaceAllString(path, "")
+}
+
+// ChainModFiles are the go.mod and go.work of a chain at a ref.
+type ChainModFiles struct {
+	Mod     *modfile.File
+	Work    *modfile.WorkFile // nil if the repo has no go.work
+	Members []*modfile.File   // go.mod files of the go.work members other than the build dir
+	Commit  string
+	RefName plumbing.ReferenceName // empty for commits
+}
+
+// GoVersion returns the go version declared by the go.mod and go.work.
+func (f ChainModFiles) GoVersion() string {
+	version, _ := ResolveGoVersion(f.Mod, f.Work)
+	return version
+}
+
+// FetchChainModFiles returns the go.mod of the build dir and the go.work of the chain at ref, which is a tag,
+// a branch, a commit or HEAD for the default branch. Only these files are fetched, the repo is not cloned.
+// Warnings, e.g. about the file cache, are written to progress.
+func FetchChainModFiles(ctx context.Context, progress io.Writer, chain ChainNodeConfig, ref string, secrets BuildSecrets) (ChainModFiles, error) {
+	repoHost := chain.RepoHost
+	if repoHost == "" {
+		repoHost = "github.com"
+	}
+	auth, err := secrets.withChainConfig(chain).gitAuth(repoHost, &knownHosts{})
+	if err != nil {
+		return ChainModFiles{}, err
+	}
+	repoFS, commit, refName, err := repoFilesystem(ctx, progress, chain, repoHost, auth, ref, false)
+	if err != nil {
+		return ChainModFiles{}, err
+	}
+	files, err := getModFiles(repoFS, chain.BuildDir)
+	if err != nil {
+		return ChainModFiles{}, err
+	}
+	files.Commit, files.RefName = commit, refName
+	return files, nil
+}
+
+// LocalChainModFiles returns the go.mod of the build dir and the go.work of the chain in the current directory.
+func LocalChainModFiles(chain ChainNodeConfig) (ChainModFiles, error) {
+	repoFS, commit, _, err := repoFilesystem(context.Background(), io.Discard, chain, "", nil, "", true)
+	if err != nil {
+		return ChainModFiles{}, err
+	}
+	files, err := getModFiles(repoFS, chain.BuildDir)
+	if err != nil {
+		return ChainModFiles{}, err
+	}
+	files.Commit = commit
+	return files, nil
+}
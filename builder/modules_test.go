@@ -0,0 +1,60 @@
+package builder_test
+
+import (
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/modfile"
+)
+
+func TestFindModule(t *testing.T) {
+	modFile, err := modfile.Parse("go.mod", []byte(`module example.com/chain
+
+go 1.22
+
+require (
+	github.com/cosmos/cosmos-sdk v0.50.9
+	github.com/cosmos/cosmos-sdk/store v1.1.0
+	github.com/cosmos/ibc-go/v8 v8.5.0
+	github.com/cometbft/cometbft v0.38.12
+)
+
+replace (
+	github.com/cosmos/cosmos-sdk => github.com/strangelove-ventures/cosmos-sdk v0.50.9-lsm
+	github.com/cometbft/cometbft v0.38.11 => github.com/cometbft/cometbft v0.38.10
+	github.com/cosmos/ibc-go/v8 => ../ibc-go
+)
+`), nil)
+	require.NoError(t, err)
+
+	m, ok := builder.FindModule(modFile, "github.com/cosmos/cosmos-sdk")
+	require.True(t, ok)
+	require.Equal(t, builder.ModuleVersion{
+		Path:           "github.com/cosmos/cosmos-sdk",
+		Version:        "v0.50.9",
+		ReplacePath:    "github.com/strangelove-ventures/cosmos-sdk",
+		ReplaceVersion: "v0.50.9-lsm",
+	}, m)
+	require.Equal(t, "v0.50.9-lsm", m.EffectiveVersion())
+
+	// major versions match, local replacements keep the required version.
+	m, ok = builder.FindModule(modFile, "github.com/cosmos/ibc-go")
+	require.True(t, ok)
+	require.Equal(t, "github.com/cosmos/ibc-go/v8", m.Path)
+	require.True(t, m.Replaced())
+	require.Equal(t, "v8.5.0", m.EffectiveVersion())
+
+	// replacements of other versions do not apply.
+	m, ok = builder.FindModule(modFile, "github.com/cometbft/cometbft")
+	require.True(t, ok)
+	require.False(t, m.Replaced())
+
+	_, ok = builder.FindModule(modFile, "github.com/CosmWasm/wasmd")
+	require.False(t, ok)
+
+	modules := builder.RequiredModules(modFile)
+	require.Len(t, modules, 4)
+	require.Equal(t, "github.com/cosmos/cosmos-sdk/store", modules[1].Path)
+	require.False(t, modules[1].Replaced())
+}
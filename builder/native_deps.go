@@ -0,0 +1,294 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/modfile"
+)
+
+// NativeDepsNone disables all native dependency detectors of a chain.
+const NativeDepsNone = "none"
+
+// DefaultNativeDeps are the detectors used for chains which do not configure native-deps.
+var DefaultNativeDeps = []string{"wasmvm"}
+
+// nativeArchs are the architectures, as reported by uname -m, which native dependencies are downloaded for.
+var nativeArchs = []string{"x86_64", "aarch64"}
+
+// checksumsTimeout limits fetching the published checksums of a native dependency release.
+const checksumsTimeout = 30 * time.Second
+
+// NativeDependency is a native library which a chain links against, downloaded into the library
+// directory of the build.
+type NativeDependency struct {
+	Name      string // name of the detector
+	Module    string // go module the dependency is provided by, e.g. github.com/CosmWasm/wasmvm/v2
+	Version   string
+	BuildArgs map[string]string
+	Downloads []NativeDownload
+}
+
+// NativeDownload is a file downloaded in the build for an architecture, verified against SHA256 if set.
+type NativeDownload struct {
+	Arch   string   `json:"arch"` // x86_64 or aarch64
+	URL    string   `json:"url"`
+	Dest   string   `json:"dest"`            // file name in the library directory, shared (.so) libraries are also installed in the final image
+	Links  []string `json:"links,omitempty"` // additional names of the file in the library directory
+	SHA256 string   `json:"sha256,omitempty"`
+}
+
+// NativeDependencyDetector detects a native dependency of a chain from its go.mod.
+type NativeDependencyDetector interface {
+	// Detect returns the dependency if the chain needs it, or nil. The checksums of downloads are set
+	// by the builder from ChecksumsURL.
+	Detect(modFile *modfile.File) (*NativeDependency, error)
+	// ChecksumsURL returns the url of the sha256sum formatted checksums published for the downloads of dep,
+	// or an empty string if none are published.
+	ChecksumsURL(dep *NativeDependency) string
+}
+
+// nativeDetectors are the registered detectors by name. There are none for rocksdb and cleveldb, which are
+// enabled by build tags rather than by go.mod and link against libraries built from source, or for Ledger HID,
+// which cgo compiles from the module sources with the eudev headers of the build image. Chains set these up
+// with build-env and pre-build.
+var (
+	nativeDetectorsMu sync.RWMutex
+	nativeDetectors   = map[string]NativeDependencyDetector{
+		"wasmvm":        WasmvmDetector{},
+		"wasmvm-shared": WasmvmSharedDetector{},
+	}
+)
+
+// RegisterNativeDependencyDetector makes a detector available to chains as name in native-deps.
+func RegisterNativeDependencyDetector(name string, detector NativeDependencyDetector) {
+	nativeDetectorsMu.Lock()
+	defer nativeDetectorsMu.Unlock()
+	nativeDetectors[name] = detector
+}
+
+// NativeDependencyDetectors returns the names of the registered detectors, sorted.
+func NativeDependencyDetectors() []string {
+	nativeDetectorsMu.RLock()
+	defer nativeDetectorsMu.RUnlock()
+	names := make([]string, 0, len(nativeDetectors))
+	for name := range nativeDetectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func nativeDependencyDetector(name string) (NativeDependencyDetector, bool) {
+	nativeDetectorsMu.RLock()
+	defer nativeDetectorsMu.RUnlock()
+	detector, ok := nativeDetectors[name]
+	return detector, ok
+}
+
+// DetectNativeDependencies runs the detectors named in names, or DefaultNativeDeps if empty, on modFile,
+// and sets the checksums of their downloads from the published checksums. Releases without published
+// checksums are an error, unless allowUnverified is set.
+func DetectNativeDependencies(ctx context.Context, modFile *modfile.File, names []string, allowUnverified bool) ([]NativeDependency, error) {
+	if len(names) == 0 {
+		names = DefaultNativeDeps
+	}
+	var deps []NativeDependency
+	for _, name := range names {
+		if name == NativeDepsNone {
+			continue
+		}
+		detector, ok := nativeDependencyDetector(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown native dependency detector %q, must be one of: %s", name, strings.Join(NativeDependencyDetectors(), ", "))
+		}
+		dep, err := detector.Detect(modFile)
+		if err != nil {
+			return nil, fmt.Errorf("error detecting native dependency %s: %w", name, err)
+		}
+		if dep == nil {
+			continue
+		}
+		dep.Name = name
+		if err := setChecksums(ctx, dep, detector.ChecksumsURL(dep), allowUnverified); err != nil {
+			return nil, err
+		}
+		deps = append(deps, *dep)
+	}
+	return deps, nil
+}
+
+// setChecksums sets the checksums of the downloads of dep from the checksums file at url.
+// If no checksums are published, downloads are only left unverified if allowUnverified is set.
+func setChecksums(ctx context.Context, dep *NativeDependency, url string, allowUnverified bool) error {
+	if len(dep.Downloads) == 0 {
+		return nil
+	}
+	if url == "" {
+		if !allowUnverified {
+			return fmt.Errorf("%s %s does not publish checksums, set native-deps-unverified to download it unverified", dep.Module, dep.Version)
+		}
+		return nil
+	}
+	checksums, err := fetchChecksums(ctx, url)
+	if errors.Is(err, errNoChecksums) {
+		if !allowUnverified {
+			return fmt.Errorf("%s %s does not publish checksums at %s, set native-deps-unverified to download it unverified", dep.Module, dep.Version, url)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error fetching checksums of %s %s: %w", dep.Module, dep.Version, err)
+	}
+	for i, dl := range dep.Downloads {
+		file := dl.URL[strings.LastIndex(dl.URL, "/")+1:]
+		sum, ok := checksums[file]
+		if !ok {
+			return fmt.Errorf("no checksum for %s in %s", file, url)
+		}
+		dep.Downloads[i].SHA256 = sum
+	}
+	return nil
+}
+
+var errNoChecksums = errors.New("no checksums published")
+
+// fetchChecksums returns the checksums of a sha256sum formatted file, by file name.
+func fetchChecksums(ctx context.Context, url string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, checksumsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, errNoChecksums
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// binary mode checksums prefix the file name with *
+		checksums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+	}
+	return checksums, scanner.Err()
+}
+
+// nativeDownloadsBuildArg returns the NATIVE_DOWNLOADS build arg, one download per line in the form
+// "arch sha256 url dest [links...]", with "-" for downloads without checksum.
+func nativeDownloadsBuildArg(deps []NativeDependency) string {
+	var lines []string
+	for _, dep := range deps {
+		for _, dl := range dep.Downloads {
+			sum := dl.SHA256
+			if sum == "" {
+				sum = "-"
+			}
+			fields := append([]string{dl.Arch, sum, dl.URL, dl.Dest}, dl.Links...)
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// WasmvmDetector detects CosmWasm wasmvm, including forks which replace it, e.g. sei-protocol/sei-wasmvm,
+// and downloads its static muslc library from the release of the module repo.
+type WasmvmDetector struct{}
+
+const wasmvmModule = "github.com/CosmWasm/wasmvm"
+
+func (WasmvmDetector) Detect(modFile *modfile.File) (*NativeDependency, error) {
+	repo, version := getWasmvmVersion(modFile)
+	if version == "" {
+		return nil, nil
+	}
+
+	dep := &NativeDependency{
+		Module:    repo,
+		Version:   version,
+		BuildArgs: map[string]string{"WASMVM_VERSION": repo + " " + version},
+	}
+	for _, arch := range nativeArchs {
+		dep.Downloads = append(dep.Downloads, NativeDownload{
+			Arch: arch,
+			URL:  fmt.Sprintf("https://%s/releases/download/%s/libwasmvm_muslc.%s.a", repo, version, arch),
+			Dest: "libwasmvm_muslc.a",
+			// the go build links the library for either architecture name, depending on the wasmvm version.
+			Links: []string{"libwasmvm.x86_64.a", "libwasmvm_muslc.x86_64.a", "libwasmvm.aarch64.a", "libwasmvm_muslc.aarch64.a"},
+		})
+	}
+	return dep, nil
+}
+
+func (WasmvmDetector) ChecksumsURL(dep *NativeDependency) string {
+	return fmt.Sprintf("https://%s/releases/download/%s/checksums.txt", dep.Module, dep.Version)
+}
+
+// getWasmvmVersion returns the repo and version of wasmvm from the mod file, following replaces
+// with other modules, e.g. forks.
+func getWasmvmVersion(modFile *modfile.File) (string, string) {
+	wasmvmRepo, wasmvmVersion := wasmvmModule, ""
+	if m, ok := FindModule(modFile, wasmvmModule); ok {
+		wasmvmRepo, wasmvmVersion = trimMajorVersion(m.Path), m.Version
+		if m.ReplaceVersion != "" {
+			wasmvmRepo, wasmvmVersion = trimMajorVersion(m.ReplacePath), m.ReplaceVersion
+		}
+	}
+
+	return wasmvmRepo, wasmvmVersion
+}
+
+// WasmvmSharedDetector detects CosmWasm wasmvm like WasmvmDetector, but downloads the shared libwasmvm library
+// of the release, as linked by wasmvm v2 without the muslc build tag, and installs it in the final image.
+type WasmvmSharedDetector struct{}
+
+func (WasmvmSharedDetector) Detect(modFile *modfile.File) (*NativeDependency, error) {
+	repo, version := getWasmvmVersion(modFile)
+	if version == "" {
+		return nil, nil
+	}
+
+	dep := &NativeDependency{
+		Module:    repo,
+		Version:   version,
+		BuildArgs: map[string]string{"WASMVM_VERSION": repo + " " + version},
+	}
+	for _, arch := range nativeArchs {
+		lib := fmt.Sprintf("libwasmvm.%s.so", arch)
+		dep.Downloads = append(dep.Downloads, NativeDownload{
+			Arch: arch,
+			URL:  fmt.Sprintf("https://%s/releases/download/%s/%s", repo, version, lib),
+			Dest: lib,
+		})
+	}
+	return dep, nil
+}
+
+func (WasmvmSharedDetector) ChecksumsURL(dep *NativeDependency) string {
+	return WasmvmDetector{}.ChecksumsURL(dep)
+}
@@ -0,0 +1,125 @@
+package builder_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/modfile"
+)
+
+func TestWasmvmDetector(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		goMod       string
+		wantModule  string
+		wantVersion string
+	}{
+		{"none", "module a\n\nrequire github.com/cosmos/cosmos-sdk v0.50.1\n", "", ""},
+		{"require", "module a\n\nrequire github.com/CosmWasm/wasmvm v1.5.2\n", "github.com/CosmWasm/wasmvm", "v1.5.2"},
+		{"v2", "module a\n\nrequire github.com/CosmWasm/wasmvm/v2 v2.1.0\n", "github.com/CosmWasm/wasmvm", "v2.1.0"},
+		{
+			"fork",
+			"module a\n\nrequire github.com/CosmWasm/wasmvm v1.5.2\n\nreplace github.com/CosmWasm/wasmvm => github.com/sei-protocol/sei-wasmvm v1.5.4-sei.0.0.1\n",
+			"github.com/sei-protocol/sei-wasmvm", "v1.5.4-sei.0.0.1",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			modFile, err := modfile.Parse("go.mod", []byte(tt.goMod), nil)
+			require.NoError(t, err)
+			dep, err := builder.WasmvmDetector{}.Detect(modFile)
+			require.NoError(t, err)
+			if tt.wantVersion == "" {
+				require.Nil(t, dep)
+				return
+			}
+			require.Equal(t, tt.wantModule, dep.Module)
+			require.Equal(t, tt.wantVersion, dep.Version)
+			require.Equal(t, tt.wantModule+" "+tt.wantVersion, dep.BuildArgs["WASMVM_VERSION"])
+			require.Len(t, dep.Downloads, 2)
+			require.Equal(t, "https://"+tt.wantModule+"/releases/download/"+tt.wantVersion+"/libwasmvm_muslc.x86_64.a", dep.Downloads[0].URL)
+			require.Equal(t, "libwasmvm_muslc.a", dep.Downloads[0].Dest)
+		})
+	}
+}
+
+func TestWasmvmSharedDetector(t *testing.T) {
+	modFile, err := modfile.Parse("go.mod", []byte("module a\n\nrequire github.com/CosmWasm/wasmvm/v2 v2.1.0\n"), nil)
+	require.NoError(t, err)
+	dep, err := builder.WasmvmSharedDetector{}.Detect(modFile)
+	require.NoError(t, err)
+	require.Len(t, dep.Downloads, 2)
+	require.Equal(t, "https://github.com/CosmWasm/wasmvm/releases/download/v2.1.0/libwasmvm.aarch64.so", dep.Downloads[1].URL)
+	require.Equal(t, "libwasmvm.aarch64.so", dep.Downloads[1].Dest)
+	require.Equal(t, "https://github.com/CosmWasm/wasmvm/releases/download/v2.1.0/checksums.txt", builder.WasmvmSharedDetector{}.ChecksumsURL(dep))
+}
+
+// fakeDetector detects a library from a fake release server.
+type fakeDetector struct {
+	url string
+}
+
+func (d fakeDetector) Detect(modFile *modfile.File) (*builder.NativeDependency, error) {
+	return &builder.NativeDependency{
+		Module:    "example.com/fake",
+		Version:   modFile.Go.Version,
+		BuildArgs: map[string]string{"FAKE_VERSION": modFile.Go.Version},
+		Downloads: []builder.NativeDownload{
+			{Arch: "x86_64", URL: d.url + "/" + modFile.Go.Version + "/libfake.x86_64.a", Dest: "libfake.a"},
+		},
+	}, nil
+}
+
+func (d fakeDetector) ChecksumsURL(dep *builder.NativeDependency) string {
+	return d.url + "/" + dep.Version + "/checksums.txt"
+}
+
+func TestDetectNativeDependencies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1.22/checksums.txt":
+			_, _ = w.Write([]byte("abc123  libfake.x86_64.a\ndef456 *libfake.aarch64.a\n"))
+		case "/1.23/checksums.txt":
+			_, _ = w.Write([]byte("def456  libfake.aarch64.a\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	builder.RegisterNativeDependencyDetector("fake", fakeDetector{url: srv.URL})
+
+	ctx := context.Background()
+	detect := func(goVersion string, names ...string) ([]builder.NativeDependency, error) {
+		modFile, err := modfile.Parse("go.mod", []byte("module a\n\ngo "+goVersion+"\n"), nil)
+		require.NoError(t, err)
+		return builder.DetectNativeDependencies(ctx, modFile, names, false)
+	}
+
+	deps, err := detect("1.22", "fake")
+	require.NoError(t, err)
+	require.Len(t, deps, 1)
+	require.Equal(t, "fake", deps[0].Name)
+	require.Equal(t, "abc123", deps[0].Downloads[0].SHA256)
+
+	// releases without checksums fail, unless unverified downloads are allowed.
+	_, err = detect("1.21", "fake")
+	require.ErrorContains(t, err, "set native-deps-unverified")
+	modFile, err := modfile.Parse("go.mod", []byte("module a\n\ngo 1.21\n"), nil)
+	require.NoError(t, err)
+	deps, err = builder.DetectNativeDependencies(ctx, modFile, []string{"fake"}, true)
+	require.NoError(t, err)
+	require.Empty(t, deps[0].Downloads[0].SHA256)
+
+	_, err = detect("1.23", "fake")
+	require.ErrorContains(t, err, "no checksum for libfake.x86_64.a")
+
+	deps, err = detect("1.22", "none")
+	require.NoError(t, err)
+	require.Empty(t, deps)
+
+	_, err = detect("1.22", "unknown")
+	require.ErrorContains(t, err, `unknown native dependency detector "unknown"`)
+}
@@ -0,0 +1,311 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/strangelove-ventures/heighliner/docker"
+)
+
+// redactedBuildArgs are build args which contain secrets, so are not shown in plans.
+var redactedBuildArgs = []string{"CLONE_KEY"}
+
+const redacted = "<redacted>"
+
+// BuildPlan is a fully resolved chain node docker image build.
+type BuildPlan struct {
+	Chain            string
+	Ref              string
+	Commit           string // commit the ref resolves to, empty for local builds
+	Tag              string // image tag derived from the ref, without image name
+	DockerfileType   DockerfileType
+	DockerfileSource string // which embedded or local Dockerfile is used
+	Dockerfile       []byte
+	BuildArgs        map[string]string
+	WasmvmVersion    string
+	// NativeDependencies are the native libraries detected in go.mod, downloaded in the build.
+	NativeDependencies []NativeDependency
+	// Vulnerabilities are the known vulnerabilities of the go modules and go version, most severe first, if audited.
+	Vulnerabilities []Vulnerability
+	Tags            []string
+	Platforms       []string // multi-platform backends only, others build for their host platform
+	MultiPlatform   bool     // set if the backend builds for Platforms instead of its host platform
+	UseBuildKit     bool
+	Builder         string // name of the build backend
+	// CacheFrom are the build caches to import and CacheTo those to export, for backends which support them.
+	CacheFrom []docker.CacheOptions
+	CacheTo   []docker.CacheOptions
+	Push      bool
+	Secrets   BuildSecrets
+	// KnownHosts are the verified ssh host keys of the repo host, in known_hosts format, if cloned with ssh.
+	KnownHosts string
+	// UnauditedModules are the go modules replaced with local directories, which have no version to audit.
+	UnauditedModules []ModuleVersion
+
+	// GoModVersion is the go version from go.mod or go.work, if available, and GoModVersionSource
+	// the directive it was read from, e.g. "go.mod toolchain".
+	GoModVersion       string
+	GoModVersionSource string
+	// GoVersion is the go version to build with. GoVersionSource and AlpineVersionSource describe
+	// where its go and alpine versions are configured.
+	GoVersion           GoVersion
+	GoVersionSource     string
+	AlpineVersionSource string
+	// RustToolchain is the rust toolchain to build with, if pinned or declared by the repo, and
+	// RustImage the rust build image (cargo builds only).
+	RustToolchain       string
+	RustToolchainSource string
+	RustImage           string
+
+	// Err is set if the build could not be resolved, so would fail.
+	Err error
+}
+
+// RedactedBuildArgs returns the build args with secrets redacted.
+func (p BuildPlan) RedactedBuildArgs() map[string]string {
+	args := maps.Clone(p.BuildArgs)
+	for _, key := range redactedBuildArgs {
+		if args[key] != "" {
+			args[key] = redacted
+		}
+	}
+	return args
+}
+
+// Plan resolves all queued builds, in the order they would be built, without building them.
+// A plan is returned for every queued build, along with an error joining the errors of all
+// builds which could not be resolved.
+func (h *HeighlinerBuilder) Plan(ctx context.Context) ([]BuildPlan, error) {
+	var plans []BuildPlan
+	var errs []error
+	for _, chainConfig := range h.queueOrder() {
+		plan, err := h.resolveBuild(ctx, chainConfig, nil)
+		if err != nil {
+			err = fmt.Errorf("error resolving build for %s from ref: %s - %w", chainConfig.Build.Name, chainConfig.Ref, err)
+			plan.Err = err
+			errs = append(errs, err)
+		}
+		plans = append(plans, *plan)
+	}
+	return plans, errors.Join(errs...)
+}
+
+// queueOrder returns the queued builds in the order they are built, starting with latest for each chain.
+func (h *HeighlinerBuilder) queueOrder() []*ChainNodeDockerBuildConfig {
+	var ordered []*ChainNodeDockerBuildConfig
+	for i := 0; ; i++ {
+		foundForThisIndex := false
+		for _, queuedChainBuilds := range h.queue {
+			if i < len(queuedChainBuilds.ChainConfigs) {
+				ordered = append(ordered, &queuedChainBuilds.ChainConfigs[i])
+				foundForThisIndex = true
+			}
+		}
+		if !foundForThisIndex {
+			return ordered
+		}
+	}
+}
+
+type nativeDepJSON struct {
+	Name      string           `json:"name"`
+	Module    string           `json:"module"`
+	Version   string           `json:"version"`
+	Downloads []NativeDownload `json:"downloads"`
+}
+
+type buildPlanJSON struct {
+	Chain            string            `json:"chain"`
+	Ref              string            `json:"ref"`
+	Commit           string            `json:"commit,omitempty"`
+	Dockerfile       DockerfileType    `json:"dockerfile"`
+	DockerfileSource string            `json:"dockerfileSource"`
+	GoModVersion     string            `json:"goModVersion,omitempty"`
+	GoModSource      string            `json:"goModVersionSource,omitempty"`
+	GoVersion        string            `json:"goVersion,omitempty"`
+	GoImage          string            `json:"goImage,omitempty"`
+	GoVersionSource  string            `json:"goVersionSource,omitempty"`
+	AlpineSource     string            `json:"alpineVersionSource,omitempty"`
+	RustToolchain    string            `json:"rustToolchain,omitempty"`
+	RustSource       string            `json:"rustToolchainSource,omitempty"`
+	RustImage        string            `json:"rustImage,omitempty"`
+	WasmvmVersion    string            `json:"wasmvmVersion,omitempty"`
+	NativeDeps       []nativeDepJSON   `json:"nativeDependencies,omitempty"`
+	Vulnerabilities  []Vulnerability   `json:"vulnerabilities,omitempty"`
+	Unaudited        []ModuleVersion   `json:"unauditedModules,omitempty"`
+	Tags             []string          `json:"tags"`
+	Platforms        []string          `json:"platforms"`
+	BuildKit         bool              `json:"buildkit"`
+	Builder          string            `json:"builder"`
+	CacheFrom        []string          `json:"cacheFrom,omitempty"`
+	CacheTo          []string          `json:"cacheTo,omitempty"`
+	Push             bool              `json:"push"`
+	BuildArgs        map[string]string `json:"buildArgs"`
+	Secrets          []string          `json:"secrets,omitempty"`
+	Error            string            `json:"error,omitempty"`
+}
+
+// WritePlansJSON writes the plans as JSON, with secret build args redacted.
+func WritePlansJSON(w io.Writer, plans []BuildPlan) error {
+	out := make([]buildPlanJSON, len(plans))
+	for i, p := range plans {
+		out[i] = buildPlanJSON{
+			Chain:            p.Chain,
+			Ref:              p.Ref,
+			Commit:           p.Commit,
+			Dockerfile:       p.DockerfileType,
+			DockerfileSource: p.DockerfileSource,
+			GoModVersion:     p.GoModVersion,
+			GoModSource:      p.GoModVersionSource,
+			GoVersion:        p.GoVersion.Version,
+			GoImage:          p.GoVersion.Image,
+			GoVersionSource:  p.GoVersionSource,
+			AlpineSource:     p.AlpineVersionSource,
+			RustToolchain:    p.RustToolchain,
+			RustSource:       p.RustToolchainSource,
+			RustImage:        p.RustImage,
+			WasmvmVersion:    p.WasmvmVersion,
+			Vulnerabilities:  p.Vulnerabilities,
+			Unaudited:        p.UnauditedModules,
+			Tags:             p.Tags,
+			Platforms:        p.Platforms,
+			BuildKit:         p.UseBuildKit,
+			Builder:          p.Builder,
+			CacheFrom:        cacheStrings(p.CacheFrom),
+			CacheTo:          cacheStrings(p.CacheTo),
+			Push:             p.Push,
+			BuildArgs:        p.RedactedBuildArgs(),
+			Secrets:          p.Secrets.Describe(),
+		}
+		for _, dep := range p.NativeDependencies {
+			out[i].NativeDeps = append(out[i].NativeDeps, nativeDepJSON{
+				Name:      dep.Name,
+				Module:    dep.Module,
+				Version:   dep.Version,
+				Downloads: dep.Downloads,
+			})
+		}
+		if out[i].Platforms == nil {
+			out[i].Platforms = []string{}
+		}
+		if p.Err != nil {
+			out[i].Error = p.Err.Error()
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func cacheStrings(caches []docker.CacheOptions) []string {
+	var out []string
+	for _, c := range caches {
+		out = append(out, c.String())
+	}
+	return out
+}
+
+// WritePlansTable writes the plans as a human readable table, followed by the
+// build args of each plan with secret build args redacted.
+func WritePlansTable(w io.Writer, plans []BuildPlan) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHAIN\tREF\tDOCKERFILE\tGO\tWASMVM\tPLATFORMS\tPUSH\tTAGS")
+	for _, p := range plans {
+		goVersion := p.GoVersion.Image
+		if goVersion == "" {
+			goVersion = "-"
+		}
+		platforms := p.Builder + " host"
+		if p.MultiPlatform {
+			platforms = strings.Join(p.Platforms, ",")
+		}
+		tags := strings.Join(p.Tags, ",")
+		if p.Err != nil {
+			tags = "ERROR: " + p.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%t\t%s\n",
+			p.Chain, p.Ref, p.DockerfileType, goVersion, valueOrDash(p.WasmvmVersion), valueOrDash(platforms), p.Push, tags)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, p := range plans {
+		if p.Err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s %s (%s)\n", p.Chain, p.Ref, p.DockerfileSource)
+		for _, line := range p.toolchainSummary() {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+		args := p.RedactedBuildArgs()
+		for _, key := range slices.Sorted(maps.Keys(args)) {
+			if args[key] == "" {
+				continue
+			}
+			fmt.Fprintf(w, "  %s=%s\n", key, args[key])
+		}
+		if p.UseBuildKit {
+			for _, secret := range p.Secrets.Describe() {
+				fmt.Fprintf(w, "  secret %s\n", secret)
+			}
+		}
+		for _, cache := range p.CacheFrom {
+			fmt.Fprintf(w, "  cache from %s\n", cache)
+		}
+		for _, cache := range p.CacheTo {
+			fmt.Fprintf(w, "  cache to %s\n", cache)
+		}
+	}
+	return nil
+}
+
+// toolchainSummary describes the go and rust toolchains of the plan, and where they are configured,
+// its native dependencies and known vulnerabilities.
+func (p BuildPlan) toolchainSummary() []string {
+	var lines []string
+	if p.GoVersion.Version != "" {
+		line := fmt.Sprintf("go %s from %s", p.GoVersion.Version, p.GoVersionSource)
+		if p.GoModVersion != "" && p.GoVersionSource != p.GoModVersionSource {
+			line += fmt.Sprintf(" (%s declares %s)", p.GoModVersionSource, p.GoModVersion)
+		}
+		lines = append(lines, line, fmt.Sprintf("go image %s with alpine from %s", p.GoVersion.Image, p.AlpineVersionSource))
+	}
+	if p.RustToolchain != "" {
+		lines = append(lines, fmt.Sprintf("rust toolchain %s from %s", p.RustToolchain, p.RustToolchainSource))
+	}
+	if p.RustImage != "" {
+		lines = append(lines, "rust image "+p.RustImage)
+	}
+	for _, dep := range p.NativeDependencies {
+		verified := "verified"
+		for _, dl := range dep.Downloads {
+			if dl.SHA256 == "" {
+				verified = "unverified"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("native dependency %s %s %s (%d %s downloads)", dep.Name, dep.Module, dep.Version, len(dep.Downloads), verified))
+	}
+	if len(p.Vulnerabilities) > 0 {
+		lines = append(lines, fmt.Sprintf("%d known vulnerabilities in go modules, most severe %s %s (%s)",
+			len(p.Vulnerabilities), p.Vulnerabilities[0].ID, p.Vulnerabilities[0].Module, p.Vulnerabilities[0].Severity))
+	}
+	for _, m := range p.UnauditedModules {
+		lines = append(lines, fmt.Sprintf("unaudited module %s replaced with local directory %s", m.Path, m.ReplacePath))
+	}
+	return lines
+}
+
+func valueOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,314 @@
+package builder_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/strangelove-ventures/heighliner/docker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+	h := builder.NewHeighlinerBuilder(builder.HeighlinerDockerBuildConfig{
+		ContainerRegistry: "ghcr.io/strangelove-ventures/heighliner",
+		UseBuildKit:       true,
+		Platform:          "linux/amd64,linux/arm64",
+	}, 1, true, false)
+	h.AddToQueue(context.Background(), builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{{
+		Build: builder.ChainNodeConfig{
+			Name:       "penumbra",
+			Dockerfile: builder.DockerfileTypeImported,
+			BaseImage:  "ghcr.io/penumbra-zone/penumbra",
+			CloneKey:   "c2VjcmV0",
+			Platforms:  []string{"linux/amd64"},
+			Binaries:   []string{"/bin/pd", "/bin/pcli"},
+		},
+		Ref:    "v0.80.0",
+		Latest: true,
+	}}})
+
+	plans, err := h.Plan(context.Background())
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+
+	plan := plans[0]
+	require.Equal(t, "penumbra", plan.Chain)
+	require.Equal(t, []string{"linux/amd64"}, plan.Platforms)
+	require.Equal(t, []string{
+		"ghcr.io/strangelove-ventures/heighliner/penumbra:v0.80.0",
+		"ghcr.io/strangelove-ventures/heighliner/penumbra:latest",
+	}, plan.Tags)
+	require.True(t, plan.Push)
+	require.NotEmpty(t, plan.Dockerfile)
+	require.Equal(t, "/bin/pd,/bin/pcli", plan.BuildArgs["BINARIES"])
+	// buildkit builds receive the clone key as a secret, not a build arg.
+	require.NotContains(t, plan.BuildArgs, "CLONE_KEY")
+	require.Equal(t, "c2VjcmV0", plan.Secrets.CloneKey)
+
+	var buf bytes.Buffer
+	require.NoError(t, builder.WritePlansJSON(&buf, plans))
+	require.NotContains(t, buf.String(), "c2VjcmV0")
+	var decoded []map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, []any{"ssh: clone-key"}, decoded[0]["secrets"])
+
+	buf.Reset()
+	require.NoError(t, builder.WritePlansTable(&buf, plans))
+	require.NotContains(t, buf.String(), "c2VjcmV0")
+	require.Contains(t, buf.String(), "secret ssh: clone-key")
+}
+
+func TestPlanChainCloneKeyOverridesSSHAgent(t *testing.T) {
+	h := builder.NewHeighlinerBuilder(builder.HeighlinerDockerBuildConfig{
+		UseBuildKit: true,
+		Secrets:     builder.BuildSecrets{SSHAgent: true, GitToken: "token"},
+	}, 1, true, false)
+	h.AddToQueue(context.Background(), builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{{
+		Build: builder.ChainNodeConfig{
+			Name:       "penumbra",
+			Dockerfile: builder.DockerfileTypeImported,
+			CloneKey:   "c2VjcmV0",
+		},
+		Ref: "v0.80.0",
+	}}})
+
+	plans, err := h.Plan(context.Background())
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	// the deploy key of the chain is used instead of the ssh-agent of all builds.
+	require.False(t, plans[0].Secrets.SSHAgent)
+	require.Equal(t, "c2VjcmV0", plans[0].Secrets.CloneKey)
+	require.Equal(t, []string{"ssh: clone-key", "netrc: git token"}, plans[0].Secrets.Describe())
+}
+
+func TestPlanNativeCloneKey(t *testing.T) {
+	h := builder.NewHeighlinerBuilder(builder.HeighlinerDockerBuildConfig{}, 1, true, false)
+	h.AddToQueue(context.Background(), builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{{
+		Build: builder.ChainNodeConfig{
+			Name:       "penumbra",
+			Dockerfile: builder.DockerfileTypeImported,
+			CloneKey:   "c2VjcmV0",
+		},
+		Ref: "v0.80.0",
+	}}})
+
+	plans, err := h.Plan(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "c2VjcmV0", plans[0].BuildArgs["CLONE_KEY"])
+	require.Equal(t, "<redacted>", plans[0].RedactedBuildArgs()["CLONE_KEY"])
+
+	var buf bytes.Buffer
+	require.NoError(t, builder.WritePlansTable(&buf, plans))
+	require.NotContains(t, buf.String(), "c2VjcmV0")
+	require.Contains(t, buf.String(), "CLONE_KEY=<redacted>")
+	require.Contains(t, buf.String(), "docker host")
+}
+
+func TestPlanCommitRef(t *testing.T) {
+	url, commit := initRepo(t, true)
+	dir := strings.TrimPrefix(url, "file://")
+	builder.SetGitURLScheme(t, "file://")
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	chain := builder.ChainNodeConfig{
+		Name:               "chain",
+		Dockerfile:         builder.DockerfileTypeImported,
+		RepoHost:           filepath.Dir(filepath.Dir(dir)),
+		GithubOrganization: filepath.Base(filepath.Dir(dir)),
+		GithubRepo:         filepath.Base(dir),
+	}
+	for ref, fetchCommit := range map[string]string{
+		commit[:7]: "true",
+		commit:     "true",
+		"v1.0.0":   "",
+	} {
+		h := builder.NewHeighlinerBuilder(builder.HeighlinerDockerBuildConfig{}, 1, false, false)
+		h.AddToQueue(context.Background(), builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{{
+			Build: chain,
+			Ref:   ref,
+		}}})
+
+		plans, err := h.Plan(context.Background())
+		require.NoError(t, err, ref)
+		require.NoError(t, plans[0].Err, ref)
+		require.Equal(t, ref, plans[0].BuildArgs["VERSION"])
+		require.Equal(t, commit, plans[0].BuildArgs["COMMIT"], ref)
+		require.Equal(t, fetchCommit, plans[0].BuildArgs["FETCH_COMMIT"], ref)
+	}
+}
+
+func TestPlanTablePlatforms(t *testing.T) {
+	// multi-platform backends list their platforms, whether or not they use buildkit.
+	backend := &docker.FakeBuilder{Caps: docker.Capabilities{MultiPlatform: true}}
+	h := builder.NewHeighlinerBuilder(builder.HeighlinerDockerBuildConfig{Backend: backend, Platform: "linux/arm64"}, 1, true, false)
+	h.AddToQueue(context.Background(), builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{{
+		Build: builder.ChainNodeConfig{Name: "penumbra", Dockerfile: builder.DockerfileTypeImported},
+		Ref:   "v0.80.0",
+	}}})
+
+	plans, err := h.Plan(context.Background())
+	require.NoError(t, err)
+	require.False(t, plans[0].UseBuildKit)
+
+	var buf bytes.Buffer
+	require.NoError(t, builder.WritePlansTable(&buf, plans))
+	require.Contains(t, buf.String(), "linux/arm64")
+	require.NotContains(t, buf.String(), "host")
+}
+
+func TestPlanToolchainPrecedence(t *testing.T) {
+	chain := builder.ChainNodeConfig{
+		Name:          "chain",
+		Dockerfile:    builder.DockerfileTypeImported,
+		GoVersion:     "1.21",
+		RustToolchain: "1.75.0",
+	}
+	plan := func(buildConfig builder.HeighlinerDockerBuildConfig) builder.BuildPlan {
+		h := builder.NewHeighlinerBuilder(buildConfig, 1, true, false)
+		h.AddToQueue(context.Background(), builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{{
+			Build: chain,
+			Ref:   "v1.0.0",
+		}}})
+		plans, err := h.Plan(context.Background())
+		require.NoError(t, err)
+		return plans[0]
+	}
+
+	p := plan(builder.HeighlinerDockerBuildConfig{})
+	require.Equal(t, "1.21.13-alpine3.20", p.GoVersion.Image)
+	require.Equal(t, "chain config go-version", p.GoVersionSource)
+	require.Equal(t, "go version catalog", p.AlpineVersionSource)
+	require.Equal(t, "1.75.0", p.BuildArgs["RUST_TOOLCHAIN"])
+	require.Equal(t, "chain config rust-toolchain", p.RustToolchainSource)
+
+	// flags take precedence over the chain config.
+	p = plan(builder.HeighlinerDockerBuildConfig{GoVersion: "1.22.5", AlpineVersion: "3.20", RustToolchain: "nightly"})
+	require.Equal(t, "1.22.5-alpine3.20", p.GoVersion.Image)
+	require.Equal(t, "--go-version flag", p.GoVersionSource)
+	require.Equal(t, "--alpine-version flag", p.AlpineVersionSource)
+	require.Equal(t, "nightly", p.BuildArgs["RUST_TOOLCHAIN"])
+
+	var buf bytes.Buffer
+	require.NoError(t, builder.WritePlansTable(&buf, []builder.BuildPlan{p}))
+	require.Contains(t, buf.String(), "go 1.22.5 from --go-version flag")
+	require.Contains(t, buf.String(), "rust toolchain nightly from --rust-toolchain flag")
+}
+
+func TestPlanBuildCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "gaia", "v0.80.0"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "gaia", "v0.80.0", "index.json"), []byte("{}"), 0644))
+
+	tests := []struct {
+		name      string
+		cfg       builder.HeighlinerDockerBuildConfig
+		chain     builder.ChainNodeConfig
+		cacheFrom []string
+		cacheTo   []string
+	}{
+		{
+			name:      "registry cache derived from the image name",
+			cfg:       builder.HeighlinerDockerBuildConfig{ContainerRegistry: "ghcr.io/org", BuildCache: builder.BuildCacheRegistry},
+			chain:     builder.ChainNodeConfig{Name: "penumbra"},
+			cacheFrom: []string{"type=registry,ref=ghcr.io/org/penumbra:buildcache-v0.80.0"},
+			cacheTo:   []string{"type=registry,mode=max,ref=ghcr.io/org/penumbra:buildcache-v0.80.0"},
+		},
+		{
+			name:      "registry cache is not exported without pushing",
+			cfg:       builder.HeighlinerDockerBuildConfig{ContainerRegistry: "ghcr.io/org", SkipPush: true},
+			chain:     builder.ChainNodeConfig{Name: "penumbra", BuildCache: builder.BuildCacheRegistry, BuildCacheRef: "ghcr.io/org/cache:penumbra"},
+			cacheFrom: []string{"type=registry,ref=ghcr.io/org/cache:penumbra"},
+		},
+		{
+			name:  "registry cache requires a registry",
+			chain: builder.ChainNodeConfig{Name: "penumbra", BuildCache: builder.BuildCacheRegistry},
+		},
+		{
+			name:      "flag overrides chain",
+			cfg:       builder.HeighlinerDockerBuildConfig{ContainerRegistry: "ghcr.io/org", BuildCache: builder.BuildCacheInline},
+			chain:     builder.ChainNodeConfig{Name: "penumbra", BuildCache: builder.BuildCacheRegistry},
+			cacheFrom: []string{"type=registry,ref=ghcr.io/org/penumbra:v0.80.0"},
+			cacheTo:   []string{"type=inline"},
+		},
+		{
+			name:    "local cache is only imported once exported",
+			cfg:     builder.HeighlinerDockerBuildConfig{BuildCache: builder.BuildCacheLocal, BuildCacheDir: cacheDir},
+			chain:   builder.ChainNodeConfig{Name: "penumbra"},
+			cacheTo: []string{"type=local,dest=" + filepath.Join(cacheDir, "penumbra", "v0.80.0") + ",mode=max"},
+		},
+		{
+			name: "local cache with additional caches",
+			cfg: builder.HeighlinerDockerBuildConfig{
+				BuildCacheDir: cacheDir,
+				CacheFrom:     []docker.CacheOptions{{Type: "registry", Attrs: map[string]string{"ref": "ghcr.io/org/cache"}}},
+			},
+			chain: builder.ChainNodeConfig{Name: "gaia", BuildCache: builder.BuildCacheLocal},
+			cacheFrom: []string{
+				"type=local,src=" + filepath.Join(cacheDir, "gaia", "v0.80.0"),
+				"type=registry,ref=ghcr.io/org/cache",
+			},
+			cacheTo: []string{"type=local,dest=" + filepath.Join(cacheDir, "gaia", "v0.80.0") + ",mode=max"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Backend = &docker.FakeBuilder{Caps: docker.Capabilities{BuildKit: true, Cache: true}}
+			tt.chain.Dockerfile = builder.DockerfileTypeImported
+			h := builder.NewHeighlinerBuilder(tt.cfg, 1, true, false)
+			h.AddToQueue(context.Background(), builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{{
+				Build: tt.chain,
+				Ref:   "v0.80.0",
+			}}})
+
+			plans, err := h.Plan(context.Background())
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, builder.WritePlansJSON(&buf, plans))
+			var decoded []struct {
+				CacheFrom []string `json:"cacheFrom"`
+				CacheTo   []string `json:"cacheTo"`
+			}
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+			require.Equal(t, tt.cacheFrom, decoded[0].CacheFrom)
+			require.Equal(t, tt.cacheTo, decoded[0].CacheTo)
+		})
+	}
+}
+
+func TestPlanBuildCacheRace(t *testing.T) {
+	// local builds of the current directory, which has the go.mod of the chain.
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/chain\n\ngo 1.22\n"), 0o644))
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { _ = os.Chdir(cwd) })
+
+	for mode, cacheFrom := range map[builder.BuildCacheMode]string{
+		builder.BuildCacheInline:   "type=registry,ref=ghcr.io/org/gaia:v15.0.0-race",
+		builder.BuildCacheRegistry: "type=registry,ref=ghcr.io/org/gaia:buildcache-v15.0.0-race",
+	} {
+		h := builder.NewHeighlinerBuilder(builder.HeighlinerDockerBuildConfig{
+			ContainerRegistry: "ghcr.io/org",
+			BuildCache:        mode,
+			Backend:           &docker.FakeBuilder{Caps: docker.Capabilities{BuildKit: true, Cache: true}},
+		}, 1, true, true)
+		h.AddToQueue(context.Background(), builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{{
+			Build: builder.ChainNodeConfig{Name: "gaia", Dockerfile: builder.DockerfileTypeCosmos},
+			Ref:   "v15.0.0",
+		}}})
+
+		plans, err := h.Plan(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, plans[0].Err)
+		require.Equal(t, []string{"ghcr.io/org/gaia:v15.0.0-race"}, plans[0].Tags)
+		require.Equal(t, cacheFrom, plans[0].CacheFrom[0].String(), mode)
+	}
+}
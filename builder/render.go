@@ -0,0 +1,135 @@
+package builder
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/strangelove-ventures/heighliner/docker"
+)
+
+const (
+	RenderedDockerfile = "Dockerfile"
+	RenderedBuildArgs  = "build-args.env"
+	RenderedScript     = "build.sh"
+)
+
+// Render writes the plan into dir, so that the build can be reproduced without heighliner:
+// the Dockerfile, the build args as an env file and a script to build with docker.
+// The script is run from the build context, the current working directory for heighliner builds.
+// Secret build args, e.g. CLONE_KEY, are written as is, so the files are only readable by the owner.
+func (p BuildPlan) Render(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating render directory %s: %w", dir, err)
+	}
+
+	files := []struct {
+		name string
+		data []byte
+		perm os.FileMode
+	}{
+		{RenderedDockerfile, p.Dockerfile, 0644},
+		{RenderedBuildArgs, []byte(p.buildArgsEnv()), 0600},
+		{RenderedScript, []byte(p.BuildScript()), 0700},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, f.data, f.perm); err != nil {
+			return fmt.Errorf("error writing %s: %w", path, err)
+		}
+		// WriteFile does not change the permissions of existing files.
+		if err := os.Chmod(path, f.perm); err != nil {
+			return fmt.Errorf("error setting permissions of %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// buildArgsEnv returns the build args in env file format, which can also be sourced by a shell.
+func (p BuildPlan) buildArgsEnv() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# build args for %s %s, rendered by heighliner\n", p.Chain, p.Ref)
+	for _, key := range slices.Sorted(maps.Keys(p.BuildArgs)) {
+		fmt.Fprintf(&sb, "%s=%s\n", key, shellQuote(p.BuildArgs[key]))
+	}
+	return sb.String()
+}
+
+// BuildScript returns a shell script which builds the plan with docker, or podman for the podman
+// builder, using the build args from the env file next to it. The build context defaults to the current
+// working directory and can be passed as the first argument.
+func (p BuildPlan) BuildScript() string {
+	cli := "docker"
+	if p.Builder == docker.BuilderPodman {
+		cli = "podman"
+	}
+	var cmd []string
+	if p.UseBuildKit {
+		cmd = append(cmd, "docker buildx build", "--allow network.host", "--network host")
+		if len(p.Platforms) > 0 {
+			cmd = append(cmd, "--platform "+strings.Join(p.Platforms, ","))
+		}
+		switch {
+		case p.Push:
+			cmd = append(cmd, "--push")
+		case len(p.Platforms) <= 1:
+			cmd = append(cmd, "--load")
+		}
+		// secrets are not rendered, the clone key must be added to the local ssh-agent.
+		if p.Secrets.hasSSH() {
+			cmd = append(cmd, `--ssh default="${SSH_AUTH_SOCK}"`)
+		}
+		if p.Secrets.GitToken != "" {
+			cmd = append(cmd, `--secret id=`+netrcSecretID+`,src="${NETRC:-$HOME/.netrc}"`)
+		}
+		for _, cache := range p.CacheFrom {
+			cmd = append(cmd, "--cache-from "+shellQuote(cache.String()))
+		}
+		for _, cache := range p.CacheTo {
+			cmd = append(cmd, "--cache-to "+shellQuote(cache.String()))
+		}
+	} else {
+		cmd = append(cmd, cli+" build", "--network host")
+	}
+	cmd = append(cmd, `-f "$dir/`+RenderedDockerfile+`"`)
+	for _, key := range slices.Sorted(maps.Keys(p.BuildArgs)) {
+		cmd = append(cmd, "--build-arg "+key)
+	}
+	for _, tag := range p.Tags {
+		cmd = append(cmd, "-t "+shellQuote(tag))
+	}
+	cmd = append(cmd, `"${1:-.}"`)
+
+	var sb strings.Builder
+	sb.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&sb, "# Builds %s %s as heighliner would, rendered by heighliner.\n", p.Chain, p.Ref)
+	sb.WriteString("# Run from the build context, or pass it as the first argument.\n")
+	if p.UseBuildKit && p.Secrets.hasSSH() {
+		sb.WriteString("# Requires the clone key in the ssh-agent, e.g. ssh-add <key file>.\n")
+	}
+	if p.UseBuildKit && p.Secrets.GitToken != "" {
+		sb.WriteString("# Requires a netrc file with the git token at $NETRC or ~/.netrc.\n")
+	}
+	sb.WriteString("set -eu\n")
+	sb.WriteString(`dir=$(dirname "$0")` + "\n")
+	sb.WriteString("set -a\n")
+	sb.WriteString(`. "$dir/` + RenderedBuildArgs + `"` + "\n")
+	sb.WriteString("set +a\n\n")
+	sb.WriteString(strings.Join(cmd, " \\\n  "))
+	sb.WriteString("\n")
+	// native docker builds are pushed after building.
+	if p.Push && !p.UseBuildKit {
+		for _, tag := range p.Tags {
+			fmt.Fprintf(&sb, "%s push %s\n", cli, shellQuote(tag))
+		}
+	}
+	return sb.String()
+}
+
+// shellQuote quotes s for a POSIX shell, which is also understood in env files.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,63 @@
+package builder_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPlanRender(t *testing.T) {
+	plan := builder.BuildPlan{
+		Chain:       "gaia",
+		Ref:         "v15.0.0",
+		Dockerfile:  []byte("FROM alpine\n"),
+		UseBuildKit: true,
+		Platforms:   []string{"linux/amd64", "linux/arm64"},
+		Push:        true,
+		Tags:        []string{"ghcr.io/strangelove-ventures/heighliner/gaia:v15.0.0"},
+		BuildArgs: map[string]string{
+			"VERSION":   "v15.0.0",
+			"PRE_BUILD": "echo 'it''s'\nmake deps",
+			"BUILD_ENV": "LEDGER_ENABLED=false $HOME",
+		},
+	}
+
+	dir := filepath.Join(t.TempDir(), "gaia-v15.0.0")
+	require.NoError(t, plan.Render(dir))
+
+	df, err := os.ReadFile(filepath.Join(dir, builder.RenderedDockerfile))
+	require.NoError(t, err)
+	require.Equal(t, "FROM alpine\n", string(df))
+
+	fi, err := os.Stat(filepath.Join(dir, builder.RenderedBuildArgs))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	script, err := os.ReadFile(filepath.Join(dir, builder.RenderedScript))
+	require.NoError(t, err)
+	require.Contains(t, string(script), "docker buildx build")
+	require.Contains(t, string(script), "--platform linux/amd64,linux/arm64")
+	require.Contains(t, string(script), "--push")
+	require.Contains(t, string(script), "--build-arg PRE_BUILD")
+	require.Contains(t, string(script), "-t 'ghcr.io/strangelove-ventures/heighliner/gaia:v15.0.0'")
+
+	// the build args are sourced by the script, so must round trip through the shell.
+	out, err := exec.Command("sh", "-c", `set -a; . "$1"; printf '%s|%s' "$PRE_BUILD" "$BUILD_ENV"`, "sh",
+		filepath.Join(dir, builder.RenderedBuildArgs)).Output()
+	require.NoError(t, err)
+	require.Equal(t, plan.BuildArgs["PRE_BUILD"]+"|"+plan.BuildArgs["BUILD_ENV"], string(out))
+
+	require.NoError(t, exec.Command("sh", "-n", filepath.Join(dir, builder.RenderedScript)).Run())
+
+	// native docker builds are pushed after building.
+	plan.UseBuildKit = false
+	script = []byte(plan.BuildScript())
+	require.True(t, strings.HasPrefix(string(script), "#!/bin/sh\n"))
+	require.Contains(t, string(script), "docker build \\\n  --network host")
+	require.Contains(t, string(script), "docker push 'ghcr.io/strangelove-ventures/heighliner/gaia:v15.0.0'")
+}
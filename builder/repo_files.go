@@ -0,0 +1,489 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/strangelove-ventures/heighliner/github"
+)
+
+// commitSHA matches full commit hashes, which are used as refs without resolving them.
+var commitSHA = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// shortCommitSHA matches abbreviated commit hashes, which are resolved from the listed refs.
+var shortCommitSHA = regexp.MustCompile(`^[0-9a-f]{4,39}$`)
+
+// rawFileTimeout limits each raw file request.
+const rawFileTimeout = 30 * time.Second
+
+// fetchMu serializes fetches into the same cache directory, keyed by directory.
+var fetchMu sync.Map
+
+// ResolveRef returns the commit of ref in the repo at url, which is a tag, a branch, a commit hash or
+// HEAD for the default branch, and the name of the reference it resolved, listing the refs of the remote
+// without cloning it. The reference name is empty for commit hashes. Abbreviated commit hashes can only
+// be resolved if they uniquely match a commit that a ref points to, as the remote does not list other commits.
+func ResolveRef(ctx context.Context, url string, auth transport.AuthMethod, ref string) (string, plumbing.ReferenceName, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{url}})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth, PeelingOption: git.AppendPeeled})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to list refs of %s: %w", url, err)
+	}
+	hashes := make(map[plumbing.ReferenceName]plumbing.Hash, len(refs))
+	var head plumbing.ReferenceName
+	for _, r := range refs {
+		if r.Type() == plumbing.SymbolicReference {
+			if r.Name() == plumbing.HEAD {
+				head = r.Target()
+			}
+			continue
+		}
+		hashes[r.Name()] = r.Hash()
+	}
+	if ref == string(plumbing.HEAD) {
+		if hash, ok := hashes[head]; ok {
+			return hash.String(), head, nil
+		}
+		return "", "", fmt.Errorf("default branch of %s not found", url)
+	}
+	for _, name := range []plumbing.ReferenceName{
+		plumbing.NewTagReferenceName(ref),
+		plumbing.NewBranchReferenceName(ref),
+	} {
+		hash, ok := hashes[name]
+		if !ok {
+			continue
+		}
+		// annotated tags are peeled to the commit they point to.
+		if peeled, ok := hashes[name+"^{}"]; ok {
+			hash = peeled
+		}
+		return hash.String(), name, nil
+	}
+	// commits are not listed, they are used as is after checking that the repo is reachable.
+	if commitSHA.MatchString(ref) {
+		return ref, "", nil
+	}
+	if shortCommitSHA.MatchString(ref) {
+		return resolveShortCommit(url, hashes, ref)
+	}
+	return "", "", fmt.Errorf("ref %s not found in %s", ref, url)
+}
+
+// resolveShortCommit returns the commit of the refs which the abbreviated commit hash ref is a prefix of.
+func resolveShortCommit(url string, hashes map[plumbing.ReferenceName]plumbing.Hash, ref string) (string, plumbing.ReferenceName, error) {
+	var commit string
+	for name, hash := range hashes {
+		// annotated tags point to tag objects, the commits are listed as their peeled refs.
+		if _, ok := hashes[name+"^{}"]; ok {
+			continue
+		}
+		if !strings.HasPrefix(hash.String(), ref) || hash.String() == commit {
+			continue
+		}
+		if commit != "" {
+			return "", "", fmt.Errorf("abbreviated commit %s is ambiguous in %s, use the full commit hash", ref, url)
+		}
+		commit = hash.String()
+	}
+	if commit == "" {
+		return "", "", fmt.Errorf("abbreviated commit %s not found in the refs of %s, use the full commit hash", ref, url)
+	}
+	return commit, "", nil
+}
+
+// RepoFiles reads the files of a repo at a commit without cloning it. Files are fetched one at a time
+// from RawURL if set, falling back to a blobless fetch of the commit, and cached on disk.
+// Commits do not change, so cached files are used without revalidating them.
+type RepoFiles struct {
+	URL    string // git url of the repo
+	Auth   transport.AuthMethod
+	Commit string
+	// RawURL is the url of the files of the repo at Commit, e.g. https://raw.githubusercontent.com/org/repo/<commit>.
+	RawURL string
+	// CacheDir holds the files of all cached commits, and a git object store per repo. Repos which were not
+	// used for repoCacheMaxAge are removed. Files are not cached if empty.
+	CacheDir string
+	HTTP     *http.Client
+	Progress io.Writer // progress output, e.g. cache warnings, os.Stdout if nil
+
+	// GitHub fetches the files from RawURL instead of HTTP if set, for github.com repos.
+	GitHub *github.Client
+}
+
+// progress returns the writer of progress output.
+func (r *RepoFiles) progress() io.Writer {
+	if r.Progress == nil {
+		return os.Stdout
+	}
+	return r.Progress
+}
+
+// RepoFilesCacheDir returns the directory files of repos are cached in, or an empty string if there is no
+// user cache directory.
+func RepoFilesCacheDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "heighliner", "repos")
+}
+
+// FS returns the files as an fs.FS, fetching them with ctx.
+func (r *RepoFiles) FS(ctx context.Context) fs.FS {
+	return repoFilesFS{ctx: ctx, files: r}
+}
+
+type repoFilesFS struct {
+	ctx   context.Context
+	files *RepoFiles
+}
+
+func (f repoFilesFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	bz, err := f.files.ReadFile(f.ctx, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &repoFile{Reader: bytes.NewReader(bz), name: path.Base(name)}, nil
+}
+
+// repoFile is a file of RepoFiles, read into memory.
+type repoFile struct {
+	*bytes.Reader
+	name string
+}
+
+func (f *repoFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *repoFile) Close() error               { return nil }
+func (f *repoFile) Name() string               { return f.name }
+func (f *repoFile) Mode() fs.FileMode          { return 0o444 }
+func (f *repoFile) ModTime() time.Time         { return time.Time{} }
+func (f *repoFile) IsDir() bool                { return false }
+func (f *repoFile) Sys() any                   { return nil }
+
+// ReadFile returns the content of the file at name, a slash separated path relative to the root of the repo.
+// Returns an error wrapping fs.ErrNotExist if the file does not exist at Commit.
+func (r *RepoFiles) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	dir := r.commitCacheDir()
+	if dir != "" {
+		r.markUsed()
+		if bz, err := os.ReadFile(filepath.Join(dir, "files", filepath.FromSlash(name))); err == nil {
+			return bz, nil
+		}
+		if _, err := os.Stat(filepath.Join(dir, "missing", filepath.FromSlash(name))); err == nil {
+			return nil, fs.ErrNotExist
+		}
+	}
+
+	bz, err := r.fetchFile(ctx, name)
+	if dir != "" {
+		switch {
+		case err == nil:
+			r.cache(filepath.Join(dir, "files", filepath.FromSlash(name)), bz)
+		case errors.Is(err, errRawFileNotFound):
+			// raw file hosts also respond with not found for private repos, so only misses in the git tree are cached.
+		case errors.Is(err, fs.ErrNotExist):
+			r.cache(filepath.Join(dir, "missing", filepath.FromSlash(name)), nil)
+		}
+	}
+	return bz, err
+}
+
+// errRawFileNotFound is returned for files not found on the raw file host.
+var errRawFileNotFound = fmt.Errorf("raw file %w", fs.ErrNotExist)
+
+func (r *RepoFiles) fetchFile(ctx context.Context, name string) ([]byte, error) {
+	if r.RawURL != "" {
+		bz, err := r.fetchRawFile(ctx, name)
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			return bz, err
+		}
+		fmt.Fprintf(r.progress(), "Failed to fetch %s of %s, falling back to git: %v\n", name, r.URL, err)
+	}
+	return r.fetchGitFile(ctx, name)
+}
+
+func (r *RepoFiles) fetchRawFile(ctx context.Context, name string) ([]byte, error) {
+	if r.GitHub != nil {
+		bz, err := r.GitHub.Get(ctx, r.RawURL+"/"+name)
+		if errors.Is(err, github.ErrNotFound) {
+			return nil, errRawFileNotFound
+		}
+		return bz, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, rawFileTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.RawURL+"/"+name, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	client := r.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(res.Body)
+	case http.StatusNotFound:
+		return nil, errRawFileNotFound
+	default:
+		return nil, fmt.Errorf("GET %s: %s", req.URL, res.Status)
+	}
+}
+
+// fetchGitFile reads name from the tree of the commit. The commit is fetched with depth 1 and without blobs,
+// and the blob of name is fetched on its own, if they are not in the git object store of the repo yet.
+func (r *RepoFiles) fetchGitFile(ctx context.Context, name string) ([]byte, error) {
+	dir := r.repoCacheDir()
+	mu, _ := fetchMu.LoadOrStore(dir, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	var storer storage.Storer = memory.NewStorage()
+	if dir != "" {
+		storer = filesystem.NewStorage(osfs.New(filepath.Join(dir, "git")), cache.NewObjectLRUDefault())
+	}
+
+	hash := plumbing.NewHash(r.Commit)
+	commit, err := object.GetCommit(storer, hash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		if err := r.fetchObject(ctx, storer, hash, true); err != nil {
+			return nil, fmt.Errorf("failed to fetch %s at %s: %w", r.URL, r.Commit, err)
+		}
+		commit, err = object.GetCommit(storer, hash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", r.Commit, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree of commit %s: %w", r.Commit, err)
+	}
+	entry, err := tree.FindEntry(path.Clean(name))
+	if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+		return nil, fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !entry.Mode.IsFile() {
+		return nil, fs.ErrNotExist
+	}
+
+	blob, err := object.GetBlob(storer, entry.Hash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		if err := r.fetchObject(ctx, storer, entry.Hash, false); err != nil {
+			return nil, fmt.Errorf("failed to fetch %s of %s at %s: %w", name, r.URL, r.Commit, err)
+		}
+		blob, err = object.GetBlob(storer, entry.Hash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s at %s: %w", name, r.Commit, err)
+	}
+	rd, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return io.ReadAll(rd)
+}
+
+// fetchObject fetches the object want into storer. Commits are fetched with depth 1 and without any blobs,
+// which requires servers to support filters, as github, gitlab and gitea do.
+func (r *RepoFiles) fetchObject(ctx context.Context, storer storage.Storer, want plumbing.Hash, isCommit bool) error {
+	ep, err := transport.NewEndpoint(r.URL)
+	if err != nil {
+		return err
+	}
+	c, err := client.NewClient(ep)
+	if err != nil {
+		return err
+	}
+	session, err := c.NewUploadPackSession(ep, r.Auth)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	adv, err := session.AdvertisedReferencesContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !adv.Capabilities.Supports(capability.Filter) {
+		return fmt.Errorf("git server does not support blobless fetches (no %s capability)", capability.Filter)
+	}
+
+	req := packp.NewUploadPackRequestFromCapabilities(adv.Capabilities)
+	req.Wants = []plumbing.Hash{want}
+	if isCommit {
+		req.Depth = packp.DepthCommits(1)
+		req.Filter = packp.FilterBlobNone()
+		if err := req.Capabilities.Set(capability.Shallow); err != nil {
+			return err
+		}
+		if err := req.Capabilities.Set(capability.Filter); err != nil {
+			return err
+		}
+	}
+	if adv.Capabilities.Supports(capability.NoProgress) {
+		if err := req.Capabilities.Set(capability.NoProgress); err != nil {
+			return err
+		}
+	}
+
+	res, err := session.UploadPack(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	if len(res.Shallows) > 0 {
+		shallows, err := storer.Shallow()
+		if err != nil {
+			return err
+		}
+		for _, s := range res.Shallows {
+			if !slices.Contains(shallows, s) {
+				shallows = append(shallows, s)
+			}
+		}
+		if err := storer.SetShallow(shallows); err != nil {
+			return err
+		}
+	}
+
+	var pack io.Reader = res
+	switch {
+	case req.Capabilities.Supports(capability.Sideband64k):
+		pack = sideband.NewDemuxer(sideband.Sideband64k, res)
+	case req.Capabilities.Supports(capability.Sideband):
+		pack = sideband.NewDemuxer(sideband.Sideband, res)
+	}
+	return packfile.UpdateObjectStorage(storer, pack)
+}
+
+// repoCacheMaxAge is how long the cache of a repo is kept after it was last used.
+const repoCacheMaxAge = 30 * 24 * time.Hour
+
+// repoCacheUsedFile is touched in the cache directory of a repo when it is first used by a process.
+const repoCacheUsedFile = ".last-used"
+
+// usedRepoCaches are the repo cache directories marked as used by this process, and prunedCacheDirs the
+// cache directories pruned by it.
+var usedRepoCaches, prunedCacheDirs sync.Map
+
+// markUsed marks the cache of the repo as used, and removes the caches of repos which were not used for
+// repoCacheMaxAge, once per process.
+func (r *RepoFiles) markUsed() {
+	if _, loaded := usedRepoCaches.LoadOrStore(r.repoCacheDir(), true); !loaded {
+		r.cache(filepath.Join(r.repoCacheDir(), repoCacheUsedFile), nil)
+	}
+	if _, loaded := prunedCacheDirs.LoadOrStore(r.CacheDir, true); !loaded {
+		pruneRepoCaches(r.progress(), r.CacheDir, time.Now().Add(-repoCacheMaxAge))
+	}
+}
+
+// pruneRepoCaches removes the repo caches in cacheDir which were last used before cutoff, warning on out
+// about caches which can not be removed.
+func pruneRepoCaches(out io.Writer, cacheDir string, cutoff time.Time) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		dir := filepath.Join(cacheDir, e.Name())
+		if _, used := usedRepoCaches.Load(dir); used || !e.IsDir() {
+			continue
+		}
+		// caches without a last used file were last used when they were created.
+		fi, err := os.Stat(filepath.Join(dir, repoCacheUsedFile))
+		if err != nil {
+			fi, err = e.Info()
+		}
+		if err == nil && fi.ModTime().Before(cutoff) {
+			if err := os.RemoveAll(dir); err != nil {
+				fmt.Fprintf(out, "Warning: failed to remove unused repo cache %s: %v\n", dir, err)
+			}
+		}
+	}
+}
+
+// repoCacheDir returns the cache directory of the repo, unique to the repo url.
+func (r *RepoFiles) repoCacheDir() string {
+	if r.CacheDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(r.URL))
+	return filepath.Join(r.CacheDir, hex.EncodeToString(sum[:8]))
+}
+
+// commitCacheDir returns the cache directory of the files of the commit.
+func (r *RepoFiles) commitCacheDir() string {
+	if r.CacheDir == "" {
+		return ""
+	}
+	return filepath.Join(r.repoCacheDir(), r.Commit)
+}
+
+// cache writes bz to path atomically, so that parallel builds never read partially written files.
+func (r *RepoFiles) cache(path string, bz []byte) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		var f *os.File
+		f, err = os.CreateTemp(filepath.Dir(path), ".tmp-*")
+		if err == nil {
+			_, err = f.Write(bz)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err == nil {
+				err = os.Rename(f.Name(), path)
+			}
+			if err != nil {
+				_ = os.Remove(f.Name())
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(r.progress(), "Warning: failed to cache %s: %v\n", path, err)
+	}
+}
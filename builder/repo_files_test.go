@@ -0,0 +1,157 @@
+package builder_test
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/strangelove-ventures/heighliner/github"
+	"github.com/stretchr/testify/require"
+)
+
+// initRepo creates a git repo with a go.mod, an annotated tag v1.0.0 and a branch, returning its url and commit.
+// If allowFilter is set, the repo can be fetched without blobs.
+func initRepo(t *testing.T, allowFilter bool) (string, string) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	if allowFilter {
+		cfg, err := repo.Config()
+		require.NoError(t, err)
+		cfg.Raw.Section("uploadpack").SetOption("allowFilter", "true")
+		cfg.Raw.Section("uploadpack").SetOption("allowAnySHA1InWant", "true")
+		require.NoError(t, repo.SetConfig(cfg))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/chain\n\ngo 1.22\n"), 0o644))
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = wt.Add("go.mod")
+	require.NoError(t, err)
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	hash, err := wt.Commit("initial", &git.CommitOptions{Author: sig})
+	require.NoError(t, err)
+	_, err = repo.CreateTag("v1.0.0", hash, &git.CreateTagOptions{Tagger: sig, Message: "v1.0.0"})
+	require.NoError(t, err)
+	require.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: "refs/heads/release", Create: true}))
+	return "file://" + dir, hash.String()
+}
+
+func TestResolveRef(t *testing.T) {
+	url, commit := initRepo(t, false)
+	ctx := context.Background()
+
+	for ref, wantName := range map[string]plumbing.ReferenceName{
+		"v1.0.0":   "refs/tags/v1.0.0",
+		"release":  "refs/heads/release",
+		"HEAD":     "refs/heads/release",
+		commit:     "",
+		commit[:7]: "",
+	} {
+		resolved, name, err := builder.ResolveRef(ctx, url, nil, ref)
+		require.NoError(t, err, ref)
+		require.Equal(t, commit, resolved, ref)
+		require.Equal(t, wantName, name, ref)
+	}
+
+	_, _, err := builder.ResolveRef(ctx, url, nil, "v2.0.0")
+	require.ErrorContains(t, err, "ref v2.0.0 not found")
+
+	_, _, err = builder.ResolveRef(ctx, url, nil, "0000000")
+	require.ErrorContains(t, err, "abbreviated commit 0000000 not found")
+}
+
+func TestRepoFilesGit(t *testing.T) {
+	url, commit := initRepo(t, true)
+	ctx := context.Background()
+	files := &builder.RepoFiles{URL: url, Commit: commit, CacheDir: t.TempDir()}
+
+	goMod, err := fs.ReadFile(files.FS(ctx), "go.mod")
+	require.NoError(t, err)
+	require.Contains(t, string(goMod), "go 1.22")
+
+	_, err = fs.ReadFile(files.FS(ctx), "go.work")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// cached files are read without fetching, including missing files.
+	require.NoError(t, os.RemoveAll(url[len("file://"):]))
+	goMod, err = files.ReadFile(ctx, "go.mod")
+	require.NoError(t, err)
+	require.Contains(t, string(goMod), "go 1.22")
+	_, err = files.ReadFile(ctx, "go.work")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestRepoFilesGitWithoutFilter(t *testing.T) {
+	url, commit := initRepo(t, false)
+	files := &builder.RepoFiles{URL: url, Commit: commit, CacheDir: t.TempDir()}
+	_, err := files.ReadFile(context.Background(), "go.mod")
+	require.ErrorContains(t, err, "does not support blobless fetches")
+}
+
+func TestRepoFilesRaw(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/org/repo/abc/node/go.mod" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("module example.com/node\n"))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	files := &builder.RepoFiles{
+		URL:      "https://example.invalid/org/repo",
+		Commit:   "abc",
+		RawURL:   srv.URL + "/org/repo/abc",
+		CacheDir: t.TempDir(),
+	}
+	for i := 0; i < 2; i++ {
+		goMod, err := files.ReadFile(ctx, "node/go.mod")
+		require.NoError(t, err)
+		require.Equal(t, "module example.com/node\n", string(goMod))
+
+		_, err = files.ReadFile(ctx, "go.work")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	}
+	// raw file hosts also do not find files of private repos, so misses are not cached.
+	require.Equal(t, 3, requests)
+}
+
+func TestRepoFilesRawGitHub(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		if r.URL.Path != "/org/repo/abc/go.mod" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("module example.com/chain\n"))
+	}))
+	defer srv.Close()
+
+	client := github.NewClient(srv.URL)
+	client.RawURL, client.Token, client.CacheDir = srv.URL, "token", t.TempDir()
+	files := &builder.RepoFiles{
+		URL:      "https://example.invalid/org/repo",
+		Commit:   "abc",
+		RawURL:   client.RawFileURL("org", "repo", "abc"),
+		CacheDir: t.TempDir(),
+		GitHub:   client,
+	}
+	goMod, err := files.ReadFile(context.Background(), "go.mod")
+	require.NoError(t, err)
+	require.Equal(t, "module example.com/chain\n", string(goMod))
+
+	_, err = files.ReadFile(context.Background(), "go.work")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
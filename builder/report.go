@@ -0,0 +1,248 @@
+package builder
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	BuildStatusSuccess = "success"
+	BuildStatusFailed  = "failed"
+	BuildStatusSkipped = "skipped"
+)
+
+// Status returns the status of the build for reports: success, failed or skipped.
+func (r BuildResult) Status() string {
+	switch {
+	case r.Err != nil:
+		return BuildStatusFailed
+	case r.Skipped:
+		return BuildStatusSkipped
+	default:
+		return BuildStatusSuccess
+	}
+}
+
+// BuildReport is the machine readable report of all builds of a heighliner run.
+type BuildReport struct {
+	Total     int                `json:"total"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+	Skipped   int                `json:"skipped"`
+	Duration  float64            `json:"durationSeconds"`
+	Builds    []BuildReportEntry `json:"builds"`
+}
+
+// BuildReportEntry is the report of a single chain/ref build.
+type BuildReportEntry struct {
+	Chain         string   `json:"chain"`
+	Ref           string   `json:"ref"`
+	Commit        string   `json:"commit,omitempty"`
+	Status        string   `json:"status"`
+	Error         string   `json:"error,omitempty"`
+	GoVersion     string   `json:"goVersion,omitempty"`
+	WasmvmVersion string   `json:"wasmvmVersion,omitempty"`
+	Tags          []string `json:"tags"`
+	Pushed        bool     `json:"pushed"`
+	Digest        string   `json:"digest,omitempty"`
+	Platforms     []string `json:"platforms"`
+	Duration      float64  `json:"durationSeconds"`
+
+	// PlatformDigests are the manifest digests by platform, Digest is that of the manifest list for multi-platform images.
+	PlatformDigests map[string]string `json:"platformDigests,omitempty"`
+}
+
+// NewBuildReport returns the report for the build results, with duration being the wall time of the run.
+func NewBuildReport(results BuildResults, duration time.Duration) BuildReport {
+	report := BuildReport{
+		Total:    len(results),
+		Duration: duration.Seconds(),
+		Builds:   make([]BuildReportEntry, len(results)),
+	}
+	for i, r := range results {
+		entry := BuildReportEntry{
+			Chain:         r.Chain,
+			Ref:           r.Ref,
+			Commit:        r.Commit,
+			Status:        r.Status(),
+			GoVersion:     r.GoVersion,
+			WasmvmVersion: r.WasmvmVersion,
+			Tags:          r.Tags,
+			Pushed:        r.Pushed,
+			Digest:        r.Digest,
+			Platforms:     r.Platforms,
+			Duration:      r.Duration.Seconds(),
+
+			PlatformDigests: r.PlatformDigests,
+		}
+		if entry.Tags == nil {
+			entry.Tags = []string{}
+		}
+		if entry.Platforms == nil {
+			entry.Platforms = []string{}
+		}
+		switch entry.Status {
+		case BuildStatusFailed:
+			entry.Error = r.Err.Error()
+			report.Failed++
+		case BuildStatusSkipped:
+			report.Skipped++
+		default:
+			report.Succeeded++
+		}
+		report.Builds[i] = entry
+	}
+	return report
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r BuildReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func junitTime(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+// WriteJUnit writes the report as JUnit XML, with a test suite per chain and a test case per ref.
+func (r BuildReport) WriteJUnit(w io.Writer) error {
+	suites := junitTestSuites{
+		Tests:    r.Total,
+		Failures: r.Failed,
+		Skipped:  r.Skipped,
+		Time:     junitTime(r.Duration),
+	}
+	suiteIndex := make(map[string]int)
+	suiteTime := make(map[string]float64)
+	for _, b := range r.Builds {
+		i, ok := suiteIndex[b.Chain]
+		if !ok {
+			i = len(suites.Suites)
+			suiteIndex[b.Chain] = i
+			suites.Suites = append(suites.Suites, junitTestSuite{Name: b.Chain})
+		}
+		suite := &suites.Suites[i]
+		suite.Tests++
+		suiteTime[b.Chain] += b.Duration
+		suite.Time = junitTime(suiteTime[b.Chain])
+
+		tc := junitTestCase{
+			ClassName: b.Chain,
+			Name:      b.Ref,
+			Time:      junitTime(b.Duration),
+			SystemOut: b.summary(),
+		}
+		switch b.Status {
+		case BuildStatusFailed:
+			suite.Failures++
+			tc.Failure = &junitMessage{Message: "build failed", Text: b.Error}
+		case BuildStatusSkipped:
+			suite.Skipped++
+			tc.Skipped = &junitMessage{Message: "image already exists"}
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// summary returns the resolved details of the build for the JUnit test case output.
+func (b BuildReportEntry) summary() string {
+	var s string
+	for _, f := range [][2]string{
+		{"commit", b.Commit},
+		{"go version", b.GoVersion},
+		{"wasmvm version", b.WasmvmVersion},
+		{"digest", b.Digest},
+	} {
+		if f[1] != "" {
+			s += fmt.Sprintf("%s: %s\n", f[0], f[1])
+		}
+	}
+	for _, tag := range b.Tags {
+		s += fmt.Sprintf("tag: %s\n", tag)
+	}
+	for _, platform := range b.Platforms {
+		s += fmt.Sprintf("platform: %s\n", platform)
+	}
+	for _, platform := range slices.Sorted(maps.Keys(b.PlatformDigests)) {
+		s += fmt.Sprintf("digest %s: %s\n", platform, b.PlatformDigests[platform])
+	}
+	return s
+}
+
+// WriteFiles writes the report as JSON to jsonPath and as JUnit XML to junitPath, skipping empty paths.
+func (r BuildReport) WriteFiles(jsonPath string, junitPath string) error {
+	for _, out := range []struct {
+		path  string
+		write func(io.Writer) error
+	}{
+		{jsonPath, r.WriteJSON},
+		{junitPath, r.WriteJUnit},
+	} {
+		if out.path == "" {
+			continue
+		}
+		f, err := os.Create(out.path)
+		if err != nil {
+			return fmt.Errorf("error creating build report %s: %w", out.path, err)
+		}
+		if err := out.write(f); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("error writing build report %s: %w", out.path, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("error writing build report %s: %w", out.path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package builder_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/stretchr/testify/require"
+)
+
+var reportResults = builder.BuildResults{
+	{
+		Chain:     "gaia",
+		Ref:       "v15.0.0",
+		Commit:    "1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b",
+		Tags:      []string{"ghcr.io/strangelove-ventures/heighliner/gaia:v15.0.0"},
+		Platforms: []string{"linux/amd64", "linux/arm64"},
+		Pushed:    true,
+		Digest:    "sha256:abc",
+		PlatformDigests: map[string]string{
+			"linux/amd64": "sha256:def",
+			"linux/arm64": "sha256:123",
+		},
+		Duration:      90 * time.Second,
+		GoVersion:     "1.21.9",
+		WasmvmVersion: "v1.5.2",
+	},
+	{
+		Chain:    "gaia",
+		Ref:      "v14.0.0",
+		Duration: 2 * time.Second,
+		Err:      errors.New("failed to clone"),
+	},
+	{
+		Chain:   "osmosis",
+		Ref:     "v23.0.0",
+		Tags:    []string{"ghcr.io/strangelove-ventures/heighliner/osmosis:v23.0.0"},
+		Skipped: true,
+	},
+}
+
+func TestBuildReportJSON(t *testing.T) {
+	report := builder.NewBuildReport(reportResults, 100*time.Second)
+	require.Equal(t, 3, report.Total)
+	require.Equal(t, 1, report.Succeeded)
+	require.Equal(t, 1, report.Failed)
+	require.Equal(t, 1, report.Skipped)
+
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteJSON(&buf))
+
+	var decoded builder.BuildReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, report, decoded)
+	require.Equal(t, builder.BuildStatusSuccess, decoded.Builds[0].Status)
+	require.Equal(t, "1.21.9", decoded.Builds[0].GoVersion)
+	require.Equal(t, "1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b", decoded.Builds[0].Commit)
+	require.Equal(t, 90.0, decoded.Builds[0].Duration)
+	require.Equal(t, "sha256:def", decoded.Builds[0].PlatformDigests["linux/amd64"])
+	require.Equal(t, builder.BuildStatusFailed, decoded.Builds[1].Status)
+	require.Equal(t, "failed to clone", decoded.Builds[1].Error)
+	require.Equal(t, builder.BuildStatusSkipped, decoded.Builds[2].Status)
+}
+
+func TestBuildReportJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, builder.NewBuildReport(reportResults, 100*time.Second).WriteJUnit(&buf))
+
+	out := buf.String()
+	require.Contains(t, out, `<testsuites tests="3" failures="1" skipped="1" time="100.000">`)
+	require.Contains(t, out, `<testsuite name="gaia" tests="2" failures="1" skipped="0" time="92.000">`)
+	require.Contains(t, out, `<testcase classname="gaia" name="v14.0.0" time="2.000">`)
+	require.Contains(t, out, `<failure message="build failed">failed to clone</failure>`)
+	require.Contains(t, out, `<skipped message="image already exists"></skipped>`)
+	require.Contains(t, out, "wasmvm version: v1.5.2")
+	require.Contains(t, out, "commit: 1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b")
+	require.Contains(t, out, "digest: sha256:abc")
+	require.Contains(t, out, "digest linux/arm64: sha256:123")
+}
@@ -0,0 +1,89 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultRustImageVersion is the version of the rust image for toolchains which are not a stable release,
+// e.g. nightly, which are installed with rustup.
+const DefaultRustImageVersion = "1"
+
+// rustToolchainFiles are the toolchain files read by rustup, in order of precedence.
+var rustToolchainFiles = []string{"rust-toolchain", "rust-toolchain.toml"}
+
+// rustStableVersion matches stable rust releases, e.g. 1.75 or 1.75.0, which have rust images.
+var rustStableVersion = regexp.MustCompile(`^1\.\d+(\.\d+)?$`)
+
+// tomlChannel matches the channel key of a rust-toolchain.toml.
+var tomlChannel = regexp.MustCompile(`^channel\s*=\s*["']([^"']+)["']`)
+
+// FindRustToolchain returns the toolchain channel of the rust toolchain file which rustup would use when
+// building in buildDir, searching from buildDir up to the root of the repo, along with the path of the file.
+// Returns empty strings if the repo does not have a toolchain file.
+func FindRustToolchain(repoFS fs.FS, buildDir string) (channel string, source string, err error) {
+	dir := path.Clean(buildDir)
+	for {
+		for _, name := range rustToolchainFiles {
+			filePath := path.Join(dir, name)
+			bz, err := fs.ReadFile(repoFS, filePath)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return "", "", fmt.Errorf("failed to read %s: %w", filePath, err)
+			}
+			channel, err := parseRustToolchain(bz)
+			if err != nil {
+				return "", "", fmt.Errorf("failed to parse %s: %w", filePath, err)
+			}
+			return channel, filePath, nil
+		}
+		if dir == "." || dir == "/" {
+			return "", "", nil
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// parseRustToolchain returns the channel of a rust toolchain file, which is either a toml file with a
+// [toolchain] table or, in the legacy format, only the channel.
+func parseRustToolchain(bz []byte) (string, error) {
+	content := strings.TrimSpace(string(bz))
+	if !strings.Contains(content, "[toolchain]") {
+		if content == "" || strings.ContainsAny(content, " =\n") {
+			return "", errors.New("expected a [toolchain] table or a toolchain name")
+		}
+		return content, nil
+	}
+
+	inToolchain := false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inToolchain = line == "[toolchain]"
+			continue
+		}
+		if !inToolchain {
+			continue
+		}
+		if m := tomlChannel.FindStringSubmatch(line); m != nil {
+			return m[1], nil
+		}
+	}
+	// a toolchain table without channel only configures components or targets.
+	return "", nil
+}
+
+// RustImageVersion returns the version of the rust image to build toolchain with: the toolchain itself for
+// stable releases, otherwise DefaultRustImageVersion, with the toolchain installed by rustup.
+func RustImageVersion(toolchain string) string {
+	if rustStableVersion.MatchString(toolchain) {
+		return toolchain
+	}
+	return DefaultRustImageVersion
+}
@@ -0,0 +1,99 @@
+package builder_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindRustToolchain(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		files         map[string]string
+		buildDir      string
+		wantChannel   string
+		wantSource    string
+		wantErr       bool
+		wantRustImage string
+	}{
+		{
+			name:          "no toolchain file",
+			files:         map[string]string{"Cargo.toml": "[package]"},
+			wantRustImage: "1",
+		},
+		{
+			name: "toml",
+			files: map[string]string{"rust-toolchain.toml": `# pinned
+[toolchain]
+channel = "1.75.0"
+components = ["rustfmt", "clippy"]
+`},
+			wantChannel:   "1.75.0",
+			wantSource:    "rust-toolchain.toml",
+			wantRustImage: "1.75.0",
+		},
+		{
+			name:          "legacy",
+			files:         map[string]string{"rust-toolchain": "nightly-2024-01-01\n"},
+			wantChannel:   "nightly-2024-01-01",
+			wantSource:    "rust-toolchain",
+			wantRustImage: "1",
+		},
+		{
+			name: "legacy takes precedence",
+			files: map[string]string{
+				"rust-toolchain":      "1.70",
+				"rust-toolchain.toml": "[toolchain]\nchannel = \"1.75.0\"",
+			},
+			wantChannel:   "1.70",
+			wantSource:    "rust-toolchain",
+			wantRustImage: "1.70",
+		},
+		{
+			name: "build dir before parent",
+			files: map[string]string{
+				"rust-toolchain.toml":           "[toolchain]\nchannel = \"1.75.0\"",
+				"node/rust-toolchain.toml":      "[toolchain]\nchannel = 'stable'",
+				"other/rust-toolchain.toml":     "[toolchain]\nchannel = \"1.60.0\"",
+				"node/cli/Cargo.toml":           "[package]",
+				"node/cli/src/rust-toolchain":   "1.50",
+				"node/unrelated/rust-toolchain": "1.40",
+			},
+			buildDir:      "node/cli",
+			wantChannel:   "stable",
+			wantSource:    "node/rust-toolchain.toml",
+			wantRustImage: "1",
+		},
+		{
+			name:          "toml without channel",
+			files:         map[string]string{"rust-toolchain.toml": "[toolchain]\ntargets = [\"wasm32-unknown-unknown\"]"},
+			wantSource:    "rust-toolchain.toml",
+			wantRustImage: "1",
+		},
+		{
+			name:     "invalid",
+			files:    map[string]string{"rust-toolchain": "channel = 1.75"},
+			buildDir: ".",
+			wantErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repoFS := fstest.MapFS{}
+			for path, content := range tc.files {
+				repoFS[path] = &fstest.MapFile{Data: []byte(content)}
+			}
+
+			channel, source, err := builder.FindRustToolchain(repoFS, tc.buildDir)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.wantChannel, channel)
+			require.Equal(t, tc.wantSource, source)
+			require.Equal(t, tc.wantRustImage, builder.RustImageVersion(channel))
+		})
+	}
+}
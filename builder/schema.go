@@ -0,0 +1,154 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/strangelove-ventures/heighliner/releases"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// fieldDescriptions are shown by editors for each chain config key.
+var fieldDescriptions = map[string]string{
+	"name":                   "Name of the chain, used as the docker image name",
+	"repo-host":              "Git repository host, defaults to github.com",
+	"repo-host-fingerprints": "Pinned SHA256 fingerprints of the ssh host keys of the repo host, verified instead of known_hosts when cloning with ssh",
+	"repo-type":              "API used to find releases of the repository, defaults to github for github.com, gitlab for gitlab.com and git tags otherwise",
+	"prereleases":            "Also build prereleases, e.g. release candidates, when building the most recent releases",
+	"release-constraint":     "Only build the most recent releases matching a semver constraint, e.g. \">= v15.0.0\"",
+	"release-tag-pattern":    "Only build the most recent releases with tags matching a regular expression, e.g. \"^v\"",
+	"github-organization":    "Organization of the chain repository",
+	"github-repo":            "Name of the chain repository",
+	"clone-key":              "Base64 encoded ssh key used to clone private repositories",
+	"language":               "DEPRECATED, use dockerfile instead",
+	"dockerfile":             "Dockerfile used to build the image",
+	"build-target":           "Command(s) to build the chain binaries",
+	"final-image":            "Base image for the final image (imported dockerfile only)",
+	"build-dir":              "Repo relative directory to run build-target in",
+	"binaries":               "Binaries to package into the final image, in the form src[:dest]",
+	"libraries":              "Libraries to package into the final image",
+	"target-libraries":       "Libraries for the target architecture to package into the final image",
+	"directories":            "Directories to package into the final image",
+	"pre-build":              "Command(s) to run prior to build-target",
+	"platforms":              "Platforms supported by the chain, in the form os/arch[/variant]",
+	"build-env":              "Build environment variables, in the form KEY=VALUE",
+	"base-image":             "Base image for the build (imported dockerfile only)",
+	"go-version":             "Go version to build with instead of the version from go.mod, e.g. \"1.21\" or \"1.21.13\"",
+	"alpine-version":         "Alpine version of the golang build image, e.g. \"3.20\"",
+	"rust-toolchain":         "Rust toolchain to build with (cargo dockerfile only), e.g. \"1.75.0\" or \"nightly-2024-01-01\"",
+	"native-deps":            "Native dependencies detected from go.mod and downloaded for the build, defaults to [wasmvm], \"none\" disables detection",
+	"native-deps-unverified": "Download native dependencies whose release publishes no checksums unverified, instead of failing the build",
+	"build-cache":            "Where buildkit builds import and export their build cache: registry for <image>:buildcache-<tag>, inline in the pushed image, local for a directory per chain and tag, or none",
+	"build-cache-ref":        "Image ref of the registry build cache, or of the image to import an inline build cache from, instead of the one derived from the image tag. Shared by all builds of the chain",
+	"extends":                "Name of a chain config or template to inherit values from",
+	"template":               "Only use this config to be extended, do not build it",
+	"versions":               "Overrides of this config for the refs matching a semver constraint",
+	"constraint":             "Semver constraint of the refs to apply the overrides to, e.g. \"< v15.0.0\"",
+}
+
+// fieldItemPatterns restricts the format of the items of list fields.
+var fieldItemPatterns = map[string]string{
+	"platforms": fmt.Sprintf("^(%s)/(%s)(/[^/]+)?$", strings.Join(validOS, "|"), strings.Join(validArch, "|")),
+	"binaries":  "^[^:]+(:[^:]+)?$",
+
+	"repo-host-fingerprints": hostKeyFingerprint.String(),
+}
+
+// ChainsJSONSchema returns a JSON Schema for chains yaml files, generated from ChainNodeConfig.
+func ChainsJSONSchema() ([]byte, error) {
+	schema := map[string]any{
+		"$schema":     jsonSchemaDraft,
+		"title":       "heighliner chains",
+		"description": "List of chain configs to build docker images for",
+		"type":        "array",
+		"items":       structSchema(reflect.TypeOf(ChainNodeConfig{}), []string{"name"}, nil),
+	}
+	bz, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling json schema: %w", err)
+	}
+	return append(bz, '\n'), nil
+}
+
+// structSchema returns the JSON Schema object for the yaml fields of struct type t,
+// excluding the fields in exclude.
+func structSchema(t reflect.Type, required []string, exclude []string) map[string]any {
+	properties := make(map[string]any)
+	addStructProperties(properties, t, exclude)
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addStructProperties adds the schema of the yaml fields of struct type t, including those of inlined structs, to properties.
+func addStructProperties(properties map[string]any, t reflect.Type, exclude []string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if opts == "inline" {
+			addStructProperties(properties, f.Type, exclude)
+			continue
+		}
+		if name == "" || name == "-" || slices.Contains(exclude, name) {
+			continue
+		}
+		prop := typeSchema(f.Type)
+		if desc, ok := fieldDescriptions[name]; ok {
+			prop["description"] = desc
+		}
+		if pattern, ok := fieldItemPatterns[name]; ok {
+			prop["items"].(map[string]any)["pattern"] = pattern
+		}
+		properties[name] = prop
+	}
+}
+
+// typeSchema returns the JSON Schema for a config field of type t.
+func typeSchema(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(DockerfileType("")) {
+		enum := make([]string, len(knownDockerfileTypes))
+		for i, d := range knownDockerfileTypes {
+			enum[i] = string(d)
+		}
+		return map[string]any{"type": "string", "enum": enum}
+	}
+	if t == reflect.TypeOf(BuildCacheMode("")) {
+		enum := make([]string, len(knownBuildCacheModes))
+		for i, m := range knownBuildCacheModes {
+			enum[i] = string(m)
+		}
+		return map[string]any{"type": "string", "enum": enum}
+	}
+	if t == reflect.TypeOf(releases.HostType("")) {
+		enum := make([]string, len(releases.HostTypes))
+		for i, h := range releases.HostTypes {
+			enum[i] = string(h)
+		}
+		return map[string]any{"type": "string", "enum": enum}
+	}
+	if t == reflect.TypeOf(ChainNodeVersionConfig{}) {
+		return structSchema(t, []string{"constraint"}, notVersioned)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t, nil, nil)
+	default:
+		return map[string]any{"type": "string"}
+	}
+}
@@ -0,0 +1,261 @@
+package builder
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	internalssh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// netrcSecretID is the id of the buildkit secret with the git token, mounted as ~/.netrc.
+const netrcSecretID = "netrc"
+
+// BuildSecrets are the credentials used to clone private repositories and to fetch private
+// go modules. Buildkit builds receive them as ssh and secret mounts, so they are not stored
+// in the image history or build cache. Native docker builds only support a clone key,
+// which is passed as the CLONE_KEY build arg.
+type BuildSecrets struct {
+	CloneKey     string // base64 encoded ssh private key
+	CloneKeyFile string // path to an ssh private key
+	SSHAgent     bool   // forward the local ssh-agent at SSH_AUTH_SOCK
+	GitToken     string // token for https git, e.g. private go modules, mounted as ~/.netrc
+
+	// KnownHostsFile verifies ssh host keys, instead of SSH_KNOWN_HOSTS or the default known_hosts files.
+	KnownHostsFile string
+	// HostKeyFingerprints are the pinned SHA256 fingerprints of the repo host keys, which take
+	// precedence over known_hosts.
+	HostKeyFingerprints []string
+}
+
+// withChainConfig returns the secrets with the clone key of a chain config, which takes precedence
+// over the clone key and ssh-agent for all builds, and its pinned host key fingerprints.
+func (s BuildSecrets) withChainConfig(build ChainNodeConfig) BuildSecrets {
+	if build.CloneKey != "" {
+		s.CloneKey = build.CloneKey
+		s.CloneKeyFile = ""
+		s.SSHAgent = false
+	}
+	s.HostKeyFingerprints = build.RepoHostFingerprints
+	return s
+}
+
+// hasSSH returns whether the secrets authenticate git over ssh.
+func (s BuildSecrets) hasSSH() bool {
+	return s.CloneKey != "" || s.CloneKeyFile != "" || s.SSHAgent
+}
+
+// Describe returns which secrets are used, without their values.
+func (s BuildSecrets) Describe() []string {
+	var d []string
+	switch {
+	case s.SSHAgent:
+		d = append(d, "ssh: ssh-agent")
+	case s.CloneKeyFile != "":
+		d = append(d, "ssh: "+s.CloneKeyFile)
+	case s.CloneKey != "":
+		d = append(d, "ssh: clone-key")
+	}
+	if s.GitToken != "" {
+		d = append(d, "netrc: git token")
+	}
+	return d
+}
+
+// cloneKeyPEM returns the ssh private key to clone with, or nil if there is none.
+func (s BuildSecrets) cloneKeyPEM() ([]byte, error) {
+	switch {
+	case s.CloneKey != "":
+		key, err := base64.StdEncoding.DecodeString(s.CloneKey)
+		if err != nil {
+			return nil, errors.New("failed to decode clone key")
+		}
+		return key, nil
+	case s.CloneKeyFile != "":
+		key, err := os.ReadFile(s.CloneKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read clone key file: %w", err)
+		}
+		return key, nil
+	default:
+		return nil, nil
+	}
+}
+
+// buildArgCloneKey returns the base64 encoded clone key for the CLONE_KEY build arg of native docker builds.
+// If warn is set, secrets which are ignored or stored in the image are warned about on it.
+func (s BuildSecrets) buildArgCloneKey(warn io.Writer) (string, error) {
+	if warn == nil {
+		warn = io.Discard
+	}
+	if s.SSHAgent || s.GitToken != "" {
+		fmt.Fprintln(warn, "Warning: ssh-agent forwarding and git tokens are only supported for buildkit builds (-b), ignoring them")
+	}
+	key, err := s.cloneKeyPEM()
+	if err != nil || key == nil {
+		return "", err
+	}
+	fmt.Fprintln(warn, "Warning: the clone key is passed as a build arg for native docker builds, so is stored in the image history. Use buildkit (-b) to pass it as a secret")
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// buildKit returns the ssh paths and secrets for the buildkit session. The clone key is
+// written to a temporary file for the ssh-agent of the session, which is removed by cleanup.
+func (s BuildSecrets) buildKit(repoHost string) (sshPaths []string, secrets map[string][]byte, cleanup func(), err error) {
+	cleanup = func() {}
+
+	if s.GitToken != "" {
+		secrets = map[string][]byte{netrcSecretID: netrc(s.GitToken, repoHost, "github.com")}
+	}
+
+	switch {
+	case s.SSHAgent:
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, nil, cleanup, errors.New("ssh-agent forwarding requested, but SSH_AUTH_SOCK is not set")
+		}
+		sshPaths = []string{sock}
+	case s.CloneKeyFile != "":
+		sshPaths = []string{s.CloneKeyFile}
+	case s.CloneKey != "":
+		key, err := s.cloneKeyPEM()
+		if err != nil {
+			return nil, nil, cleanup, err
+		}
+		// outside of the build context, so that it can never be copied into the image.
+		f, err := os.CreateTemp("", "heighliner-clone-key")
+		if err != nil {
+			return nil, nil, cleanup, fmt.Errorf("error creating temporary clone key file: %w", err)
+		}
+		cleanup = func() { _ = os.Remove(f.Name()) }
+		_, err = f.Write(key)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			cleanup()
+			return nil, nil, func() {}, fmt.Errorf("error writing temporary clone key file: %w", err)
+		}
+		sshPaths = []string{f.Name()}
+	}
+
+	return sshPaths, secrets, cleanup, nil
+}
+
+// netrc returns a netrc file authenticating to hosts with token.
+func netrc(token string, hosts ...string) []byte {
+	var sb strings.Builder
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		fmt.Fprintf(&sb, "machine %s\nlogin x-access-token\npassword %s\n", host, token)
+	}
+	return []byte(sb.String())
+}
+
+// knownHosts records the ssh host keys verified when fetching from the repo host, as known_hosts lines.
+// They are passed to the build, so that the build does not trust the host key on first use.
+type knownHosts struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (k *knownHosts) add(line string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !slices.Contains(k.lines, line) {
+		k.lines = append(k.lines, line)
+	}
+}
+
+// String returns the verified keys as a known_hosts file.
+func (k *knownHosts) String() string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return strings.Join(k.lines, "\n")
+}
+
+// hostKeyCallback returns the ssh host key verification for repoHost, against the pinned fingerprints if any,
+// otherwise against known_hosts. Verified keys are recorded in verified.
+func (s BuildSecrets) hostKeyCallback(repoHost string, verified *knownHosts) (ssh.HostKeyCallbackHelper, error) {
+	var helper ssh.HostKeyCallbackHelper
+	var verify internalssh.HostKeyCallback
+	if len(s.HostKeyFingerprints) > 0 {
+		verify = func(hostname string, _ net.Addr, key internalssh.PublicKey) error {
+			fingerprint := internalssh.FingerprintSHA256(key)
+			if !slices.Contains(s.HostKeyFingerprints, fingerprint) {
+				return fmt.Errorf("host key %s of %s does not match the pinned repo-host-fingerprints", fingerprint, hostname)
+			}
+			return nil
+		}
+	} else {
+		var files []string
+		if s.KnownHostsFile != "" {
+			files = append(files, s.KnownHostsFile)
+		}
+		db, err := ssh.NewKnownHostsDb(files...)
+		if err != nil {
+			return helper, fmt.Errorf("failed to load known_hosts to verify the host key of %s, pin it with repo-host-fingerprints instead: %w", repoHost, err)
+		}
+		verify = db.HostKeyCallback()
+		// only negotiate the key types in known_hosts, so that the host does not present a key which is not known.
+		helper.HostKeyAlgorithms = db.HostKeyAlgorithms(repoHost + ":22")
+	}
+
+	helper.HostKeyCallback = func(hostname string, remote net.Addr, key internalssh.PublicKey) error {
+		if err := verify(hostname, remote, key); err != nil {
+			return err
+		}
+		verified.add(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+		return nil
+	}
+	return helper, nil
+}
+
+// gitAuth returns the auth to fetch go.mod files from repoHost with, or nil for public repositories.
+// The host keys of ssh auth are verified, and recorded in verified.
+func (s BuildSecrets) gitAuth(repoHost string, verified *knownHosts) (transport.AuthMethod, error) {
+	if s.hasSSH() {
+		hostKeys, err := s.hostKeyCallback(repoHost, verified)
+		if err != nil {
+			return nil, err
+		}
+		if s.SSHAgent {
+			auth, err := ssh.NewSSHAgentAuth("git")
+			if err != nil {
+				return nil, fmt.Errorf("failed to use ssh-agent: %w", err)
+			}
+			auth.HostKeyCallbackHelper = hostKeys
+			return auth, nil
+		}
+
+		key, err := s.cloneKeyPEM()
+		if err != nil {
+			return nil, err
+		}
+		auth, err := ssh.NewPublicKeys("git", key, "")
+		if err != nil {
+			return nil, errors.New("failed to generate public key")
+		}
+		auth.HostKeyCallbackHelper = hostKeys
+		return auth, nil
+	}
+
+	if s.GitToken != "" {
+		return &http.BasicAuth{Username: "x-access-token", Password: s.GitToken}, nil
+	}
+	return nil, nil
+}
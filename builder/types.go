@@ -1,5 +1,17 @@
 package builder
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/strangelove-ventures/heighliner/docker"
+	"github.com/strangelove-ventures/heighliner/releases"
+)
+
 type DockerfileType string
 
 const (
@@ -7,6 +19,7 @@ const (
 	DockerfileTypeAvalanche DockerfileType = "avalanche"
 	DockerfileTypeCargo     DockerfileType = "cargo"
 	DockerfileTypeImported  DockerfileType = "imported"
+	DockerfileTypeNone      DockerfileType = "none"
 
 	DockerfileTypeGo   DockerfileType = "go"   // DEPRECATED, use "cosmos" instead
 	DockerfileTypeRust DockerfileType = "rust" // DEPRECATED, use "cargo" instead
@@ -20,24 +33,51 @@ var deprecationReplacements = [][2]DockerfileType{
 }
 
 type ChainNodeConfig struct {
-	Name               string         `yaml:"name"`
-	RepoHost           string         `yaml:"repo-host"`
-	GithubOrganization string         `yaml:"github-organization"`
-	GithubRepo         string         `yaml:"github-repo"`
-	CloneKey           string         `yaml:"clone-key"`
-	Language           DockerfileType `yaml:"language"` // DEPRECATED, use "dockerfile" instead
-	Dockerfile         DockerfileType `yaml:"dockerfile"`
-	BuildTarget        string         `yaml:"build-target"`
-	FinalImage         string         `yaml:"final-image"`
-	BuildDir           string         `yaml:"build-dir"`
-	Binaries           []string       `yaml:"binaries"`
-	Libraries          []string       `yaml:"libraries"`
-	TargetLibraries    []string       `yaml:"target-libraries"`
-	Directories        []string       `yaml:"directories"`
-	PreBuild           string         `yaml:"pre-build"`
-	Platforms          []string       `yaml:"platforms"`
-	BuildEnv           []string       `yaml:"build-env"`
-	BaseImage          string         `yaml:"base-image"`
+	Name     string            `yaml:"name"`
+	RepoHost string            `yaml:"repo-host"`
+	RepoType releases.HostType `yaml:"repo-type"`
+	// RepoHostFingerprints are the pinned SHA256 fingerprints of the ssh host keys of the repo host.
+	RepoHostFingerprints []string       `yaml:"repo-host-fingerprints"`
+	Prereleases          bool           `yaml:"prereleases"`
+	ReleaseConstraint    string         `yaml:"release-constraint"`
+	ReleaseTagPattern    string         `yaml:"release-tag-pattern"`
+	GithubOrganization   string         `yaml:"github-organization"`
+	GithubRepo           string         `yaml:"github-repo"`
+	CloneKey             string         `yaml:"clone-key"`
+	Language             DockerfileType `yaml:"language"` // DEPRECATED, use "dockerfile" instead
+	Dockerfile           DockerfileType `yaml:"dockerfile"`
+	BuildTarget          string         `yaml:"build-target"`
+	FinalImage           string         `yaml:"final-image"`
+	BuildDir             string         `yaml:"build-dir"`
+	Binaries             []string       `yaml:"binaries"`
+	Libraries            []string       `yaml:"libraries"`
+	TargetLibraries      []string       `yaml:"target-libraries"`
+	Directories          []string       `yaml:"directories"`
+	PreBuild             string         `yaml:"pre-build"`
+	Platforms            []string       `yaml:"platforms"`
+	BuildEnv             []string       `yaml:"build-env"`
+	BaseImage            string         `yaml:"base-image"`
+	GoVersion            string         `yaml:"go-version"`
+	AlpineVersion        string         `yaml:"alpine-version"`
+	RustToolchain        string         `yaml:"rust-toolchain"`
+	NativeDeps           []string       `yaml:"native-deps"`
+	NativeDepsUnverified bool           `yaml:"native-deps-unverified"`
+	BuildCache           BuildCacheMode `yaml:"build-cache"`
+	BuildCacheRef        string         `yaml:"build-cache-ref"`
+	Extends              string         `yaml:"extends"`
+	Template             bool           `yaml:"template"`
+
+	Versions []ChainNodeVersionConfig `yaml:"versions"`
+}
+
+// ChainNodeVersionConfig overrides fields of a ChainNodeConfig when building refs
+// which match the semver Constraint, e.g. "< v15.0.0".
+type ChainNodeVersionConfig struct {
+	Constraint      string `yaml:"constraint"`
+	ChainNodeConfig `yaml:",inline"`
+
+	// keys are the yaml keys of the entry, whose fields are overridden even if empty.
+	keys []string
 }
 
 type ChainNodeDockerBuildConfig struct {
@@ -45,6 +85,9 @@ type ChainNodeDockerBuildConfig struct {
 	Ref    string
 	Tag    string
 	Latest bool
+
+	// VersionConstraints are the constraints of the `versions` entries applied to Build.
+	VersionConstraints []string
 }
 
 type HeighlinerDockerBuildConfig struct {
@@ -53,13 +96,103 @@ type HeighlinerDockerBuildConfig struct {
 	TarExportPath     string
 	UseBuildKit       bool
 	BuildKitAddr      string
+	Backend           docker.Builder        // builds the images, docker or buildkit according to UseBuildKit if nil
+	BuildCache        BuildCacheMode        // overrides the build cache of all chains
+	BuildCacheDir     string                // directory of local build caches, DefaultBuildCacheDir if empty
+	CacheFrom         []docker.CacheOptions // additional caches to import for all builds
+	CacheTo           []docker.CacheOptions // additional caches to export for all builds
 	Platform          string
 	NoCache           bool
 	NoBuildCache      bool
-	GoVersion         string
-	AlpineVersion     string
+	GoVersion         string // overrides the go version of all chains
+	AlpineVersion     string // overrides the alpine version of all chains
+	RustToolchain     string // overrides the rust toolchain of all chains
+	SkipExisting      bool
+	BuildTimeout      time.Duration // per build, no timeout if zero
+	Secrets           BuildSecrets
+	VulnDB            *VulnDB  // audits the go.mod of builds if set
+	FailOnSeverity    Severity // fails builds with vulnerabilities of at least this severity
+
+	// Progress receives the progress output of queueing and building, os.Stdout if nil.
+	Progress io.Writer
 }
 
 type HeighlinerQueuedChainBuilds struct {
 	ChainConfigs []ChainNodeDockerBuildConfig
 }
+
+// BuildResult is the outcome of a single chain node docker image build.
+type BuildResult struct {
+	Chain     string
+	Ref       string
+	Commit    string // commit the ref resolved to, if resolved
+	Tags      []string
+	Platforms []string
+	Pushed    bool
+	Digest    string // digest of the built image, that of the manifest list for multi-platform images, if available
+	Duration  time.Duration
+	Err       error
+
+	// PlatformDigests are the manifest digests of the built image by platform, if available.
+	PlatformDigests map[string]string
+
+	GoVersion     string
+	WasmvmVersion string
+
+	// Skipped is set if the build was skipped because its image already exists in the container registry.
+	Skipped bool
+}
+
+// BuildResults are the outcomes of all builds of a HeighlinerBuilder.
+type BuildResults []BuildResult
+
+// Failed returns the results of the builds which failed.
+func (r BuildResults) Failed() BuildResults {
+	var failed BuildResults
+	for _, result := range r {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Skipped returns the results of the builds which were skipped.
+func (r BuildResults) Skipped() BuildResults {
+	var skipped BuildResults
+	for _, result := range r {
+		if result.Skipped {
+			skipped = append(skipped, result)
+		}
+	}
+	return skipped
+}
+
+// Err returns the errors of all failed builds joined, or nil if all builds succeeded.
+func (r BuildResults) Err() error {
+	var errs []error
+	for _, result := range r.Failed() {
+		errs = append(errs, result.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// ReleaseFilter returns the filter selecting which of the most recent releases of the chain are built.
+func (c ChainNodeConfig) ReleaseFilter() (releases.Filter, error) {
+	filter := releases.Filter{Prereleases: c.Prereleases}
+	if c.ReleaseConstraint != "" {
+		constraint, err := version.NewConstraint(c.ReleaseConstraint)
+		if err != nil {
+			return filter, fmt.Errorf("invalid release-constraint %q: %w", c.ReleaseConstraint, err)
+		}
+		filter.Constraint = constraint
+	}
+	if c.ReleaseTagPattern != "" {
+		pattern, err := regexp.Compile(c.ReleaseTagPattern)
+		if err != nil {
+			return filter, fmt.Errorf("invalid release-tag-pattern %q: %w", c.ReleaseTagPattern, err)
+		}
+		filter.TagPattern = pattern
+	}
+	return filter, nil
+}
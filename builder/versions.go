@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"gopkg.in/yaml.v3"
+)
+
+// ForRef returns the chain config to build ref with. The overrides of every `versions`
+// entry with a constraint matching ref are applied in order, so later entries take precedence.
+// Refs which are not semver versions, e.g. branches, use the chain config as is.
+func (c ChainNodeConfig) ForRef(ref string) ChainNodeConfig {
+	cfg := c
+	cfg.Versions = nil
+	for _, vc := range c.versionsForRef(ref) {
+		cfg = overrideChainNodeConfig(cfg, vc)
+	}
+	return cfg
+}
+
+// versionsForRef returns the `versions` entries with a constraint matching ref, in order.
+func (c ChainNodeConfig) versionsForRef(ref string) []ChainNodeVersionConfig {
+	v, err := version.NewVersion(ref)
+	if err != nil {
+		return nil
+	}
+
+	var matching []ChainNodeVersionConfig
+	for _, vc := range c.Versions {
+		constraints, err := version.NewConstraint(vc.Constraint)
+		if err != nil {
+			// constraints are validated when loading chain configs.
+			continue
+		}
+		// prereleases match the constraints of their release, e.g. v15.0.0-rc1 matches ">= v15.0.0".
+		if !constraints.Check(v) && !(v.Prerelease() != "" && constraints.Check(v.Core())) {
+			continue
+		}
+		matching = append(matching, vc)
+	}
+	return matching
+}
+
+// UnmarshalYAML decodes a `versions` entry, recording its keys so that empty values,
+// e.g. `pre-build: ""` or `skip-push: false`, override the chain config too.
+func (vc *ChainNodeVersionConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain ChainNodeVersionConfig
+	if err := node.Decode((*plain)(vc)); err != nil {
+		return err
+	}
+	vc.keys = []string{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		vc.keys = append(vc.keys, node.Content[i].Value)
+	}
+	return nil
+}
+
+// overrideChainNodeConfig returns base with the fields of the keys of override set, or all its non-zero
+// fields if it was not decoded from yaml, except for those which are not allowed in `versions`.
+func overrideChainNodeConfig(base ChainNodeConfig, override ChainNodeVersionConfig) ChainNodeConfig {
+	b := reflect.ValueOf(&base).Elem()
+	o := reflect.ValueOf(override.ChainNodeConfig)
+	for i := 0; i < b.NumField(); i++ {
+		key, _, _ := strings.Cut(b.Type().Field(i).Tag.Get("yaml"), ",")
+		if slices.Contains(notVersioned, key) {
+			continue
+		}
+		set := !o.Field(i).IsZero()
+		if override.keys != nil {
+			set = slices.Contains(override.keys, key)
+		}
+		if set {
+			b.Field(i).Set(o.Field(i))
+		}
+	}
+	return base
+}
+
+// NewChainNodeDockerBuildConfig returns the config to build ref of a chain, with the
+// chain config for ref resolved from its `versions`.
+func NewChainNodeDockerBuildConfig(build ChainNodeConfig, ref string, tag string, latest bool) ChainNodeDockerBuildConfig {
+	var constraints []string
+	for _, vc := range build.versionsForRef(ref) {
+		constraints = append(constraints, vc.Constraint)
+	}
+	return ChainNodeDockerBuildConfig{
+		Build:              build.ForRef(ref),
+		Ref:                ref,
+		Tag:                tag,
+		Latest:             latest,
+		VersionConstraints: constraints,
+	}
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/strangelove-ventures/heighliner/builder"
+)
+
+const (
+	flagVulnDB = "vuln-db"
+	flagFailOn = "fail-on"
+)
+
+func AuditCmd() *cobra.Command {
+	var secrets builder.BuildSecrets
+
+	var auditCmd = &cobra.Command{
+		Use:   "audit",
+		Short: "Check the go modules of a chain for known vulnerabilities",
+		Long: `Check every module required by the go.mod of a chain at a ref and by the members of its go.work, at the
+version of its replacement if replaced, and the standard library and toolchain of the go version the chain is
+built with, against a local vulnerability database of OSV entries, e.g. an extracted snapshot of
+https://vuln.go.dev or the osv.dev Go ecosystem export. The database is never fetched, so audits of
+--local chains work offline. Modules replaced with local directories have no version and are reported as
+not audited.
+
+With --fail-on, exits with an error if a vulnerability of at least that severity is found.
+Builds can be gated the same way with build --vuln-db and --fail-on.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdFlags := cmd.Flags()
+
+			configFile, _ := cmdFlags.GetString(flagFile)
+			if err := loadChains(configFile); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if err := loadSecrets(cmd, &secrets); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			var buildConfig builder.HeighlinerDockerBuildConfig
+			if err := loadVulnDB(cmd, &buildConfig); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if buildConfig.VulnDB == nil {
+				fmt.Printf("--%s is required\n", flagVulnDB)
+				os.Exit(1)
+			}
+
+			chainName, _ := cmdFlags.GetString(flagChain)
+			chain, err := findChain(chainName)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			ref, _ := cmdFlags.GetString(flagGitRef)
+			local, _ := cmdFlags.GetBool(flagLocal)
+
+			var files builder.ChainModFiles
+			if local {
+				ref = "local directory"
+				files, err = builder.LocalChainModFiles(chain)
+			} else {
+				if ref == "" {
+					ref = "HEAD"
+				}
+				files, err = builder.FetchChainModFiles(context.Background(), os.Stderr, chain, ref, secrets)
+			}
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			// the standard library is audited at the go version that the chain would be built with.
+			goVersion := chain.GoVersion
+			if goVersion == "" {
+				goVersion = files.GoVersion()
+			}
+			if goVersion != "" {
+				goVersion = builder.GetImageAndVersionForGoVersion(goVersion, chain.AlpineVersion).Version
+			}
+
+			report := buildConfig.VulnDB.Audit(files, goVersion)
+			vulns := report.Vulnerabilities
+			format, _ := cmdFlags.GetString(flagFormat)
+			switch format {
+			case listFormatJSON:
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				err = enc.Encode(report)
+			case listFormatTable:
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "SEVERITY\tID\tMODULE\tVERSION\tFIXED\tSUMMARY")
+				for _, v := range vulns {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Severity, v.ID, v.Module, v.Version, valueOrDash(v.Fixed), v.Summary)
+				}
+				err = tw.Flush()
+				fmt.Printf("\n%d known vulnerabilities in %s %s\n", len(vulns), chain.Name, ref)
+				for _, m := range report.Unaudited {
+					fmt.Printf("Not audited: %s is replaced with the local directory %s\n", m.Path, m.ReplacePath)
+				}
+			default:
+				err = fmt.Errorf("unknown format %q, must be %s or %s", format, listFormatTable, listFormatJSON)
+			}
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if failing := builder.VulnerabilitiesAtLeast(vulns, buildConfig.FailOnSeverity); len(failing) > 0 {
+				fmt.Fprintf(os.Stderr, "%d vulnerabilities of severity %s or higher\n", len(failing), buildConfig.FailOnSeverity)
+				os.Exit(1)
+			}
+		},
+	}
+
+	auditCmd.PersistentFlags().StringP(flagFile, "f", "", "chains.yaml config file path (searches for chains.yaml in current directory by default)")
+	auditCmd.PersistentFlags().StringP(flagChain, "c", "", "Chain to audit from chains.yaml")
+	auditCmd.PersistentFlags().StringP(flagGitRef, "g", "", "Git ref to audit (branch, tag or full commit SHA), defaults to the default branch")
+	auditCmd.PersistentFlags().Bool(flagLocal, false, "Audit the go.mod of the local directory (not git repository)")
+	auditCmd.PersistentFlags().String(flagFormat, listFormatTable, "Output format: table or json")
+	addVulnDBFlags(auditCmd)
+	addSecretFlags(auditCmd, &secrets)
+
+	return auditCmd
+}
+
+// addVulnDBFlags adds the flags to audit go modules with a vulnerability database to cmd.
+func addVulnDBFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String(flagVulnDB, "", "Directory of OSV vulnerability entries to audit the go modules of chains with")
+	cmd.PersistentFlags().String(flagFailOn, "", "Fail if the go modules have a vulnerability of at least this severity: any, low, medium, high or critical")
+}
+
+// loadVulnDB loads the vulnerability database and severity threshold of the flags into buildConfig.
+func loadVulnDB(cmd *cobra.Command, buildConfig *builder.HeighlinerDockerBuildConfig) error {
+	cmdFlags := cmd.Flags()
+	failOn, _ := cmdFlags.GetString(flagFailOn)
+	severity, err := builder.ParseSeverity(failOn)
+	if err != nil {
+		return fmt.Errorf("invalid --%s: %w", flagFailOn, err)
+	}
+	buildConfig.FailOnSeverity = severity
+
+	dir, _ := cmdFlags.GetString(flagVulnDB)
+	if dir == "" {
+		if severity != builder.SeverityNone {
+			return fmt.Errorf("--%s requires --%s", flagFailOn, flagVulnDB)
+		}
+		return nil
+	}
+	buildConfig.VulnDB, err = builder.LoadVulnDB(dir)
+	return err
+}
@@ -1,16 +1,19 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"os"
+	"os/signal"
 	"path"
-	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/strangelove-ventures/heighliner/builder"
 	"github.com/strangelove-ventures/heighliner/docker"
-	"gopkg.in/yaml.v2"
 )
 
 type chainConfigFlags struct {
@@ -27,6 +30,10 @@ type chainConfigFlags struct {
 	orgOverride         string
 	repoOverride        string
 	repoHostOverride    string
+	repoTypeOverride    string
+	prereleases         bool
+	releaseConstraint   string
+	releaseTagPattern   string
 	cloneKeyOverride    string
 	dockerfileOverride  string
 	buildDirOverride    string
@@ -38,85 +45,122 @@ type chainConfigFlags struct {
 }
 
 const (
-	flagFile          = "file"
-	flagRegistry      = "registry"
-	flagChain         = "chain"
-	flagOrg           = "org"
-	flagRepo          = "repo"
-	flagRepoHost      = "repo-host"
-	flagCloneKey      = "clone-key"
-	flagGitRef        = "git-ref"
-	flagDockerfile    = "dockerfile"
-	flagBuildDir      = "build-dir"
-	flagPreBuild      = "pre-build"
-	flagBuildTarget   = "build-target"
-	flagBuildEnv      = "build-env"
-	flagBinaries      = "binaries"
-	flagLibraries     = "libraries"
-	flagTag           = "tag"
-	flagVersion       = "version" // DEPRECATED
-	flagNumber        = "number"
-	flagParallel      = "parallel"
-	flagSkip          = "skip"
-	flagTarExport     = "tar-export-path"
-	flagLatest        = "latest"
-	flagLocal         = "local"
-	flagUseBuildkit   = "use-buildkit"
-	flagBuildkitAddr  = "buildkit-addr"
-	flagPlatform      = "platform"
-	flagNoCache       = "no-cache"
-	flagNoBuildCache  = "no-build-cache"
-	flagRace          = "race"
-	flagGoVersion     = "go-version"
-	flagAlpineVersion = "alpine-version"
+	flagFile              = "file"
+	flagRegistry          = "registry"
+	flagChain             = "chain"
+	flagOrg               = "org"
+	flagRepo              = "repo"
+	flagRepoHost          = "repo-host"
+	flagRepoType          = "repo-type"
+	flagPrereleases       = "prereleases"
+	flagReleaseConstraint = "release-constraint"
+	flagReleaseTagPattern = "release-tag-pattern"
+	flagCloneKey          = "clone-key"
+	flagGitRef            = "git-ref"
+	flagDockerfile        = "dockerfile"
+	flagBuildDir          = "build-dir"
+	flagPreBuild          = "pre-build"
+	flagBuildTarget       = "build-target"
+	flagBuildEnv          = "build-env"
+	flagBinaries          = "binaries"
+	flagLibraries         = "libraries"
+	flagTag               = "tag"
+	flagVersion           = "version" // DEPRECATED
+	flagNumber            = "number"
+	flagParallel          = "parallel"
+	flagSkip              = "skip"
+	flagTarExport         = "tar-export-path"
+	flagLatest            = "latest"
+	flagLocal             = "local"
+	flagUseBuildkit       = "use-buildkit"
+	flagBuilder           = "builder"
+	flagBuildCache        = "build-cache"
+	flagBuildCacheDir     = "build-cache-dir"
+	flagCacheFrom         = "cache-from"
+	flagCacheTo           = "cache-to"
+	flagBuildkitAddr      = "buildkit-addr"
+	flagPlatform          = "platform"
+	flagNoCache           = "no-cache"
+	flagNoBuildCache      = "no-build-cache"
+	flagRace              = "race"
+	flagGoVersion         = "go-version"
+	flagAlpineVersion     = "alpine-version"
+	flagRustToolchain     = "rust-toolchain"
+	flagSkipExisting      = "skip-existing"
+	flagForce             = "force"
+	flagTimeout           = "timeout"
+	flagReportJSON        = "report-json"
+	flagReportJUnit       = "report-junit"
+	flagDryRun            = "dry-run"
+	flagDryRunFormat      = "dry-run-format"
+
+	flagCloneKeyFile = "clone-key-file"
+	flagSSHAgent     = "ssh-agent"
+	flagGitTokenFile = "git-token-file"
+	flagKnownHosts   = "known-hosts"
+
+	envCloneKey = "HEIGHLINER_CLONE_KEY"
+	envGitToken = "HEIGHLINER_GIT_TOKEN"
+
+	dryRunFormatTable = "table"
+	dryRunFormatJSON  = "json"
 )
 
-func loadChainsYaml(configFile string) error {
+// readChainsFiles reads the chains yaml file at configFile, or all yaml files within it if it is a directory.
+func readChainsFiles(configFile string) ([]builder.ChainsFile, error) {
 	fi, err := os.Stat(configFile)
 	if err != nil {
-		return fmt.Errorf("error checking for file: %s: %w", configFile, err)
+		return nil, fmt.Errorf("error checking for file: %s: %w", configFile, err)
 	}
+	var paths []string
 	switch mode := fi.Mode(); {
 	case mode.IsDir():
-		{
-			dir := os.DirFS(configFile)
-			configFiles, err := fs.Glob(dir, "*.yaml")
-			if err != nil {
-				return fmt.Errorf("error checking for yaml files in : %s: %w", configFile, err)
-			}
-			var combinedChains []builder.ChainNodeConfig
-			for _, v := range configFiles {
-				bz, err := os.ReadFile(path.Join(configFile, v))
-				if err != nil {
-					return fmt.Errorf("error reading file: %s - %s: %w", configFile, v, err)
-				}
-				var newChains []builder.ChainNodeConfig
-				err = yaml.Unmarshal(bz, &newChains)
-				if err != nil {
-					return fmt.Errorf("error unmarshalling yaml from file: %s- %s: %w", configFile, v, err)
-				}
-				combinedChains = append(combinedChains, newChains...)
-			}
-			chains = combinedChains
+		dir := os.DirFS(configFile)
+		configFiles, err := fs.Glob(dir, "*.yaml")
+		if err != nil {
+			return nil, fmt.Errorf("error checking for yaml files in : %s: %w", configFile, err)
+		}
+		for _, v := range configFiles {
+			paths = append(paths, path.Join(configFile, v))
 		}
 	case mode.IsRegular():
-		{
-			bz, err := os.ReadFile(configFile)
-			if err != nil {
-				return fmt.Errorf("error reading file: %s: %w", configFile, err)
-			}
-			var newChains []builder.ChainNodeConfig
-			err = yaml.Unmarshal(bz, &newChains)
-			if err != nil {
-				return fmt.Errorf("error unmarshalling yaml from file: %s: %w", configFile, err)
-			}
-			chains = newChains
+		paths = append(paths, configFile)
+	}
+
+	files := make([]builder.ChainsFile, len(paths))
+	for i, p := range paths {
+		bz, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %s: %w", p, err)
 		}
+		files[i] = builder.ChainsFile{Path: p, Content: bz}
 	}
+	return files, nil
+}
 
+func loadChainsYaml(configFile string) error {
+	files, err := readChainsFiles(configFile)
+	if err != nil {
+		return err
+	}
+	newChains, err := builder.LoadChainNodeConfigs(files...)
+	if err != nil {
+		return fmt.Errorf("invalid chain configs in %s:\n%w", configFile, err)
+	}
+	chains = newChains
 	return nil
 }
 
+// loadChains loads chains from configFile if provided, otherwise from a chains/ directory
+// in the current working directory if it exists, falling back to the embedded chains.
+// Chain configs that are found are validated, so any error should abort the command.
+func loadChains(configFile string) error {
+	if configFile != "" {
+		return loadChainsYaml(configFile)
+	}
+	return loadLocalChainsYaml()
+}
+
 func BuildCmd() *cobra.Command {
 	var chainConfig chainConfigFlags
 	var buildConfig builder.HeighlinerDockerBuildConfig
@@ -126,27 +170,16 @@ func BuildCmd() *cobra.Command {
 		Short: "Build the docker images",
 		Long: `By default, fetch the last 5 releases in the repositories specified in chains.yaml.
 For each tag that doesn't exist in the specified container repository,
-it will be built and pushed`,
+it will be built and pushed. Tags which exist but are missing any of the
+platforms to build are rebuilt. Use --force to rebuild existing tags.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			cmdFlags := cmd.Flags()
 
+			// pre-flight validation of chain configs, fail before any build is started.
 			configFile, _ := cmdFlags.GetString(flagFile)
-			if configFile == "" {
-				// try to load a local chains.yaml, but do not panic for any error, will fall back to embedded chains.
-				cwd, err := os.Getwd()
-				if err == nil {
-					chainsYamlSearchPath := filepath.Join(cwd, "chains/")
-					if err := loadChainsYaml(chainsYamlSearchPath); err != nil {
-						fmt.Printf("No config found at %s, using embedded chains. pass -f to configure chains.yaml path.\n", chainsYamlSearchPath)
-					} else {
-						fmt.Printf("Loaded chains from %s\n", chainsYamlSearchPath)
-					}
-				}
-			} else {
-				// if flag is explicitly provided, panic on error since intent was to override embedded chains.
-				if err := loadChainsYaml(configFile); err != nil {
-					panic(err)
-				}
+			if err := loadChains(configFile); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
 			}
 
 			version, _ := cmdFlags.GetString(flagVersion)
@@ -163,7 +196,64 @@ An optional flag --tag/-t is now available to override the resulting docker imag
 			}
 			// END DEPRECATION HANDLING
 
-			queueAndBuild(buildConfig, chainConfig)
+			if err := loadSecrets(cmd, &buildConfig.Secrets); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if err := loadGoCatalog(cmd); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if err := loadVulnDB(cmd, &buildConfig); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if err := loadBuilder(cmd, &buildConfig); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if err := loadBuildCache(cmd, &buildConfig); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if force, _ := cmdFlags.GetBool(flagForce); force {
+				buildConfig.SkipExisting = false
+			}
+
+			// cancel running builds on ctrl+c, temporary build directories are cleaned up before exiting.
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			if dryRun, _ := cmdFlags.GetBool(flagDryRun); dryRun {
+				format, _ := cmdFlags.GetString(flagDryRunFormat)
+				if err := queueAndPlan(ctx, buildConfig, chainConfig, format); err != nil {
+					fmt.Fprintf(os.Stderr, "Some builds can not be resolved:\n%v\n", err)
+					stop()
+					os.Exit(1)
+				}
+				return
+			}
+
+			start := time.Now()
+			results, err := queueAndBuild(ctx, buildConfig, chainConfig)
+			fmt.Printf("Built %d of %d images\n", len(results)-len(results.Failed())-len(results.Skipped()), len(results)-len(results.Skipped()))
+
+			reportJSON, _ := cmdFlags.GetString(flagReportJSON)
+			reportJUnit, _ := cmdFlags.GetString(flagReportJUnit)
+			if err := builder.NewBuildReport(results, time.Since(start)).WriteFiles(reportJSON, reportJUnit); err != nil {
+				fmt.Println(err)
+			}
+
+			if err != nil {
+				fmt.Printf("Some images failed to build:\n%v\n", err)
+				stop()
+				os.Exit(1)
+			}
 		},
 	}
 
@@ -171,7 +261,7 @@ An optional flag --tag/-t is now available to override the resulting docker imag
 
 	// Chain config options
 	buildCmd.PersistentFlags().StringVarP(&chainConfig.chain, flagChain, "c", "", "Cosmos chain to build from chains.yaml")
-	buildCmd.PersistentFlags().StringVarP(&chainConfig.ref, flagGitRef, "g", "", "Github short ref to build (branch, tag)")
+	buildCmd.PersistentFlags().StringVarP(&chainConfig.ref, flagGitRef, "g", "", "Git ref to build (branch, tag or full commit SHA)")
 	buildCmd.PersistentFlags().StringVarP(&chainConfig.tag, flagTag, "t", "", "Resulting docker image tag. If not provided, will derive from ref.")
 	buildCmd.PersistentFlags().Int16VarP(&chainConfig.number, flagNumber, "n", 5, "Number of releases to build per chain")
 	buildCmd.PersistentFlags().Int16Var(&chainConfig.parallel, flagParallel, 1, "Number of docker builds to run simultaneously")
@@ -180,32 +270,151 @@ An optional flag --tag/-t is now available to override the resulting docker imag
 	buildCmd.PersistentFlags().BoolVar(&chainConfig.race, flagRace, false, "Enable race detector (go builds only)")
 
 	// Chain config override flags (overwrites chains.yaml params)
-	buildCmd.PersistentFlags().StringVarP(&chainConfig.orgOverride, flagOrg, "o", "", "github-organization override for building from a fork")
-	buildCmd.PersistentFlags().StringVar(&chainConfig.repoOverride, flagRepo, "", "github-repo override for building from a fork")
-	buildCmd.PersistentFlags().StringVar(&chainConfig.repoHostOverride, flagRepoHost, "", "repo-host Git repository host override for building from a fork")
-	buildCmd.PersistentFlags().StringVar(&chainConfig.cloneKeyOverride, flagCloneKey, "", "base64 encoded ssh key to authenticate")
-	buildCmd.PersistentFlags().StringVar(&chainConfig.dockerfileOverride, flagDockerfile, "", "dockerfile override (cosmos, cargo, imported, none)")
-	buildCmd.PersistentFlags().StringVar(&chainConfig.buildDirOverride, flagBuildDir, "", "build-dir override - repo relative directory to run build target")
-	buildCmd.PersistentFlags().StringVar(&chainConfig.preBuildOverride, flagPreBuild, "", "pre-build override - command(s) to run prior to build-target")
-	buildCmd.PersistentFlags().StringVar(&chainConfig.buildTargetOverride, flagBuildTarget, "", "Build target (build-target) override")
-	buildCmd.PersistentFlags().StringVar(&chainConfig.buildEnvOverride, flagBuildEnv, "", "build-env override - Build environment variables")
-	buildCmd.PersistentFlags().StringVar(&chainConfig.binariesOverride, flagBinaries, "", "binaries override - Binaries after build phase to package into final image")
-	buildCmd.PersistentFlags().StringVar(&chainConfig.librariesOverride, flagLibraries, "", "libraries override - Libraries after build phase to package into final image")
+	addChainConfigOverrideFlags(buildCmd, &chainConfig)
+
+	// Credentials for private repositories
+	addSecretFlags(buildCmd, &buildConfig.Secrets)
+
+	// Vulnerability audit of the go modules of builds
+	addVulnDBFlags(buildCmd)
 
 	// Docker specific flags
 	buildCmd.PersistentFlags().StringVarP(&buildConfig.ContainerRegistry, flagRegistry, "r", "", "Docker Container Registry for pushing images")
 	buildCmd.PersistentFlags().BoolVarP(&buildConfig.SkipPush, flagSkip, "s", false, "Skip pushing images to registry")
 	buildCmd.PersistentFlags().StringVar(&buildConfig.TarExportPath, flagTarExport, "", "File path to export built image as docker tarball")
-	buildCmd.PersistentFlags().BoolVarP(&buildConfig.UseBuildKit, flagUseBuildkit, "b", false, "Use buildkit to build multi-arch images")
+	buildCmd.PersistentFlags().BoolVarP(&buildConfig.UseBuildKit, flagUseBuildkit, "b", false, "Use buildkit to build multi-arch images, same as --builder buildkit")
+	buildCmd.PersistentFlags().String(flagBuilder, "", "Build backend: "+strings.Join(docker.BuilderNames, ", ")+". Defaults to docker, podman uses CONTAINER_HOST or the podman socket of the current user")
 	buildCmd.PersistentFlags().StringVar(&buildConfig.BuildKitAddr, flagBuildkitAddr, docker.BuildKitSock, "Address of the buildkit socket, can be unix, tcp, ssl")
-	buildCmd.PersistentFlags().StringVarP(&buildConfig.Platform, flagPlatform, "p", docker.DefaultPlatforms, "Platforms to build (only applies to buildkit builds with -b)")
+	buildCmd.PersistentFlags().StringVarP(&buildConfig.Platform, flagPlatform, "p", docker.DefaultPlatforms, "Platforms to build (only applies to buildkit builds)")
 	buildCmd.PersistentFlags().BoolVar(&buildConfig.NoCache, flagNoCache, false, "Don't use docker cache for building")
 	buildCmd.PersistentFlags().BoolVar(&buildConfig.NoBuildCache, flagNoBuildCache, false, "Invalidate caches for clone and build.")
+	addBuildCacheFlags(buildCmd, &buildConfig)
 	buildCmd.PersistentFlags().StringVar(&buildConfig.GoVersion, flagGoVersion, "", "Go version override to use for building (go builds only)")
 	buildCmd.PersistentFlags().StringVar(&buildConfig.AlpineVersion, flagAlpineVersion, "", "Alpine version override to use for building (go builds only)")
+	buildCmd.PersistentFlags().StringVar(&buildConfig.RustToolchain, flagRustToolchain, "", "Rust toolchain override to use for building (cargo builds only)")
+	buildCmd.PersistentFlags().String(flagGoVersionsFile, "", "Go version catalog file (defaults to the embedded catalog merged with the catalog cached by go-versions update)")
+	buildCmd.PersistentFlags().BoolVar(&buildConfig.SkipExisting, flagSkipExisting, true, "Skip builds whose image tag already exists in the container registry for all platforms (only applies when pushing, branches are checked by the tag of their current commit)")
+	buildCmd.PersistentFlags().DurationVar(&buildConfig.BuildTimeout, flagTimeout, 180*time.Minute, "Timeout for each image build, 0 for no timeout")
+	buildCmd.PersistentFlags().Bool(flagForce, false, "Build and push images even if their tags already exist in the container registry")
+	buildCmd.PersistentFlags().Bool(flagDryRun, false, "Print the fully resolved builds without building them, no docker or buildkit connection is needed")
+	buildCmd.PersistentFlags().String(flagDryRunFormat, dryRunFormatTable, "Output format for --dry-run: table or json")
+	buildCmd.PersistentFlags().String(flagReportJSON, "", "File path to write a JSON report of the build results to")
+	buildCmd.PersistentFlags().String(flagReportJUnit, "", "File path to write a JUnit XML report of the build results to")
 
 	// DEPRECATED
 	buildCmd.PersistentFlags().StringP(flagVersion, "v", "", "DEPRECATED, use --git-ref/-g instead")
 
 	return buildCmd
 }
+
+// addChainConfigOverrideFlags adds the flags which override chains.yaml params to cmd.
+func addChainConfigOverrideFlags(cmd *cobra.Command, chainConfig *chainConfigFlags) {
+	cmd.PersistentFlags().StringVarP(&chainConfig.orgOverride, flagOrg, "o", "", "github-organization override for building from a fork")
+	cmd.PersistentFlags().StringVar(&chainConfig.repoOverride, flagRepo, "", "github-repo override for building from a fork")
+	cmd.PersistentFlags().StringVar(&chainConfig.repoHostOverride, flagRepoHost, "", "repo-host Git repository host override for building from a fork")
+	cmd.PersistentFlags().StringVar(&chainConfig.repoTypeOverride, flagRepoType, "", "repo-type override - API used to find releases (github, gitlab, gitea, git)")
+	cmd.PersistentFlags().BoolVar(&chainConfig.prereleases, flagPrereleases, false, "Also build prereleases when building the most recent releases")
+	cmd.PersistentFlags().StringVar(&chainConfig.releaseConstraint, flagReleaseConstraint, "", "release-constraint override - only build the most recent releases matching a semver constraint")
+	cmd.PersistentFlags().StringVar(&chainConfig.releaseTagPattern, flagReleaseTagPattern, "", "release-tag-pattern override - only build the most recent releases with tags matching a regular expression")
+	cmd.PersistentFlags().StringVar(&chainConfig.cloneKeyOverride, flagCloneKey, "", "DEPRECATED, base64 encoded ssh key to authenticate. Use --clone-key-file or "+envCloneKey+" instead")
+	cmd.PersistentFlags().StringVar(&chainConfig.dockerfileOverride, flagDockerfile, "", "dockerfile override (cosmos, cargo, imported, none)")
+	cmd.PersistentFlags().StringVar(&chainConfig.buildDirOverride, flagBuildDir, "", "build-dir override - repo relative directory to run build target")
+	cmd.PersistentFlags().StringVar(&chainConfig.preBuildOverride, flagPreBuild, "", "pre-build override - command(s) to run prior to build-target")
+	cmd.PersistentFlags().StringVar(&chainConfig.buildTargetOverride, flagBuildTarget, "", "Build target (build-target) override")
+	cmd.PersistentFlags().StringVar(&chainConfig.buildEnvOverride, flagBuildEnv, "", "build-env override - Build environment variables")
+	cmd.PersistentFlags().StringVar(&chainConfig.binariesOverride, flagBinaries, "", "binaries override - Binaries after build phase to package into final image")
+	cmd.PersistentFlags().StringVar(&chainConfig.librariesOverride, flagLibraries, "", "libraries override - Libraries after build phase to package into final image")
+}
+
+// addSecretFlags adds the flags for the credentials of private repositories and go modules to cmd.
+func addSecretFlags(cmd *cobra.Command, secrets *builder.BuildSecrets) {
+	cmd.PersistentFlags().StringVar(&secrets.CloneKeyFile, flagCloneKeyFile, "", "ssh private key file to clone private repositories with (passed as a secret for buildkit builds). A base64 encoded key is also read from "+envCloneKey)
+	cmd.PersistentFlags().BoolVar(&secrets.SSHAgent, flagSSHAgent, false, "Forward the local ssh-agent to clone private repositories (buildkit builds only)")
+	cmd.PersistentFlags().StringVar(&secrets.KnownHostsFile, flagKnownHosts, "", "known_hosts file to verify ssh host keys with, instead of SSH_KNOWN_HOSTS or ~/.ssh/known_hosts. Chain configs can pin host keys with repo-host-fingerprints")
+	cmd.PersistentFlags().String(flagGitTokenFile, "", "File with a token for https git, e.g. private go modules, mounted as ~/.netrc (buildkit builds only). Also read from "+envGitToken)
+}
+
+// loadBuilder sets the build backend selected by the flags in buildConfig.
+func loadBuilder(cmd *cobra.Command, buildConfig *builder.HeighlinerDockerBuildConfig) error {
+	name, _ := cmd.Flags().GetString(flagBuilder)
+	switch {
+	case name == "" && buildConfig.UseBuildKit:
+		name = docker.BuilderBuildKit
+	case name == "":
+		name = docker.BuilderDocker
+	case buildConfig.UseBuildKit && name != docker.BuilderBuildKit:
+		return fmt.Errorf("--%s can not be used with --%s %s", flagUseBuildkit, flagBuilder, name)
+	}
+
+	var address string
+	if name == docker.BuilderBuildKit {
+		address = buildConfig.BuildKitAddr
+	}
+	backend, err := docker.NewBuilder(name, address)
+	if err != nil {
+		return err
+	}
+	buildConfig.Backend = backend
+	return nil
+}
+
+// addBuildCacheFlags adds the flags for the build caches of buildkit builds to cmd.
+func addBuildCacheFlags(cmd *cobra.Command, buildConfig *builder.HeighlinerDockerBuildConfig) {
+	cmd.PersistentFlags().String(flagBuildCache, "", "Build cache of all chains (buildkit builds only), overriding build-cache of chains.yaml: registry (<image>:buildcache-<tag>), inline, local or none")
+	cmd.PersistentFlags().StringVar(&buildConfig.BuildCacheDir, flagBuildCacheDir, "", "Directory of local build caches, one per chain and tag (defaults to heighliner/buildcache in the user cache directory)")
+	cmd.PersistentFlags().StringSlice(flagCacheFrom, nil, "Additional build caches to import for all builds, e.g. type=registry,ref=ghcr.io/org/cache:gaia (buildkit builds only)")
+	cmd.PersistentFlags().StringSlice(flagCacheTo, nil, "Additional build caches to export for all builds, e.g. type=local,dest=path,mode=max (buildkit builds only)")
+}
+
+// loadBuildCache parses the build cache flags into buildConfig. The build backend must be loaded first.
+func loadBuildCache(cmd *cobra.Command, buildConfig *builder.HeighlinerDockerBuildConfig) error {
+	cmdFlags := cmd.Flags()
+	mode, _ := cmdFlags.GetString(flagBuildCache)
+	var err error
+	if buildConfig.BuildCache, err = builder.ParseBuildCacheMode(mode); err != nil {
+		return fmt.Errorf("invalid --%s: %w", flagBuildCache, err)
+	}
+
+	for _, flag := range []string{flagCacheFrom, flagCacheTo} {
+		values, _ := cmdFlags.GetStringSlice(flag)
+		for _, value := range values {
+			opts, err := docker.ParseCacheOptions(value)
+			if err != nil {
+				return fmt.Errorf("invalid --%s: %w", flag, err)
+			}
+			if flag == flagCacheFrom {
+				buildConfig.CacheFrom = append(buildConfig.CacheFrom, opts)
+			} else {
+				buildConfig.CacheTo = append(buildConfig.CacheTo, opts)
+			}
+		}
+	}
+
+	explicit := (buildConfig.BuildCache != "" && buildConfig.BuildCache != builder.BuildCacheNone) ||
+		len(buildConfig.CacheFrom) > 0 || len(buildConfig.CacheTo) > 0
+	if explicit && !buildConfig.Backend.Capabilities().Cache {
+		return fmt.Errorf("the %s builder does not support build caches, use --%s %s", buildConfig.Backend.Name(), flagBuilder, docker.BuilderBuildKit)
+	}
+	return nil
+}
+
+// loadSecrets reads the secrets which are provided by file or environment variable.
+func loadSecrets(cmd *cobra.Command, secrets *builder.BuildSecrets) error {
+	if cloneKey, _ := cmd.Flags().GetString(flagCloneKey); cloneKey != "" {
+		fmt.Printf("Warning: --%s is visible in shell history and the process list, use --%s or %s instead\n", flagCloneKey, flagCloneKeyFile, envCloneKey)
+	}
+
+	if secrets.CloneKeyFile == "" {
+		secrets.CloneKey = os.Getenv(envCloneKey)
+	}
+
+	secrets.GitToken = os.Getenv(envGitToken)
+	if tokenFile, _ := cmd.Flags().GetString(flagGitTokenFile); tokenFile != "" {
+		bz, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return fmt.Errorf("error reading git token file: %w", err)
+		}
+		secrets.GitToken = strings.TrimSpace(string(bz))
+	}
+	return nil
+}
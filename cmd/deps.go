@@ -0,0 +1,354 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/strangelove-ventures/heighliner/builder"
+	"golang.org/x/mod/module"
+)
+
+const depsFormatMarkdown = "markdown"
+
+var defaultDepsModules = []string{goModule, "cosmos-sdk", "cometbft", "ibc-go", "wasmvm"}
+
+func DepsCmd() *cobra.Command {
+	var depsCmd = &cobra.Command{
+		Use:   "deps",
+		Short: "Show the dependency versions of the most recent releases of the chains",
+		Long: `Show a matrix of the go version and the versions of go modules, e.g. cosmos-sdk and ibc-go,
+that each of the most recent releases of each chain requires, resolving replace directives.
+Only the go.mod and go.work of each release are fetched, the repos are not cloned.
+
+Modules are given by name, one of: ` + strings.Join(append(sortedKeys(knownModules), goModule), ", ") + `, or by module path.
+Use "deps diff" to compare all modules of two refs of a chain.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdFlags := cmd.Flags()
+
+			configFile, _ := cmdFlags.GetString(flagFile)
+			if err := loadChains(configFile); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			chainName, _ := cmdFlags.GetString(flagChain)
+			number, _ := cmdFlags.GetInt16(flagNumber)
+			modules, _ := cmdFlags.GetStringSlice(flagModules)
+			format, _ := cmdFlags.GetString(flagFormat)
+
+			if err := depsMatrix(context.Background(), chainName, number, modules, format); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	depsCmd.PersistentFlags().StringP(flagFile, "f", "", "chains.yaml config file path (searches for chains.yaml in current directory by default)")
+	depsCmd.PersistentFlags().String(flagFormat, depsFormatMarkdown, "Output format: markdown, csv or json")
+	depsCmd.Flags().StringP(flagChain, "c", "", "Only show this chain")
+	depsCmd.Flags().Int16P(flagNumber, "n", 3, "Number of releases per chain")
+	depsCmd.Flags().StringSlice(flagModules, defaultDepsModules, "Modules to show, by name or module path")
+
+	depsCmd.AddCommand(depsDiffCmd())
+
+	return depsCmd
+}
+
+func depsDiffCmd() *cobra.Command {
+	var diffCmd = &cobra.Command{
+		Use:   "diff CHAIN FROM TO",
+		Short: "Show the modules which changed between two refs of a chain",
+		Long: `Show the go version and all required go modules which changed between the FROM and TO refs of a chain,
+e.g. "heighliner deps diff gaia v15.0.0 v16.0.0". Refs are tags, branches or commits.`,
+		Args: cobra.ExactArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdFlags := cmd.Flags()
+
+			configFile, _ := cmdFlags.GetString(flagFile)
+			if err := loadChains(configFile); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			format, _ := cmdFlags.GetString(flagFormat)
+			if err := depsDiff(context.Background(), args[0], args[1], args[2], format); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+	return diffCmd
+}
+
+// checkDepsFormat returns an error if format is not an output format of deps. The output is written to
+// stdout and progress output to stderr, so that the output can be redirected to a file.
+func checkDepsFormat(format string) error {
+	switch format {
+	case depsFormatMarkdown, listFormatCSV, listFormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, must be %s, %s or %s", format, depsFormatMarkdown, listFormatCSV, listFormatJSON)
+	}
+}
+
+// findChain returns the chain config named name.
+func findChain(name string) (builder.ChainNodeConfig, error) {
+	for _, chain := range chains {
+		if chain.Name == name {
+			return chain, nil
+		}
+	}
+	return builder.ChainNodeConfig{}, fmt.Errorf("chain %s not found", name)
+}
+
+// depsMatrix writes the modules of the most recent releases of the chains, or only chainName if set.
+func depsMatrix(ctx context.Context, chainName string, number int16, modules []string, format string) error {
+	if err := checkDepsFormat(format); err != nil {
+		return err
+	}
+	w, progress := os.Stdout, os.Stderr
+
+	var depsChains []builder.ChainNodeConfig
+	for _, chain := range chains {
+		if chainName == "" || chain.Name == chainName {
+			depsChains = append(depsChains, chain)
+		}
+	}
+	if len(depsChains) == 0 {
+		return fmt.Errorf("chain %s not found", chainName)
+	}
+
+	// chains whose releases could not be fetched are listed with their error.
+	var jobs []listedChain
+	var jobChains []builder.ChainNodeConfig
+	for i, fetched := range fetchMostRecentReleases(ctx, progress, depsChains, builder.BuildSecrets{}, number) {
+		if fetched.err != nil {
+			jobs = append(jobs, listedChain{Chain: depsChains[i].Name, Error: fetched.err.Error()})
+			jobChains = append(jobChains, builder.ChainNodeConfig{})
+			continue
+		}
+		for _, build := range fetched.builds.ChainConfigs {
+			jobs = append(jobs, listedChain{Chain: depsChains[i].Name, Ref: build.Ref})
+			jobChains = append(jobChains, depsChains[i])
+		}
+	}
+
+	sem := make(chan struct{}, releaseFetchParallelism)
+	var wg sync.WaitGroup
+	for i := range jobs {
+		if jobs[i].Error != "" {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := listChain(ctx, progress, jobChains[i], false, modules, &jobs[i]); err != nil {
+				jobs[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	switch format {
+	case listFormatJSON:
+		return writeListJSON(w, jobs, modules)
+	case listFormatCSV:
+		return writeListCSV(w, jobs, modules)
+	default:
+		return writeDepsMarkdown(w, jobs, modules)
+	}
+}
+
+// writeDepsMarkdown writes the modules of each chain release as a markdown table.
+func writeDepsMarkdown(w io.Writer, listed []listedChain, modules []string) error {
+	fmt.Fprintf(w, "| Chain | Release | %s |\n", strings.Join(modules, " | "))
+	fmt.Fprintf(w, "|---|---|%s\n", strings.Repeat("---|", len(modules)))
+	for _, l := range listed {
+		cells := make([]string, len(modules))
+		for i, name := range modules {
+			m, ok := l.Modules[name]
+			cells[i] = moduleCell(m, ok)
+		}
+		if l.Error != "" {
+			cells = []string{"error: " + markdownEscape(l.Error)}
+		}
+		if _, err := fmt.Fprintf(w, "| %s | %s | %s |\n", l.Chain, valueOrDash(l.Ref), strings.Join(cells, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moduleCell describes the version of a module, including its replacement.
+func moduleCell(m builder.ModuleVersion, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	if !m.Replaced() {
+		return m.Version
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s => %s %s", m.Version, m.ReplacePath, m.ReplaceVersion))
+}
+
+func markdownEscape(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+func valueOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// depsChange is a module which changed between two refs, nil where the module is not required.
+type depsChange struct {
+	Module string                 `json:"module"`
+	From   *builder.ModuleVersion `json:"from,omitempty"`
+	To     *builder.ModuleVersion `json:"to,omitempty"`
+}
+
+type depsDiffJSON struct {
+	Chain      string       `json:"chain"`
+	From       string       `json:"from"`
+	FromCommit string       `json:"fromCommit"`
+	To         string       `json:"to"`
+	ToCommit   string       `json:"toCommit"`
+	Changes    []depsChange `json:"changes"`
+}
+
+// depsDiff writes the modules which changed between the from and to refs of a chain.
+func depsDiff(ctx context.Context, chainName, from, to, format string) error {
+	if err := checkDepsFormat(format); err != nil {
+		return err
+	}
+	w, progress := os.Stdout, os.Stderr
+
+	chain, err := findChain(chainName)
+	if err != nil {
+		return err
+	}
+
+	var files [2]builder.ChainModFiles
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i, ref := range []string{from, to} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			files[i], errs[i] = builder.FetchChainModFiles(ctx, progress, chain, ref, builder.BuildSecrets{})
+		}()
+	}
+	wg.Wait()
+	for i, ref := range []string{from, to} {
+		if errs[i] != nil {
+			return fmt.Errorf("error fetching go.mod of %s at %s: %w", chainName, ref, errs[i])
+		}
+	}
+
+	diff := depsDiffJSON{
+		Chain:      chainName,
+		From:       from,
+		FromCommit: files[0].Commit,
+		To:         to,
+		ToCommit:   files[1].Commit,
+		Changes:    diffModules(allModules(files[0]), allModules(files[1])),
+	}
+
+	switch format {
+	case listFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	case listFormatCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"module", from, to})
+		for _, c := range diff.Changes {
+			_ = cw.Write([]string{c.Module, changeCell(c.From), changeCell(c.To)})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		fmt.Fprintf(w, "| Module | %s | %s |\n|---|---|---|\n", from, to)
+		for _, c := range diff.Changes {
+			fmt.Fprintf(w, "| %s | %s | %s |\n", c.Module, changeCell(c.From), changeCell(c.To))
+		}
+		if len(diff.Changes) == 0 {
+			_, err := fmt.Fprintf(w, "\nNo module changed between %s and %s\n", from, to)
+			return err
+		}
+		return nil
+	}
+}
+
+func changeCell(m *builder.ModuleVersion) string {
+	if m == nil {
+		return "-"
+	}
+	return moduleCell(*m, true)
+}
+
+// allModules returns the go version and all required modules of the mod files, by module path without
+// major version suffix, so that major version upgrades are changes of the same module.
+func allModules(files builder.ChainModFiles) map[string]builder.ModuleVersion {
+	modules := make(map[string]builder.ModuleVersion)
+	if goVersion := files.GoVersion(); goVersion != "" {
+		modules[goModule] = builder.ModuleVersion{Path: goModule, Version: goVersion}
+	}
+	for _, m := range builder.RequiredModules(files.Mod) {
+		path, _, _ := module.SplitPathVersion(m.Path)
+		modules[path] = m
+	}
+	return modules
+}
+
+// diffModules returns the modules which were added, removed or changed between from and to,
+// the go version first, followed by the modules sorted by path.
+func diffModules(from, to map[string]builder.ModuleVersion) []depsChange {
+	var names []string
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == goModule || names[j] == goModule {
+			return names[i] == goModule && names[j] != goModule
+		}
+		return names[i] < names[j]
+	})
+
+	changes := []depsChange{}
+	for _, name := range names {
+		f, inFrom := from[name]
+		t, inTo := to[name]
+		if inFrom && inTo && f == t {
+			continue
+		}
+		c := depsChange{Module: name}
+		if inFrom {
+			c.From = &f
+		}
+		if inTo {
+			c.To = &t
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/strangelove-ventures/heighliner/docker"
+)
+
+const (
+	flagGoVersionsFile = "go-versions-file"
+	flagURL            = "url"
+)
+
+func GoVersionsCmd() *cobra.Command {
+	var goVersionsCmd = &cobra.Command{
+		Use:   "go-versions",
+		Short: "Show or update the go versions used to pick golang build images",
+		Long: `The go version catalog maps each go minor version to its latest patch release and the alpine
+version of its golang image. The catalog embedded in heighliner is merged with the catalog cached by
+"go-versions update", so that new go patch releases are built without a heighliner release.
+Pass --go-versions-file to build, render and go-versions list to use a local catalog instead.`,
+	}
+	goVersionsCmd.AddCommand(goVersionsListCmd(), goVersionsUpdateCmd())
+	return goVersionsCmd
+}
+
+func goVersionsListCmd() *cobra.Command {
+	var listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the go versions of the catalog",
+		Run: func(cmd *cobra.Command, args []string) {
+			path, _ := cmd.Flags().GetString(flagGoVersionsFile)
+			catalog, err := builder.LoadGoCatalog(path)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "GO\tVERSION\tIMAGE")
+			for _, v := range catalog.Versions {
+				fmt.Fprintf(tw, "%s\t%s\tgolang:%s\n", v.Minor, v.Version, builder.GolangAlpineImage(v.Version, v.Alpine))
+			}
+			_ = tw.Flush()
+			fmt.Printf("\nOther go versions use alpine %s\n", catalog.LatestAlpine)
+		},
+	}
+	listCmd.Flags().String(flagGoVersionsFile, "", "Go version catalog file (defaults to the embedded catalog merged with the cached catalog)")
+	return listCmd
+}
+
+func goVersionsUpdateCmd() *cobra.Command {
+	var updateCmd = &cobra.Command{
+		Use:   "update",
+		Short: "Update the catalog with the latest go patch releases from go.dev",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdFlags := cmd.Flags()
+			url, _ := cmdFlags.GetString(flagURL)
+			output, _ := cmdFlags.GetString(flagOutput)
+			if output == "" {
+				var err error
+				if output, err = builder.GoCatalogCachePath(); err != nil {
+					fmt.Printf("Error finding the go versions cache: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			catalog, err := builder.LoadGoCatalog("")
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			ctx := context.Background()
+			versions, err := builder.FetchGoReleases(ctx, url)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			registry := docker.NewRegistryClient()
+			exists := func(ctx context.Context, goVersion string, alpineVersion string) (bool, error) {
+				_, exists, err := registry.ImagePlatforms(ctx, "golang", builder.GolangAlpineImage(goVersion, alpineVersion))
+				return exists, err
+			}
+			catalog, changes, err := catalog.Update(ctx, versions, exists)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			for _, change := range changes {
+				fmt.Println(change)
+			}
+			if len(changes) == 0 {
+				fmt.Println("Go versions are up to date")
+			}
+			if err := catalog.Write(output); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("Wrote go versions to %s\n", output)
+		},
+	}
+	updateCmd.Flags().String(flagURL, builder.GoReleasesURL, "URL of the go releases JSON")
+	updateCmd.Flags().String(flagOutput, "", "File to write the catalog to (default is the user cache directory)")
+	return updateCmd
+}
+
+// loadGoCatalog uses the go version catalog of the --go-versions-file flag of cmd, or the embedded
+// catalog merged with the cached catalog.
+func loadGoCatalog(cmd *cobra.Command) error {
+	path, _ := cmd.Flags().GetString(flagGoVersionsFile)
+	catalog, err := builder.LoadGoCatalog(path)
+	if err != nil {
+		return err
+	}
+	return builder.UseGoCatalog(catalog)
+}
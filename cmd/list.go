@@ -1,144 +1,323 @@
 package cmd
 
 import (
-	"crypto/tls"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"github.com/hashicorp/go-version"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
 
+	"github.com/hashicorp/go-version"
 	"github.com/spf13/cobra"
-	"golang.org/x/mod/modfile"
+	"github.com/strangelove-ventures/heighliner/builder"
 )
 
 func loadLocalChainsYaml() error {
-	// try to load a local chains.yaml, but do not panic for any error, will fall back to embedded chains.
+	// try to load a local chains.yaml, falling back to embedded chains if it does not exist.
 	cwd, err := os.Getwd()
 	if err != nil {
 		return err
 	}
 	chainsYamlSearchPath := filepath.Join(cwd, "chains/")
-	err = loadChainsYaml(chainsYamlSearchPath)
-	if err != nil {
+	if _, err := os.Stat(chainsYamlSearchPath); err != nil {
 		fmt.Printf("No config found at %s, using embedded chains. pass -f to configure chains.yaml path.\n", chainsYamlSearchPath)
 		return nil
 	}
+	if err := loadChainsYaml(chainsYamlSearchPath); err != nil {
+		return err
+	}
 	fmt.Printf("Loaded chains from %s\n", chainsYamlSearchPath)
 	return nil
 }
 
+const (
+	flagRef           = "ref"
+	flagLatestRelease = "latest-release"
+	flagModules       = "modules"
+	flagFormat        = "format"
+
+	listFormatTable = "table"
+	listFormatJSON  = "json"
+	listFormatCSV   = "csv"
+)
+
+// knownModules are the module paths of the names accepted by --modules. Other modules are listed by path.
+var knownModules = map[string]string{
+	"cosmos-sdk": "github.com/cosmos/cosmos-sdk",
+	"cometbft":   "github.com/cometbft/cometbft",
+	"tendermint": "github.com/tendermint/tendermint",
+	"ibc-go":     "github.com/cosmos/ibc-go",
+	"wasmd":      "github.com/CosmWasm/wasmd",
+	"wasmvm":     "github.com/CosmWasm/wasmvm",
+}
+
+// goModule lists the go version of the chains, from go.mod or go.work.
+const goModule = "go"
+
+var defaultListModules = []string{"cosmos-sdk", "cometbft", "ibc-go", "wasmd", "wasmvm"}
+
 func ListCmd() *cobra.Command {
 	var listCmd = &cobra.Command{
 		Use:   "list",
-		Short: "List the docker images. Currently only supports cosmos-based images.",
+		Short: "List the versions of the dependencies of the chains. Currently only supports go chains.",
+		Long: `List the versions of the go modules, e.g. cosmos-sdk and ibc-go, that each chain requires,
+resolving replace directives, along with a summary of the minor versions in use.
+The go.mod of the default branch of each chain is read, unless --ref or --latest-release is set.
+
+Modules are given by name, one of: ` + strings.Join(sortedKeys(knownModules), ", ") + `, or by module path.
+The go module lists the go version of the chain.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			cmdFlags := cmd.Flags()
 
 			configFile, _ := cmdFlags.GetString(flagFile)
-			if configFile == "" {
-				if err := loadLocalChainsYaml(); err != nil {
-					fmt.Println(err)
-				}
-			} else {
-				// if flag is explicitly provided, panic on error since intent was to override embedded chains.
-				if err := loadChainsYaml(configFile); err != nil {
-					panic(err)
-				}
+			if err := loadChains(configFile); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			chainName, _ := cmdFlags.GetString(flagChain)
+			ref, _ := cmdFlags.GetString(flagRef)
+			latestRelease, _ := cmdFlags.GetBool(flagLatestRelease)
+			modules, _ := cmdFlags.GetStringSlice(flagModules)
+			format, _ := cmdFlags.GetString(flagFormat)
+			if ref != "" && latestRelease {
+				fmt.Printf("--%s and --%s are mutually exclusive\n", flagRef, flagLatestRelease)
+				os.Exit(1)
+			}
+			if ref == "" {
+				ref = "HEAD"
+			}
+
+			if err := list(context.Background(), chainName, ref, latestRelease, modules, format); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
 			}
-			list()
 		},
 	}
 
 	listCmd.PersistentFlags().StringP(flagFile, "f", "", "chains.yaml config file path (searches for chains.yaml in current directory by default)")
+	listCmd.PersistentFlags().StringP(flagChain, "c", "", "Only list this chain")
+	listCmd.PersistentFlags().String(flagRef, "", "Git ref to list the dependencies of, instead of the default branch of each chain")
+	listCmd.PersistentFlags().Bool(flagLatestRelease, false, "List the dependencies of the most recent release of each chain, instead of the default branch")
+	listCmd.PersistentFlags().StringSlice(flagModules, defaultListModules, "Modules to list, by name or module path")
+	listCmd.PersistentFlags().String(flagFormat, listFormatTable, "Output format: table, json or csv")
 
 	return listCmd
 }
 
-func printError(err error) {
-	fmt.Printf("  error: %s\n", err)
+// listedChain are the versions of the listed modules required by a chain at a ref, by module name.
+type listedChain struct {
+	Chain   string                           `json:"chain"`
+	Ref     string                           `json:"ref"`
+	Commit  string                           `json:"commit,omitempty"`
+	Modules map[string]builder.ModuleVersion `json:"modules"`
+	Error   string                           `json:"error,omitempty"`
 }
 
-func list() {
-	requires := []string{
-		"cosmos-sdk",
-		"ibc-go",
-	}
-	stats := map[string]map[string]int{}
-	for _, r := range requires {
-		stats[r] = map[string]int{}
-	}
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	for _, chain := range chains {
-		fmt.Printf("\n%s:\n", chain.Name)
+// fetchListedChains fetches the go.mod of each chain concurrently, at ref or the most recent release,
+// writing progress output to progress.
+func fetchListedChains(ctx context.Context, progress io.Writer, chains []builder.ChainNodeConfig, ref string, latestRelease bool, modules []string) []listedChain {
+	listed := make([]listedChain, len(chains))
+	sem := make(chan struct{}, releaseFetchParallelism)
+	var wg sync.WaitGroup
+	for i, chain := range chains {
+		listed[i] = listedChain{Chain: chain.Name, Ref: ref, Modules: map[string]builder.ModuleVersion{}}
 		if chain.GithubOrganization == "" || chain.GithubRepo == "" {
-			printError(fmt.Errorf("not enough repo info; missing organization or repo"))
+			listed[i].Error = "not enough repo info; missing organization or repo"
 			continue
 		}
-		url := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/main/go.mod",
-			chain.GithubOrganization, chain.GithubRepo)
-		resp, err := http.Get(url)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := listChain(ctx, progress, chain, latestRelease, modules, &listed[i]); err != nil {
+				listed[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	return listed
+}
+
+func listChain(ctx context.Context, progress io.Writer, chain builder.ChainNodeConfig, latestRelease bool, modules []string, listed *listedChain) error {
+	if latestRelease {
+		builds, err := mostRecentReleasesForChain(ctx, progress, chain, builder.BuildSecrets{}, 1)
 		if err != nil {
-			printError(fmt.Errorf("GET %s: %w", url, err))
-			continue
+			return err
 		}
-		if resp.StatusCode != http.StatusOK {
-			printError(fmt.Errorf("GET %s: %s", url, resp.Status))
-			continue
+		if len(builds.ChainConfigs) == 0 {
+			return fmt.Errorf("no releases found")
 		}
-		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			printError(err)
+		listed.Ref = builds.ChainConfigs[0].Ref
+	}
+	files, err := builder.FetchChainModFiles(ctx, progress, chain, listed.Ref, builder.BuildSecrets{})
+	if err != nil {
+		return err
+	}
+	listed.Commit = files.Commit
+	// the default branch is listed by name.
+	if listed.Ref == "HEAD" && files.RefName != "" {
+		listed.Ref = files.RefName.Short()
+	}
+	listed.Modules = findModules(files, modules)
+	return nil
+}
+
+// findModules returns the versions of the modules required by the mod files, by name. The go module
+// is the go version of the chain.
+func findModules(files builder.ChainModFiles, modules []string) map[string]builder.ModuleVersion {
+	found := make(map[string]builder.ModuleVersion)
+	for _, name := range modules {
+		if name == goModule {
+			if goVersion := files.GoVersion(); goVersion != "" {
+				found[name] = builder.ModuleVersion{Path: goModule, Version: goVersion}
+			}
 			continue
 		}
-		mod, err := modfile.Parse("", body, nil)
-		if err != nil {
-			printError(fmt.Errorf("parsing go.mod: %w", err))
+		path, ok := knownModules[name]
+		if !ok {
+			path = name
+		}
+		if m, ok := builder.FindModule(files.Mod, path); ok {
+			found[name] = m
+		}
+	}
+	return found
+}
+
+func list(ctx context.Context, chainName, ref string, latestRelease bool, modules []string, format string) error {
+	var writeListed func(w io.Writer, listed []listedChain, modules []string) error
+	switch format {
+	case listFormatTable:
+		writeListed = writeListTable
+	case listFormatJSON:
+		writeListed = writeListJSON
+	case listFormatCSV:
+		writeListed = writeListCSV
+	default:
+		return fmt.Errorf("unknown format %q, must be %s, %s or %s", format, listFormatTable, listFormatJSON, listFormatCSV)
+	}
+	// progress output goes to stderr, so that stdout is only the listed chains.
+	var progress io.Writer = os.Stdout
+	if format != listFormatTable {
+		progress = os.Stderr
+	}
+
+	var listChains []builder.ChainNodeConfig
+	for _, chain := range chains {
+		if chainName == "" || chain.Name == chainName {
+			listChains = append(listChains, chain)
+		}
+	}
+	if len(listChains) == 0 {
+		return fmt.Errorf("chain %s not found", chainName)
+	}
+
+	return writeListed(os.Stdout, fetchListedChains(ctx, progress, listChains, ref, latestRelease, modules), modules)
+}
+
+func writeListJSON(w io.Writer, listed []listedChain, _ []string) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(listed)
+}
+
+// writeListCSV writes a row for each module of each chain, or a row with the error of chains which could not be listed.
+func writeListCSV(w io.Writer, listed []listedChain, modules []string) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"chain", "ref", "commit", "module", "path", "version", "replace_path", "replace_version", "error"})
+	for _, l := range listed {
+		if l.Error != "" {
+			_ = cw.Write([]string{l.Chain, l.Ref, l.Commit, "", "", "", "", "", l.Error})
 			continue
 		}
-		newStats := 0
-		for _, require := range mod.Require {
-			for _, r := range requires {
-				if strings.Contains(require.Mod.Path, r) {
-					fmt.Printf("  %s@%s\n", r, require.Mod.Version)
-					v, err := version.NewVersion(require.Mod.Version)
-					if err != nil {
-						printError(fmt.Errorf("parsing module version: %w", err))
-						continue
-					}
-					segments := v.Segments()
-					majorVersion := strconv.Itoa(segments[0]) + "." + strconv.Itoa(segments[1])
-					if _, found := stats[r][majorVersion]; !found {
-						stats[r][majorVersion] = 0
-					}
-					stats[r][majorVersion]++
-					newStats++
-				}
+		for _, name := range modules {
+			m, ok := l.Modules[name]
+			if !ok {
+				continue
 			}
+			_ = cw.Write([]string{l.Chain, l.Ref, l.Commit, name, m.Path, m.Version, m.ReplacePath, m.ReplaceVersion, ""})
 		}
-		if newStats == 0 {
-			printError(fmt.Errorf("no versions found"))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeListTable writes the module versions of each chain as a table, followed by the replaced modules
+// and a summary of the minor versions of each module.
+func writeListTable(w io.Writer, listed []listedChain, modules []string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "CHAIN\tREF\t%s\n", strings.ToUpper(strings.Join(modules, "\t")))
+	var replaced []string
+	for _, l := range listed {
+		if l.Error != "" {
+			fmt.Fprintf(tw, "%s\t%s\tERROR: %s\n", l.Chain, l.Ref, l.Error)
+			continue
 		}
+		cells := make([]string, len(modules))
+		for i, name := range modules {
+			m, ok := l.Modules[name]
+			if !ok {
+				cells[i] = "-"
+				continue
+			}
+			cells[i] = m.EffectiveVersion()
+			if m.Replaced() {
+				cells[i] += "*"
+				replaced = append(replaced, fmt.Sprintf("  %s %s %s => %s %s", l.Chain, m.Path, m.Version, m.ReplacePath, m.ReplaceVersion))
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", l.Chain, l.Ref, strings.Join(cells, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
 	}
-	fmt.Printf("\nSummary:\n")
-	for _, r := range requires {
-		fmt.Printf("\n  %s versions:\n", r)
+
+	if len(replaced) > 0 {
+		fmt.Fprintf(w, "\n* replaced:\n%s\n", strings.Join(replaced, "\n"))
+	}
+
+	fmt.Fprintf(w, "\nSummary:\n")
+	for _, name := range modules {
+		stats := map[string]int{}
 		total := 0
-		versions := make([]string, 0, len(stats[r]))
-		for v := range stats[r] {
-			versions = append(versions, v)
+		for _, l := range listed {
+			m, ok := l.Modules[name]
+			if !ok {
+				continue
+			}
+			v, err := version.NewVersion(m.EffectiveVersion())
+			if err != nil {
+				continue
+			}
+			segments := v.Segments()
+			stats[strconv.Itoa(segments[0])+"."+strconv.Itoa(segments[1])]++
+			total++
 		}
-		sort.Strings(versions)
-		for _, version := range versions {
-			count := stats[r][version]
-			fmt.Printf("    %s (%d)\n", version, count)
-			total += count
+		fmt.Fprintf(w, "\n  %s versions:\n", name)
+		for _, minor := range sortedKeys(stats) {
+			fmt.Fprintf(w, "    %s (%d)\n", minor, stats[minor])
 		}
-		fmt.Printf("    total: %d chains\n", total)
+		fmt.Fprintf(w, "    total: %d chains\n", total)
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+	return keys
 }
@@ -1,137 +1,176 @@
 package cmd
 
 import (
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/strangelove-ventures/heighliner/releases"
 )
 
-type GithubRelease struct {
-	TagName string `json:"tag_name"`
-}
-
 func mostRecentReleasesForChain(
+	ctx context.Context,
+	progress io.Writer,
 	chainNodeConfig builder.ChainNodeConfig,
+	secrets builder.BuildSecrets,
 	number int16,
 ) (builder.HeighlinerQueuedChainBuilds, error) {
 	if chainNodeConfig.GithubOrganization == "" || chainNodeConfig.GithubRepo == "" {
 		return builder.HeighlinerQueuedChainBuilds{}, fmt.Errorf("github organization: %s and/or repo: %s not provided for chain: %s", chainNodeConfig.GithubOrganization, chainNodeConfig.GithubRepo, chainNodeConfig.Name)
 	}
-	client := http.Client{Timeout: 5 * time.Second}
 
-	if chainNodeConfig.RepoHost != "" && chainNodeConfig.RepoHost != "github.com" {
-		return builder.HeighlinerQueuedChainBuilds{}, nil
+	repoHost := chainNodeConfig.RepoHost
+	if repoHost == "" {
+		repoHost = "github.com"
 	}
-
-	fmt.Printf("Fetching most recent releases for github.com/%s/%s\n", chainNodeConfig.GithubOrganization, chainNodeConfig.GithubRepo)
-
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://api.github.com/repos/%s/%s/releases?per_page=%d&page=1",
-		chainNodeConfig.GithubOrganization, chainNodeConfig.GithubRepo, number), http.NoBody)
+	source, err := builder.ReleaseSource(chainNodeConfig, secrets)
 	if err != nil {
-		return builder.HeighlinerQueuedChainBuilds{}, fmt.Errorf("error building github releases request: %v", err)
+		return builder.HeighlinerQueuedChainBuilds{}, err
 	}
-
-	githubUser, githubPAT := os.Getenv("GH_USER"), os.Getenv("GH_PAT")
-	if githubUser != "" && githubPAT != "" {
-		req.Header.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(githubUser+":"+githubPAT)))
-	}
-
-	res, err := client.Do(req)
+	repo := releases.Repo{Organization: chainNodeConfig.GithubOrganization, Name: chainNodeConfig.GithubRepo}
+	filter, err := chainNodeConfig.ReleaseFilter()
 	if err != nil {
-		return builder.HeighlinerQueuedChainBuilds{}, fmt.Errorf("error performing github releases request: %v", err)
-	}
-	if res.StatusCode != http.StatusOK {
-		return builder.HeighlinerQueuedChainBuilds{}, fmt.Errorf("status code: %v", res.StatusCode)
+		return builder.HeighlinerQueuedChainBuilds{}, err
 	}
 
-	defer res.Body.Close()
+	fmt.Fprintf(progress, "Fetching most recent releases for %s/%s\n", repoHost, repo)
 
-	body, err := io.ReadAll(res.Body)
+	rels, latest, err := releases.Find(ctx, source, repo, filter, int(number))
 	if err != nil {
-		return builder.HeighlinerQueuedChainBuilds{}, fmt.Errorf("error reading body from github releases request: %v", err)
+		return builder.HeighlinerQueuedChainBuilds{}, fmt.Errorf("error fetching releases for %s/%s: %w", repoHost, repo, err)
 	}
 
-	releases := []GithubRelease{}
-	err = json.Unmarshal(body, &releases)
-	if err != nil {
-		return builder.HeighlinerQueuedChainBuilds{}, fmt.Errorf("error parsing github releases response: %s, error: %v", body, err)
-	}
 	chainQueuedBuilds := builder.HeighlinerQueuedChainBuilds{}
-	for i, release := range releases {
-		fmt.Printf("Adding release tag to build queue: %s\n", release.TagName)
-		chainQueuedBuilds.ChainConfigs = append(chainQueuedBuilds.ChainConfigs, builder.ChainNodeDockerBuildConfig{
-			Build:  chainNodeConfig,
-			Ref:    release.TagName,
-			Latest: i == 0,
-		})
+	for i, release := range rels {
+		chainQueuedBuilds.ChainConfigs = append(chainQueuedBuilds.ChainConfigs,
+			builder.NewChainNodeDockerBuildConfig(chainNodeConfig, release.Tag, "", i == latest))
 	}
 
 	return chainQueuedBuilds, nil
 }
 
-func queueAndBuild(
+// releaseFetchParallelism is the number of chains to fetch the most recent releases for at once.
+const releaseFetchParallelism = 8
+
+type fetchedReleases struct {
+	builds builder.HeighlinerQueuedChainBuilds
+	err    error
+}
+
+// fetchMostRecentReleases fetches the most recent releases of the chains concurrently, authenticating
+// with secrets, returning the queued builds of each chain in the order of chainNodeConfigs.
+func fetchMostRecentReleases(
+	ctx context.Context,
+	progress io.Writer,
+	chainNodeConfigs []builder.ChainNodeConfig,
+	secrets builder.BuildSecrets,
+	number int16,
+) []fetchedReleases {
+	fetched := make([]fetchedReleases, len(chainNodeConfigs))
+	sem := make(chan struct{}, releaseFetchParallelism)
+	var wg sync.WaitGroup
+	for i, chainNodeConfig := range chainNodeConfigs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			builds, err := mostRecentReleasesForChain(ctx, progress, chainNodeConfig, secrets, number)
+			fetched[i] = fetchedReleases{builds: builds, err: err}
+		}()
+	}
+	wg.Wait()
+	return fetched
+}
+
+// applyOverrides sets the fields of the chain config which are overridden by flags.
+func (chainConfig chainConfigFlags) applyOverrides(chainNodeConfig *builder.ChainNodeConfig) {
+	if chainConfig.orgOverride != "" {
+		chainNodeConfig.GithubOrganization = chainConfig.orgOverride
+	}
+	if chainConfig.repoOverride != "" {
+		chainNodeConfig.GithubRepo = chainConfig.repoOverride
+	}
+	if chainConfig.repoHostOverride != "" {
+		chainNodeConfig.RepoHost = chainConfig.repoHostOverride
+	}
+	if chainConfig.repoTypeOverride != "" {
+		chainNodeConfig.RepoType = releases.HostType(chainConfig.repoTypeOverride)
+	}
+	if chainConfig.prereleases {
+		chainNodeConfig.Prereleases = true
+	}
+	if chainConfig.releaseConstraint != "" {
+		chainNodeConfig.ReleaseConstraint = chainConfig.releaseConstraint
+	}
+	if chainConfig.releaseTagPattern != "" {
+		chainNodeConfig.ReleaseTagPattern = chainConfig.releaseTagPattern
+	}
+	if chainConfig.cloneKeyOverride != "" {
+		chainNodeConfig.CloneKey = chainConfig.cloneKeyOverride
+	}
+	if chainConfig.buildTargetOverride != "" {
+		chainNodeConfig.BuildTarget = chainConfig.buildTargetOverride
+	}
+	if chainConfig.buildEnvOverride != "" {
+		chainNodeConfig.BuildEnv = strings.Split(chainConfig.buildEnvOverride, " ")
+	}
+	if chainConfig.binariesOverride != "" {
+		chainNodeConfig.Binaries = strings.Split(chainConfig.binariesOverride, " ")
+	}
+	if chainConfig.librariesOverride != "" {
+		chainNodeConfig.Libraries = strings.Split(chainConfig.librariesOverride, " ")
+	}
+}
+
+// queueBuilds returns a builder with the builds for the chain config flags queued, writing progress output
+// of queueing and building to progress.
+func queueBuilds(
+	ctx context.Context,
+	progress io.Writer,
 	buildConfig builder.HeighlinerDockerBuildConfig,
 	chainConfig chainConfigFlags,
-) {
+) *builder.HeighlinerBuilder {
+	buildConfig.Progress = progress
 	heighlinerBuilder := builder.NewHeighlinerBuilder(buildConfig, chainConfig.parallel, chainConfig.local, chainConfig.race)
 
+	var releaseChains []builder.ChainNodeConfig
 	for _, chainNodeConfig := range chains {
 		// If chain is provided, only build images for that chain
 		// Chain must be declared in chains.yaml
 		if chainConfig.chain != "" && chainNodeConfig.Name != chainConfig.chain {
 			continue
 		}
-		if chainConfig.orgOverride != "" {
-			chainNodeConfig.GithubOrganization = chainConfig.orgOverride
-		}
-		if chainConfig.repoOverride != "" {
-			chainNodeConfig.GithubRepo = chainConfig.repoOverride
-		}
-		if chainConfig.repoHostOverride != "" {
-			chainNodeConfig.RepoHost = chainConfig.repoHostOverride
-		}
-		if chainConfig.cloneKeyOverride != "" {
-			chainNodeConfig.CloneKey = chainConfig.cloneKeyOverride
-		}
-		if chainConfig.buildTargetOverride != "" {
-			chainNodeConfig.BuildTarget = chainConfig.buildTargetOverride
-		}
-		if chainConfig.buildEnvOverride != "" {
-			chainNodeConfig.BuildEnv = strings.Split(chainConfig.buildEnvOverride, " ")
-		}
-		if chainConfig.binariesOverride != "" {
-			chainNodeConfig.Binaries = strings.Split(chainConfig.binariesOverride, " ")
-		}
-		if chainConfig.librariesOverride != "" {
-			chainNodeConfig.Libraries = strings.Split(chainConfig.librariesOverride, " ")
-		}
+		chainConfig.applyOverrides(&chainNodeConfig)
 		chainQueuedBuilds := builder.HeighlinerQueuedChainBuilds{ChainConfigs: []builder.ChainNodeDockerBuildConfig{}}
 		if chainConfig.ref != "" || chainConfig.local {
-			chainConfig := builder.ChainNodeDockerBuildConfig{
-				Build:  chainNodeConfig,
-				Ref:    chainConfig.ref,
-				Tag:    chainConfig.tag,
-				Latest: chainConfig.latest,
-			}
-			chainQueuedBuilds.ChainConfigs = append(chainQueuedBuilds.ChainConfigs, chainConfig)
-			heighlinerBuilder.AddToQueue(chainQueuedBuilds)
-			heighlinerBuilder.BuildImages()
-			return
+			chainBuild := builder.NewChainNodeDockerBuildConfig(chainNodeConfig, chainConfig.ref, chainConfig.tag, chainConfig.latest)
+			// overrides take precedence over the chain config for the ref.
+			chainConfig.applyOverrides(&chainBuild.Build)
+			chainQueuedBuilds.ChainConfigs = append(chainQueuedBuilds.ChainConfigs, chainBuild)
+			heighlinerBuilder.AddToQueue(ctx, chainQueuedBuilds)
+			return heighlinerBuilder
 		}
 		// If specific version not provided, build images for the last n releases from the chain
-		chainBuilds, err := mostRecentReleasesForChain(chainNodeConfig, chainConfig.number)
-		if err != nil {
-			fmt.Printf("Error queueing docker image builds for chain %s: %v", chainNodeConfig.Name, err)
+		releaseChains = append(releaseChains, chainNodeConfig)
+	}
+
+	for i, chainBuilds := range fetchMostRecentReleases(ctx, progress, releaseChains, buildConfig.Secrets, chainConfig.number) {
+		if chainBuilds.err != nil {
+			fmt.Fprintf(progress, "Error queueing docker image builds for chain %s: %v\n", releaseChains[i].Name, chainBuilds.err)
 			continue
 		}
-		heighlinerBuilder.AddToQueue(chainBuilds)
+		for j := range chainBuilds.builds.ChainConfigs {
+			fmt.Fprintf(progress, "Adding %s release tag to build queue: %s\n", releaseChains[i].Name, chainBuilds.builds.ChainConfigs[j].Ref)
+			chainConfig.applyOverrides(&chainBuilds.builds.ChainConfigs[j].Build)
+		}
+		heighlinerBuilder.AddToQueue(ctx, chainBuilds.builds)
 	}
 
 	if heighlinerBuilder.QueueLen() == 0 {
@@ -140,6 +179,7 @@ func queueAndBuild(
 			Build: builder.ChainNodeConfig{
 				Name:               chainConfig.chain,
 				RepoHost:           chainConfig.repoHostOverride,
+				RepoType:           releases.HostType(chainConfig.repoTypeOverride),
 				GithubOrganization: chainConfig.orgOverride,
 				GithubRepo:         chainConfig.repoOverride,
 				CloneKey:           chainConfig.cloneKeyOverride,
@@ -156,8 +196,42 @@ func queueAndBuild(
 			Latest: chainConfig.latest,
 		}
 		chainQueuedBuilds.ChainConfigs = append(chainQueuedBuilds.ChainConfigs, chainConfig)
-		heighlinerBuilder.AddToQueue(chainQueuedBuilds)
+		heighlinerBuilder.AddToQueue(ctx, chainQueuedBuilds)
 	}
 
-	heighlinerBuilder.BuildImages()
+	return heighlinerBuilder
+}
+
+func queueAndBuild(
+	ctx context.Context,
+	buildConfig builder.HeighlinerDockerBuildConfig,
+	chainConfig chainConfigFlags,
+) (builder.BuildResults, error) {
+	return queueBuilds(ctx, os.Stdout, buildConfig, chainConfig).BuildImages(ctx)
+}
+
+// queueAndPlan resolves the queued builds without building them, and writes the plans to stdout in format, table or json.
+func queueAndPlan(
+	ctx context.Context,
+	buildConfig builder.HeighlinerDockerBuildConfig,
+	chainConfig chainConfigFlags,
+	format string,
+) error {
+	var progress io.Writer = os.Stdout
+	writePlans := builder.WritePlansTable
+	switch format {
+	case dryRunFormatTable:
+	case dryRunFormatJSON:
+		writePlans = builder.WritePlansJSON
+		// progress output goes to stderr, so that stdout is only the json plans.
+		progress = os.Stderr
+	default:
+		return fmt.Errorf("unknown dry run format %q, must be %s or %s", format, dryRunFormatTable, dryRunFormatJSON)
+	}
+
+	plans, err := queueBuilds(ctx, progress, buildConfig, chainConfig).Plan(ctx)
+	if writeErr := writePlans(os.Stdout, plans); writeErr != nil {
+		return fmt.Errorf("error writing build plans: %w", writeErr)
+	}
+	return err
 }
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/strangelove-ventures/heighliner/builder"
+	"github.com/strangelove-ventures/heighliner/docker"
+)
+
+const flagOutput = "output"
+
+func RenderCmd() *cobra.Command {
+	var chainConfig chainConfigFlags
+	var buildConfig builder.HeighlinerDockerBuildConfig
+
+	var renderCmd = &cobra.Command{
+		Use:   "render",
+		Short: "Render the Dockerfile, build args and docker command for a build",
+		Long: `Write the Dockerfile and build args that heighliner would build a chain ref with,
+along with a script to run the same build with plain docker, into the output directory.
+If --git-ref is not provided, the most recent release is rendered.
+
+For native docker builds, the build args include the clone key, if any, so they are only readable by the owner.
+Buildkit builds read the clone key from the local ssh-agent instead.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdFlags := cmd.Flags()
+
+			configFile, _ := cmdFlags.GetString(flagFile)
+			if err := loadChains(configFile); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if err := loadSecrets(cmd, &buildConfig.Secrets); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if err := loadGoCatalog(cmd); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if err := loadBuilder(cmd, &buildConfig); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if err := loadBuildCache(cmd, &buildConfig); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			// only the most recent release when no ref is provided.
+			chainConfig.number = 1
+			chainConfig.parallel = 1
+
+			ctx := context.Background()
+			plans, err := queueBuilds(ctx, os.Stdout, buildConfig, chainConfig).Plan(ctx)
+			if len(plans) != 1 {
+				fmt.Printf("Render requires exactly one build, found %d. Specify a chain with --chain and a ref with --git-ref\n", len(plans))
+				os.Exit(1)
+			}
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			plan := plans[0]
+
+			outDir, _ := cmdFlags.GetString(flagOutput)
+			if outDir == "" {
+				outDir = filepath.Join("render", plan.Chain+"-"+plan.Tag)
+			}
+			if err := plan.Render(outDir); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Rendered %s %s (%s) to %s\n", plan.Chain, plan.Ref, plan.DockerfileSource, outDir)
+			fmt.Printf("Build it with:\n  %s\n", filepath.Join(outDir, builder.RenderedScript))
+		},
+	}
+
+	renderCmd.PersistentFlags().StringP(flagFile, "f", "", "chains.yaml config file path (searches for chains.yaml in current directory by default)")
+	renderCmd.PersistentFlags().String(flagOutput, "", "Directory to render into (default render/<chain>-<tag>)")
+
+	// Chain config options
+	renderCmd.PersistentFlags().StringVarP(&chainConfig.chain, flagChain, "c", "", "Cosmos chain to render from chains.yaml")
+	renderCmd.PersistentFlags().StringVarP(&chainConfig.ref, flagGitRef, "g", "", "Git ref to render (branch, tag or full commit SHA)")
+	renderCmd.PersistentFlags().StringVarP(&chainConfig.tag, flagTag, "t", "", "Resulting docker image tag. If not provided, will derive from ref.")
+	renderCmd.PersistentFlags().BoolVarP(&chainConfig.latest, flagLatest, "l", false, "Also tag latest")
+	renderCmd.PersistentFlags().BoolVar(&chainConfig.local, flagLocal, false, "Use local directory (not git repository)")
+	renderCmd.PersistentFlags().BoolVar(&chainConfig.race, flagRace, false, "Enable race detector (go builds only)")
+
+	// Chain config override flags (overwrites chains.yaml params)
+	addChainConfigOverrideFlags(renderCmd, &chainConfig)
+
+	// Credentials for private repositories
+	addSecretFlags(renderCmd, &buildConfig.Secrets)
+
+	// Docker specific flags
+	renderCmd.PersistentFlags().StringVarP(&buildConfig.ContainerRegistry, flagRegistry, "r", "", "Docker Container Registry for tagging and pushing images")
+	renderCmd.PersistentFlags().BoolVarP(&buildConfig.SkipPush, flagSkip, "s", false, "Skip pushing images to registry")
+	renderCmd.PersistentFlags().BoolVarP(&buildConfig.UseBuildKit, flagUseBuildkit, "b", false, "Render a buildkit (docker buildx) build for multi-arch images, same as --builder buildkit")
+	renderCmd.PersistentFlags().String(flagBuilder, "", "Build backend to render the build for: "+strings.Join(docker.BuilderNames, ", ")+". Defaults to docker")
+	renderCmd.PersistentFlags().StringVarP(&buildConfig.Platform, flagPlatform, "p", docker.DefaultPlatforms, "Platforms to build (only applies to buildkit builds)")
+	renderCmd.PersistentFlags().BoolVar(&buildConfig.NoBuildCache, flagNoBuildCache, false, "Invalidate caches for clone and build.")
+	addBuildCacheFlags(renderCmd, &buildConfig)
+	renderCmd.PersistentFlags().StringVar(&buildConfig.GoVersion, flagGoVersion, "", "Go version override to use for building (go builds only)")
+	renderCmd.PersistentFlags().StringVar(&buildConfig.AlpineVersion, flagAlpineVersion, "", "Alpine version override to use for building (go builds only)")
+	renderCmd.PersistentFlags().StringVar(&buildConfig.RustToolchain, flagRustToolchain, "", "Rust toolchain override to use for building (cargo builds only)")
+	renderCmd.PersistentFlags().String(flagGoVersionsFile, "", "Go version catalog file (defaults to the embedded catalog merged with the catalog cached by go-versions update)")
+
+	return renderCmd
+}
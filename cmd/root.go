@@ -6,13 +6,13 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/strangelove-ventures/heighliner/builder"
-	"gopkg.in/yaml.v2"
 )
 
 var chains []builder.ChainNodeConfig
 
 func Execute(chainsYaml []byte) {
-	err := yaml.Unmarshal(chainsYaml, &chains)
+	var err error
+	chains, err = builder.LoadChainNodeConfigs(builder.ChainsFile{Path: "chains.yaml", Content: chainsYaml})
 	if err != nil {
 		panic(fmt.Errorf("error parsing chains.yaml: %v", err))
 	}
@@ -28,6 +28,11 @@ of the configured Cosmos blockchains in chains.yaml`,
 
 	rootCmd.AddCommand(BuildCmd())
 	rootCmd.AddCommand(ListCmd())
+	rootCmd.AddCommand(DepsCmd())
+	rootCmd.AddCommand(AuditCmd())
+	rootCmd.AddCommand(ValidateCmd())
+	rootCmd.AddCommand(RenderCmd())
+	rootCmd.AddCommand(GoVersionsCmd())
 
 	err = rootCmd.Execute()
 	if err != nil {
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/strangelove-ventures/heighliner/builder"
+)
+
+const flagSchema = "schema"
+
+func ValidateCmd() *cobra.Command {
+	var validateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Validate chain configs",
+		Long: `Strictly validate chain configs, rejecting unknown keys and invalid values.
+Validates the chains/ directory in the current directory by default, or the embedded chains if it does not exist.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdFlags := cmd.Flags()
+
+			if schema, _ := cmdFlags.GetBool(flagSchema); schema {
+				bz, err := builder.ChainsJSONSchema()
+				if err != nil {
+					panic(err)
+				}
+				fmt.Print(string(bz))
+				return
+			}
+
+			configFile, _ := cmdFlags.GetString(flagFile)
+			if err := loadChains(configFile); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("%d chain configs are valid\n", len(chains))
+		},
+	}
+
+	validateCmd.PersistentFlags().StringP(flagFile, "f", "", "chains.yaml config file path (searches for chains.yaml in current directory by default)")
+	validateCmd.PersistentFlags().Bool(flagSchema, false, "Print the JSON Schema for chain configs instead of validating")
+
+	return validateCmd
+}
@@ -0,0 +1,285 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Build backend names.
+const (
+	BuilderDocker   = "docker"
+	BuilderBuildKit = "buildkit"
+	BuilderPodman   = "podman"
+)
+
+// BuilderNames are the names of the build backends which can be created with NewBuilder.
+var BuilderNames = []string{BuilderDocker, BuilderBuildKit, BuilderPodman}
+
+// Capabilities are the features supported by a build backend.
+type Capabilities struct {
+	// BuildKit is set if the backend builds with the buildkit dockerfile frontend,
+	// which supports RUN --mount for secrets, ssh forwarding and caches.
+	BuildKit bool
+	// MultiPlatform is set if the backend builds for requested platforms, including several at once.
+	// Other backends build for the platform of their host.
+	MultiPlatform bool
+	// TarExport is set if the backend can export the image as a docker tarball instead of loading or pushing it.
+	TarExport bool
+	// Cache is set if the backend can import and export its build cache, e.g. from and to a registry.
+	Cache bool
+}
+
+// BuildRequest is a docker image build. The build context is the current working directory.
+type BuildRequest struct {
+	Dockerfile string // path of the Dockerfile, relative to the build context
+	Tags       []string
+	Push       bool
+	TarExport  string // file path to export the image to, if set
+	BuildArgs  map[string]string
+	Platforms  []string // empty for the platform of the backend host
+	NoCache    bool
+
+	// SSH are the paths of private key files, or of a single ssh-agent socket, and Secrets the
+	// secrets available to RUN --mount instructions. Only supported by buildkit backends.
+	SSH     []string
+	Secrets map[string][]byte
+
+	// CacheFrom are the caches to import and CacheTo the caches to export the build cache to.
+	CacheFrom []CacheOptions
+	CacheTo   []CacheOptions
+
+	// Progress receives the build and push output of docker and podman builds, os.Stdout if nil.
+	// Buildkit builds write their progress to os.Stderr, as it is rendered for a console.
+	Progress io.Writer
+}
+
+// BuiltImage is an image built by a Builder. Digests are only available for pushed images.
+type BuiltImage struct {
+	// Digest is the digest of the image, that of the manifest list for multi-platform images.
+	Digest string
+	// PlatformDigests are the manifest digests of the image by platform, e.g. linux/amd64.
+	PlatformDigests map[string]string
+}
+
+// Builder is a backend which builds docker images.
+type Builder interface {
+	// Name returns the name of the backend, e.g. docker.
+	Name() string
+	// Capabilities returns the features supported by the backend.
+	Capabilities() Capabilities
+	// Build builds the image, pushing all tags if requested.
+	Build(ctx context.Context, req BuildRequest) (BuiltImage, error)
+}
+
+// NewBuilder returns the build backend named name, one of BuilderNames. The address is that of the buildkit
+// daemon for buildkit, or of the docker or podman API socket, defaults are used if empty.
+func NewBuilder(name string, address string) (Builder, error) {
+	switch name {
+	case BuilderDocker:
+		return NewDockerBuilder(address), nil
+	case BuilderBuildKit:
+		return NewBuildKitBuilder(address), nil
+	case BuilderPodman:
+		return NewPodmanBuilder(address), nil
+	default:
+		return nil, fmt.Errorf("unknown builder %q, must be one of: %s", name, strings.Join(BuilderNames, ", "))
+	}
+}
+
+// DockerBuilder builds images with the API of a docker daemon, or of a daemon compatible with it.
+type DockerBuilder struct {
+	name string
+	host string // from the environment, e.g. DOCKER_HOST, if empty
+}
+
+// NewDockerBuilder returns a builder for the docker daemon at host, or the one configured by the
+// environment, e.g. DOCKER_HOST, if host is empty.
+func NewDockerBuilder(host string) *DockerBuilder {
+	return &DockerBuilder{name: BuilderDocker, host: host}
+}
+
+// NewPodmanBuilder returns a builder for the docker compatible API socket of podman at host. If host is empty,
+// CONTAINER_HOST is used if set, otherwise the podman socket of the current user.
+func NewPodmanBuilder(host string) *DockerBuilder {
+	if host == "" {
+		host = os.Getenv("CONTAINER_HOST")
+	}
+	if host == "" {
+		host = defaultPodmanSocket()
+	}
+	return &DockerBuilder{name: BuilderPodman, host: host}
+}
+
+// defaultPodmanSocket returns the podman socket of the current user, the system socket for root.
+func defaultPodmanSocket() string {
+	uid := os.Getuid()
+	if uid == 0 {
+		return "unix:///run/podman/podman.sock"
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return "unix://" + filepath.Join(runtimeDir, "podman", "podman.sock")
+	}
+	return fmt.Sprintf("unix:///run/user/%d/podman/podman.sock", uid)
+}
+
+func (b *DockerBuilder) Name() string {
+	return b.name
+}
+
+func (b *DockerBuilder) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+func (b *DockerBuilder) Build(ctx context.Context, req BuildRequest) (BuiltImage, error) {
+	if err := CheckCapabilities(b, req); err != nil {
+		return BuiltImage{}, err
+	}
+	progress := req.Progress
+	if progress == nil {
+		progress = os.Stdout
+	}
+	digest, err := buildDockerImage(ctx, progress, b.host, req.Dockerfile, req.Tags, req.Push, req.BuildArgs, req.NoCache)
+	if err != nil {
+		return BuiltImage{}, err
+	}
+	return pushedImage(ctx, req, digest), nil
+}
+
+// BuildKitBuilder builds images with a buildkit daemon.
+type BuildKitBuilder struct {
+	options BuildKitOptions
+}
+
+// NewBuildKitBuilder returns a builder for the buildkit daemon at address, BuildKitSock if empty.
+func NewBuildKitBuilder(address string) *BuildKitBuilder {
+	options := GetDefaultBuildKitOptions()
+	if address != "" {
+		options.Address = address
+	}
+	return &BuildKitBuilder{options: options}
+}
+
+func (b *BuildKitBuilder) Name() string {
+	return BuilderBuildKit
+}
+
+func (b *BuildKitBuilder) Capabilities() Capabilities {
+	return Capabilities{BuildKit: true, MultiPlatform: true, TarExport: true, Cache: true}
+}
+
+func (b *BuildKitBuilder) Build(ctx context.Context, req BuildRequest) (BuiltImage, error) {
+	options := b.options
+	if len(req.Platforms) > 0 {
+		options.Platform = strings.Join(req.Platforms, ",")
+	}
+	options.NoCache = req.NoCache
+	options.SSH = req.SSH
+	options.Secrets = req.Secrets
+	options.CacheImports = req.CacheFrom
+	options.CacheExports = req.CacheTo
+	digest, err := BuildDockerImageWithBuildKit(ctx, filepath.Dir(req.Dockerfile), req.Tags, req.Push, req.TarExport, req.BuildArgs, options)
+	if err != nil {
+		return BuiltImage{}, err
+	}
+	return pushedImage(ctx, req, digest), nil
+}
+
+// pushedImage returns the built image with the digest returned by the build and, if pushed, the platform
+// digests looked up in the registry. The build succeeded, so lookup failures only omit the platform digests.
+func pushedImage(ctx context.Context, req BuildRequest, digest string) BuiltImage {
+	image := BuiltImage{Digest: digest}
+	if !req.Push || len(req.Tags) == 0 {
+		return image
+	}
+	name, tag := splitImageTag(req.Tags[0])
+	platformDigests, _, err := NewRegistryClient().PlatformDigests(ctx, name, tag)
+	if err != nil {
+		logrus.Warnf("Unable to look up the platform digests of %s: %v", req.Tags[0], err)
+		return image
+	}
+	image.PlatformDigests = platformDigests
+	return image
+}
+
+// splitImageTag splits an image tag, e.g. ghcr.io/strangelove-ventures/heighliner/gaia:v15.0.0,
+// into the image name and tag, which is latest if not set.
+func splitImageTag(imageTag string) (image string, tag string) {
+	i := strings.LastIndex(imageTag, ":")
+	if i < 0 || strings.Contains(imageTag[i:], "/") {
+		return imageTag, "latest"
+	}
+	return imageTag[:i], imageTag[i+1:]
+}
+
+// CheckCapabilities returns an error if req needs a feature the builder b does not support,
+// so that builds can fail before anything is built.
+func CheckCapabilities(b Builder, req BuildRequest) error {
+	caps := b.Capabilities()
+	switch {
+	case req.TarExport != "" && !caps.TarExport:
+		return fmt.Errorf("the %s builder can not export images as tarballs", b.Name())
+	case len(req.Platforms) > 0 && !caps.MultiPlatform:
+		return fmt.Errorf("the %s builder can not build for platforms %s, only for its host platform", b.Name(), strings.Join(req.Platforms, ","))
+	case (len(req.SSH) > 0 || len(req.Secrets) > 0) && !caps.BuildKit:
+		return fmt.Errorf("the %s builder does not support secret and ssh mounts", b.Name())
+	case (len(req.CacheFrom) > 0 || len(req.CacheTo) > 0) && !caps.Cache:
+		return fmt.Errorf("the %s builder can not import or export build caches", b.Name())
+	}
+	return nil
+}
+
+// FakeBuild is a build recorded by a FakeBuilder.
+type FakeBuild struct {
+	BuildRequest
+	DockerfileContents []byte // read at build time, as the Dockerfile is usually temporary
+}
+
+// FakeBuilder is a build backend which records builds instead of building, for tests without a daemon.
+type FakeBuilder struct {
+	Caps            Capabilities
+	Digest          string            // returned by successful builds
+	PlatformDigests map[string]string // returned by successful builds
+	Err             error             // returned by all builds if set
+
+	mu     sync.Mutex
+	builds []FakeBuild
+}
+
+func (b *FakeBuilder) Name() string {
+	return "fake"
+}
+
+func (b *FakeBuilder) Capabilities() Capabilities {
+	return b.Caps
+}
+
+func (b *FakeBuilder) Build(ctx context.Context, req BuildRequest) (BuiltImage, error) {
+	if err := CheckCapabilities(b, req); err != nil {
+		return BuiltImage{}, err
+	}
+	dockerfile, err := os.ReadFile(req.Dockerfile)
+	if err != nil {
+		return BuiltImage{}, err
+	}
+	b.mu.Lock()
+	b.builds = append(b.builds, FakeBuild{BuildRequest: req, DockerfileContents: dockerfile})
+	b.mu.Unlock()
+	if b.Err != nil {
+		return BuiltImage{}, b.Err
+	}
+	return BuiltImage{Digest: b.Digest, PlatformDigests: b.PlatformDigests}, ctx.Err()
+}
+
+// Builds returns the recorded builds, in the order they were started.
+func (b *FakeBuilder) Builds() []FakeBuild {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]FakeBuild(nil), b.builds...)
+}
@@ -0,0 +1,45 @@
+package docker_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/docker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerBuilderProgress(t *testing.T) {
+	// docker daemon stand-in, streaming build and push output.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Header().Set("Api-Version", "1.41")
+		case strings.HasSuffix(r.URL.Path, "/build"):
+			fmt.Fprintln(w, `{"stream":"Step 1/1 : FROM scratch\n"}`)
+			fmt.Fprintln(w, `{"aux":{"ID":"sha256:image"}}`)
+		case strings.HasSuffix(r.URL.Path, "/push"):
+			fmt.Fprintln(w, `{"status":"Pushed"}`)
+			fmt.Fprintln(w, `{"aux":{"Tag":"v1.0.0","Digest":"sha256:pushed"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	var progress bytes.Buffer
+	image, err := docker.NewDockerBuilder("tcp://"+srv.Listener.Addr().String()).Build(context.Background(), docker.BuildRequest{
+		Dockerfile: "Dockerfile",
+		// unreachable registry, so that the platform digests lookup fails fast.
+		Tags:     []string{"127.0.0.1:1/gaia:v1.0.0"},
+		Push:     true,
+		Progress: &progress,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "sha256:pushed", image.Digest)
+	require.Equal(t, "Step 1/1 : FROM scratch\nImage ID: sha256:image\nPushed\n", progress.String())
+}
@@ -5,14 +5,19 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/docker/cli/cli/config"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
 	"github.com/moby/buildkit/session"
 	"github.com/moby/buildkit/session/auth/authprovider"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"github.com/moby/buildkit/session/sshforward/sshprovider"
 	"github.com/moby/buildkit/util/entitlements"
 	"github.com/moby/buildkit/util/progress/progresswriter"
 	"github.com/sirupsen/logrus"
@@ -29,6 +34,17 @@ type BuildKitOptions struct {
 
 	// Set type of progress (auto, plain, tty). Use plain to show container output
 	LogBuildProgress string
+
+	// SSH are the paths of private key files, or of a single ssh-agent socket,
+	// available to RUN --mount=type=ssh instructions.
+	SSH []string
+
+	// Secrets are available to RUN --mount=type=secret,id=<name> instructions.
+	Secrets map[string][]byte
+
+	// CacheImports are the caches to import, and CacheExports the caches to export the build cache to.
+	CacheImports []CacheOptions
+	CacheExports []CacheOptions
 }
 
 func GetDefaultBuildKitOptions() BuildKitOptions {
@@ -52,6 +68,8 @@ func (wc *WriteCloser) Close() error {
 	return wc.f.Close()
 }
 
+// BuildDockerImageWithBuildKit builds the docker image with buildkit, pushing all tags if push is true.
+// Returns the digest of the built image.
 func BuildDockerImageWithBuildKit(
 	ctx context.Context,
 	dockerfileDir string,
@@ -60,14 +78,25 @@ func BuildDockerImageWithBuildKit(
 	tarExport string,
 	args map[string]string,
 	buildKitOptions BuildKitOptions,
-) error {
+) (string, error) {
 	c, err := client.New(ctx, buildKitOptions.Address)
 	if err != nil {
-		return fmt.Errorf("error getting buildkit client: %v", err)
+		return "", fmt.Errorf("error getting buildkit client: %v", err)
 	}
+	defer c.Close()
 
 	dockerConfig := config.LoadDefaultConfigFile(os.Stderr)
 	attachable := []session.Attachable{authprovider.NewDockerAuthProvider(dockerConfig)}
+	if len(buildKitOptions.SSH) > 0 {
+		sshProvider, err := sshprovider.NewSSHAgentProvider([]sshprovider.AgentConfig{{Paths: buildKitOptions.SSH}})
+		if err != nil {
+			return "", fmt.Errorf("error setting up ssh forwarding: %w", err)
+		}
+		attachable = append(attachable, sshProvider)
+	}
+	if len(buildKitOptions.Secrets) > 0 {
+		attachable = append(attachable, secretsprovider.FromMap(buildKitOptions.Secrets))
+	}
 
 	eg, ctx := errgroup.WithContext(ctx)
 
@@ -79,7 +108,7 @@ func BuildDockerImageWithBuildKit(
 
 	if tarExport != "" {
 		if len(strings.Split(buildKitOptions.Platform, ",")) > 1 {
-			return fmt.Errorf("when using tar-export-path, only one platform is supported")
+			return "", fmt.Errorf("when using tar-export-path, only one platform is supported")
 		}
 
 		exports[0] = client.ExportEntry{
@@ -122,8 +151,8 @@ func BuildDockerImageWithBuildKit(
 	solveOpt := client.SolveOpt{
 		Exports:             exports,
 		Frontend:            "dockerfile.v0",
-		CacheExports:        []client.CacheOptionsEntry{},
-		CacheImports:        []client.CacheOptionsEntry{},
+		CacheExports:        cacheOptionsEntries(buildKitOptions.CacheExports),
+		CacheImports:        cacheOptionsEntries(buildKitOptions.CacheImports),
 		Session:             attachable,
 		FrontendAttrs:       opts,
 		LocalDirs:           locals,
@@ -139,7 +168,7 @@ func BuildDockerImageWithBuildKit(
 	// not using shared context to not disrupt display but let is finish reporting errors
 	pw, err := progresswriter.NewPrinter(ctx, os.Stderr, buildKitOptions.LogBuildProgress)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	mw := progresswriter.NewMultiWriter(pw)
@@ -157,6 +186,7 @@ func BuildDockerImageWithBuildKit(
 		}
 	}
 
+	var digest string
 	eg.Go(func() error {
 		defer func() {
 			for _, w := range writers {
@@ -170,6 +200,7 @@ func BuildDockerImageWithBuildKit(
 		for k, v := range resp.ExporterResponse {
 			logrus.Debugf("exporter response: %s=%s", k, v)
 		}
+		digest = resp.ExporterResponse[exptypes.ExporterImageDigestKey]
 
 		return nil
 	})
@@ -179,5 +210,61 @@ func BuildDockerImageWithBuildKit(
 		return pw.Err()
 	})
 
-	return eg.Wait()
+	if err := eg.Wait(); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// CacheOptions is a buildkit cache import or export, e.g. type=registry,ref=ghcr.io/org/image:buildcache.
+type CacheOptions struct {
+	Type  string
+	Attrs map[string]string
+}
+
+// ParseCacheOptions parses cache options in the form of docker buildx --cache-from and --cache-to,
+// e.g. type=local,dest=path,mode=max. A value without type is the ref of a registry cache.
+func ParseCacheOptions(s string) (CacheOptions, error) {
+	if !strings.Contains(s, "=") {
+		if s == "" {
+			return CacheOptions{}, fmt.Errorf("empty cache options")
+		}
+		return CacheOptions{Type: "registry", Attrs: map[string]string{"ref": s}}, nil
+	}
+	opts := CacheOptions{Attrs: make(map[string]string)}
+	for _, field := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return CacheOptions{}, fmt.Errorf("invalid cache option %q in %q, must be key=value", field, s)
+		}
+		if key == "type" {
+			opts.Type = value
+			continue
+		}
+		opts.Attrs[key] = value
+	}
+	if opts.Type == "" {
+		return CacheOptions{}, fmt.Errorf("cache options %q have no type", s)
+	}
+	return opts, nil
+}
+
+// String returns the options in the form of docker buildx --cache-from and --cache-to.
+func (c CacheOptions) String() string {
+	fields := []string{"type=" + c.Type}
+	for _, key := range slices.Sorted(maps.Keys(c.Attrs)) {
+		fields = append(fields, key+"="+c.Attrs[key])
+	}
+	return strings.Join(fields, ",")
+}
+
+func cacheOptionsEntries(opts []CacheOptions) []client.CacheOptionsEntry {
+	entries := make([]client.CacheOptionsEntry, len(opts))
+	for i, o := range opts {
+		entries[i] = client.CacheOptionsEntry{Type: o.Type, Attrs: maps.Clone(o.Attrs)}
+		if entries[i].Attrs == nil {
+			entries[i].Attrs = map[string]string{}
+		}
+	}
+	return entries
 }
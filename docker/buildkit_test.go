@@ -0,0 +1,25 @@
+package docker_test
+
+import (
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/docker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCacheOptions(t *testing.T) {
+	opts, err := docker.ParseCacheOptions("type=local,dest=/tmp/cache,mode=max")
+	require.NoError(t, err)
+	require.Equal(t, docker.CacheOptions{Type: "local", Attrs: map[string]string{"dest": "/tmp/cache", "mode": "max"}}, opts)
+	require.Equal(t, "type=local,dest=/tmp/cache,mode=max", opts.String())
+
+	// a ref without type is a registry cache.
+	opts, err = docker.ParseCacheOptions("ghcr.io/org/gaia:buildcache")
+	require.NoError(t, err)
+	require.Equal(t, "type=registry,ref=ghcr.io/org/gaia:buildcache", opts.String())
+
+	_, err = docker.ParseCacheOptions("ref=ghcr.io/org/gaia:buildcache")
+	require.ErrorContains(t, err, "have no type")
+	_, err = docker.ParseCacheOptions("type=local,dest")
+	require.ErrorContains(t, err, "must be key=value")
+}
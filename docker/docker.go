@@ -7,7 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"strings"
+	"os"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/image"
@@ -30,11 +30,35 @@ type DockerImageBuildLog struct {
 	ErrorDetail *DockerImageBuildErrorDetail `json:"errorDetail"`
 }
 
-func BuildDockerImage(ctx context.Context, dockerfile string, tags []string, push bool, args map[string]string, noCache bool) error {
-	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+type DockerImagePushLogAux struct {
+	Tag    string `json:"Tag"`
+	Digest string `json:"Digest"`
+}
+
+type DockerImagePushLog struct {
+	Status string                 `json:"status"`
+	Aux    *DockerImagePushLogAux `json:"aux"`
+	Error  string                 `json:"error"`
+}
+
+// BuildDockerImage builds the docker image with the docker daemon, pushing all tags if push is true.
+// Returns the digest of the pushed image, or an empty digest if not pushed.
+func BuildDockerImage(ctx context.Context, dockerfile string, tags []string, push bool, args map[string]string, noCache bool) (string, error) {
+	return buildDockerImage(ctx, os.Stdout, "", dockerfile, tags, push, args, noCache)
+}
+
+// buildDockerImage builds the docker image with the daemon at host, or the one configured by the environment if empty,
+// writing the build and push output to progress.
+func buildDockerImage(ctx context.Context, progress io.Writer, host string, dockerfile string, tags []string, push bool, args map[string]string, noCache bool) (string, error) {
+	clientOpts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if host != "" {
+		clientOpts = append(clientOpts, client.WithHost(host))
+	}
+	dockerClient, err := client.NewClientWithOpts(clientOpts...)
 	if err != nil {
-		return err
+		return "", err
 	}
+	defer dockerClient.Close()
 
 	buildArgs := map[string]*string{}
 
@@ -54,13 +78,14 @@ func BuildDockerImage(ctx context.Context, dockerfile string, tags []string, pus
 
 	tar, err := archive.TarWithOptions("./", &archive.TarOptions{})
 	if err != nil {
-		panic(fmt.Errorf("error archiving project for docker: %v", err))
+		return "", fmt.Errorf("error archiving project for docker: %w", err)
 	}
 
 	res, err := dockerClient.ImageBuild(ctx, tar, opts)
 	if err != nil {
-		return err
+		return "", err
 	}
+	defer res.Body.Close()
 
 	scanner := bufio.NewScanner(res.Body)
 
@@ -69,48 +94,68 @@ func BuildDockerImage(ctx context.Context, dockerfile string, tags []string, pus
 		logLineText := scanner.Text()
 		err = json.Unmarshal([]byte(logLineText), dockerLogLine)
 		if err != nil {
-			return err
+			return "", err
 		}
 		if dockerLogLine.Stream != "" {
-			fmt.Printf("%s", dockerLogLine.Stream)
+			fmt.Fprint(progress, dockerLogLine.Stream)
 		}
 		if dockerLogLine.Aux != nil {
-			fmt.Printf("Image ID: %s\n", dockerLogLine.Aux.ID)
+			fmt.Fprintf(progress, "Image ID: %s\n", dockerLogLine.Aux.ID)
 		}
 		if dockerLogLine.Error != "" {
-			return errors.New(dockerLogLine.Error)
+			return "", errors.New(dockerLogLine.Error)
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return err
+		return "", err
 	}
 
 	// Only continue to push images if registry is provided
 	if !push {
-		return nil
+		return "", nil
 	}
 
 	// push all image tags to container registry using provided auth
+	var digest string
 	for _, imageTag := range tags {
-		rd, err := dockerClient.ImagePush(ctx, imageTag, image.PushOptions{
-			All: true,
-		})
+		tagDigest, err := pushDockerImage(ctx, progress, dockerClient, imageTag)
 		if err != nil {
-			return err
+			return "", err
 		}
+		digest = tagDigest
+	}
 
-		defer rd.Close()
+	return digest, nil
+}
 
-		buf := new(strings.Builder)
-		_, err = io.Copy(buf, rd)
+// pushDockerImage pushes an image tag, returning the digest of the pushed image.
+func pushDockerImage(ctx context.Context, progress io.Writer, dockerClient *client.Client, imageTag string) (string, error) {
+	rd, err := dockerClient.ImagePush(ctx, imageTag, image.PushOptions{
+		All: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer rd.Close()
 
-		if err != nil {
-			return err
+	var digest string
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		pushLogLine := &DockerImagePushLog{}
+		if err := json.Unmarshal(scanner.Bytes(), pushLogLine); err != nil {
+			return "", err
+		}
+		if pushLogLine.Status != "" {
+			fmt.Fprintln(progress, pushLogLine.Status)
+		}
+		if pushLogLine.Aux != nil && pushLogLine.Aux.Digest != "" {
+			digest = pushLogLine.Aux.Digest
+		}
+		if pushLogLine.Error != "" {
+			return "", errors.New(pushLogLine.Error)
 		}
-
-		fmt.Println(buf.String())
 	}
 
-	return nil
+	return digest, scanner.Err()
 }
@@ -0,0 +1,387 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/cli/cli/config"
+)
+
+const (
+	dockerHubConfigfileKey = "https://index.docker.io/v1/"
+	dockerHubRegistryHost  = "registry-1.docker.io"
+
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// RegistryCredentials are used to authenticate with a container registry.
+type RegistryCredentials struct {
+	Username string
+	Password string
+
+	// IdentityToken is exchanged for a registry access token in place of username and password.
+	IdentityToken string
+}
+
+// RegistryClient looks up images in container registries with the OCI distribution API.
+type RegistryClient struct {
+	HTTPClient *http.Client
+
+	// Credentials returns the credentials for a registry host.
+	Credentials func(host string) (RegistryCredentials, error)
+}
+
+// NewRegistryClient returns a RegistryClient using the credentials from the docker config file,
+// the same credentials that are shared with buildkit for pushing images.
+func NewRegistryClient() *RegistryClient {
+	dockerConfig := config.LoadDefaultConfigFile(os.Stderr)
+	return &RegistryClient{
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Credentials: func(host string) (RegistryCredentials, error) {
+			if host == dockerHubRegistryHost {
+				host = dockerHubConfigfileKey
+			}
+			ac, err := dockerConfig.GetAuthConfig(host)
+			if err != nil {
+				return RegistryCredentials{}, err
+			}
+			return RegistryCredentials{
+				Username:      ac.Username,
+				Password:      ac.Password,
+				IdentityToken: ac.IdentityToken,
+			}, nil
+		},
+	}
+}
+
+// manifest is the subset of OCI/docker image manifests and indexes needed to determine platforms.
+type manifest struct {
+	MediaType string `json:"mediaType"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform *struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+}
+
+// imageConfig is the subset of an image config blob needed to determine its platform.
+type imageConfig struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant"`
+}
+
+func platformString(os, arch, variant string) string {
+	if variant != "" {
+		return fmt.Sprintf("%s/%s/%s", os, arch, variant)
+	}
+	return fmt.Sprintf("%s/%s", os, arch)
+}
+
+// splitImageName splits an image name, e.g. ghcr.io/strangelove-ventures/heighliner/gaia,
+// into the registry host and repository. Images without a registry host are on docker hub.
+func splitImageName(image string) (host string, repository string) {
+	first, rest, found := strings.Cut(image, "/")
+	if !found || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		if !found {
+			return dockerHubRegistryHost, "library/" + image
+		}
+		return dockerHubRegistryHost, image
+	}
+	if first == "docker.io" || first == "index.docker.io" {
+		if !strings.Contains(rest, "/") {
+			rest = "library/" + rest
+		}
+		return dockerHubRegistryHost, rest
+	}
+	return first, rest
+}
+
+// ImagePlatforms returns the platforms, e.g. linux/amd64, of the image tag in its registry.
+// If the tag does not exist, exists is false.
+func (c *RegistryClient) ImagePlatforms(ctx context.Context, image string, tag string) (platforms []string, exists bool, err error) {
+	manifests, exists, err := c.platformManifests(ctx, image, tag)
+	for _, m := range manifests {
+		platforms = append(platforms, m.platform)
+	}
+	return platforms, exists, err
+}
+
+// PlatformDigests returns the manifest digests of the image tag in its registry by platform, e.g. linux/amd64.
+// For multi-platform images these are the manifests listed by the index, otherwise the single manifest of the tag.
+// If the tag does not exist, exists is false.
+func (c *RegistryClient) PlatformDigests(ctx context.Context, image string, tag string) (digests map[string]string, exists bool, err error) {
+	manifests, exists, err := c.platformManifests(ctx, image, tag)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+	digests = make(map[string]string, len(manifests))
+	for _, m := range manifests {
+		digests[m.platform] = m.digest
+	}
+	return digests, true, nil
+}
+
+// platformManifest is the manifest of an image for a single platform.
+type platformManifest struct {
+	platform string
+	digest   string
+}
+
+// platformManifests returns the platform manifests of the image tag, in the order listed by its index.
+func (c *RegistryClient) platformManifests(ctx context.Context, image string, tag string) (manifests []platformManifest, exists bool, err error) {
+	host, repository := splitImageName(image)
+	session := &registrySession{client: c, host: host, repository: repository}
+
+	bz, found, err := session.get(ctx, fmt.Sprintf("/v2/%s/manifests/%s", repository, tag),
+		mediaTypeOCIIndex, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeDockerManifest)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(bz, &m); err != nil {
+		return nil, false, fmt.Errorf("error parsing manifest for %s:%s: %w", image, tag, err)
+	}
+
+	// multi-platform image
+	if len(m.Manifests) > 0 {
+		for _, desc := range m.Manifests {
+			// attestation manifests have an unknown platform
+			if desc.Platform == nil || desc.Platform.OS == "unknown" {
+				continue
+			}
+			manifests = append(manifests, platformManifest{
+				platform: platformString(desc.Platform.OS, desc.Platform.Architecture, desc.Platform.Variant),
+				digest:   desc.Digest,
+			})
+		}
+		return manifests, true, nil
+	}
+
+	// single platform image, the platform is in the image config
+	if m.Config.Digest == "" {
+		return nil, true, nil
+	}
+	// the digest of a manifest is that of its content, as served by the registry.
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(bz))
+	bz, found, err = session.get(ctx, fmt.Sprintf("/v2/%s/blobs/%s", repository, m.Config.Digest))
+	if err != nil {
+		return nil, true, err
+	}
+	if !found {
+		return nil, true, fmt.Errorf("image config %s not found for %s:%s", m.Config.Digest, image, tag)
+	}
+	var cfg imageConfig
+	if err := json.Unmarshal(bz, &cfg); err != nil {
+		return nil, true, fmt.Errorf("error parsing image config for %s:%s: %w", image, tag, err)
+	}
+	return []platformManifest{{platform: platformString(cfg.OS, cfg.Architecture, cfg.Variant), digest: digest}}, true, nil
+}
+
+// MissingPlatforms returns the platforms which are not available for the image tag in its registry.
+// If the tag does not exist, all platforms are missing. If platforms is empty,
+// only the existence of the tag is checked and nil is returned if it exists.
+func (c *RegistryClient) MissingPlatforms(ctx context.Context, image string, tag string, platforms []string) (missing []string, exists bool, err error) {
+	available, exists, err := c.ImagePlatforms(ctx, image, tag)
+	if err != nil || !exists {
+		return platforms, exists, err
+	}
+	for _, p := range platforms {
+		found := false
+		for _, a := range available {
+			if a == p || strings.HasPrefix(a, p+"/") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, p)
+		}
+	}
+	return missing, true, nil
+}
+
+// registrySession performs requests against a single repository, authenticating as requested by the registry.
+type registrySession struct {
+	client     *RegistryClient
+	host       string
+	repository string
+
+	authorization string
+}
+
+// get performs a GET request for path, returning found false for 404 responses.
+func (s *registrySession) get(ctx context.Context, path string, accept ...string) (body []byte, found bool, err error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+s.host+path, http.NoBody)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		if s.authorization != "" {
+			req.Header.Set("Authorization", s.authorization)
+		}
+
+		res, err := s.client.HTTPClient.Do(req)
+		if err != nil {
+			return nil, false, fmt.Errorf("error requesting %s%s: %w", s.host, path, err)
+		}
+		body, err = io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("error reading response from %s%s: %w", s.host, path, err)
+		}
+
+		switch {
+		case res.StatusCode == http.StatusOK:
+			return body, true, nil
+		case res.StatusCode == http.StatusNotFound:
+			return nil, false, nil
+		case res.StatusCode == http.StatusUnauthorized && attempt == 0:
+			if err := s.authorize(ctx, res.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, false, err
+			}
+		default:
+			return nil, false, fmt.Errorf("unexpected status from %s%s: %s", s.host, path, res.Status)
+		}
+	}
+}
+
+// authorize sets the authorization for subsequent requests from a WWW-Authenticate challenge.
+func (s *registrySession) authorize(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+
+	creds, err := s.client.Credentials(s.host)
+	if err != nil {
+		return fmt.Errorf("error getting credentials for %s: %w", s.host, err)
+	}
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds.Username == "" {
+			return fmt.Errorf("registry %s requires authentication, no credentials found in docker config", s.host)
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.SetBasicAuth(creds.Username, creds.Password)
+		s.authorization = req.Header.Get("Authorization")
+		return nil
+	case "bearer":
+		token, err := s.fetchToken(ctx, params, creds)
+		if err != nil {
+			return err
+		}
+		s.authorization = "Bearer " + token
+		return nil
+	default:
+		return fmt.Errorf("unsupported authentication challenge from %s: %q", s.host, challenge)
+	}
+}
+
+// fetchToken fetches a bearer token from the registry's token server.
+func (s *registrySession) fetchToken(ctx context.Context, params map[string]string, creds RegistryCredentials) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("missing realm in authentication challenge from %s", s.host)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", s.repository)
+	}
+
+	var req *http.Request
+	var err error
+	if creds.IdentityToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {creds.IdentityToken},
+			"service":       {params["service"]},
+			"scope":         {scope},
+			"client_id":     {"heighliner"},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		u, err := url.Parse(realm)
+		if err != nil {
+			return "", fmt.Errorf("invalid realm in authentication challenge from %s: %w", s.host, err)
+		}
+		q := u.Query()
+		if service := params["service"]; service != "" {
+			q.Set("service", service)
+		}
+		q.Set("scope", scope)
+		u.RawQuery = q.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
+		if err != nil {
+			return "", err
+		}
+		if creds.Username != "" {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+
+	res, err := s.client.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching token for %s: %w", s.host, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching token for %s: %s", s.host, res.Status)
+	}
+
+	var tokenRes struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokenRes); err != nil {
+		return "", fmt.Errorf("error parsing token response from %s: %w", s.host, err)
+	}
+	if tokenRes.Token != "" {
+		return tokenRes.Token, nil
+	}
+	if tokenRes.AccessToken != "" {
+		return tokenRes.AccessToken, nil
+	}
+	return "", errors.New("empty token in token response from " + s.host)
+}
+
+// parseChallenge parses a WWW-Authenticate header, e.g. Bearer realm="https://ghcr.io/token",service="ghcr.io".
+func parseChallenge(challenge string) (scheme string, params map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params = make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return scheme, params
+}
@@ -0,0 +1,125 @@
+package docker_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/strangelove-ventures/heighliner/docker"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRegistry is a registry stand-in serving manifests behind token authentication.
+func fakeRegistry(t *testing.T) (*httptest.Server, *docker.RegistryClient) {
+	const token = "test-token"
+
+	mux := http.NewServeMux()
+	srv := httptest.NewTLSServer(mux)
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.Equal(t, "repository:heighliner/gaia:pull", r.URL.Query().Get("scope"))
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+	})
+
+	manifests := map[string]string{
+		// multi-platform index with an attestation manifest
+		"v1.0.0": `{"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[
+			{"digest":"sha256:amd64","platform":{"os":"linux","architecture":"amd64"}},
+			{"digest":"sha256:arm64","platform":{"os":"linux","architecture":"arm64","variant":"v8"}},
+			{"digest":"sha256:attestation","platform":{"os":"unknown","architecture":"unknown"}}]}`,
+		// single platform manifest
+		"v0.9.0": `{"mediaType":"application/vnd.docker.distribution.manifest.v2+json","config":{"digest":"sha256:abc"}}`,
+	}
+	mux.HandleFunc("/v2/heighliner/gaia/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.test"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/blobs/sha256:abc") {
+			_, _ = w.Write([]byte(`{"os":"linux","architecture":"amd64"}`))
+			return
+		}
+		m, ok := manifests[strings.TrimPrefix(r.URL.Path, "/v2/heighliner/gaia/manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(m))
+	})
+
+	client := &docker.RegistryClient{
+		HTTPClient: srv.Client(),
+		Credentials: func(host string) (docker.RegistryCredentials, error) {
+			require.Equal(t, strings.TrimPrefix(srv.URL, "https://"), host)
+			return docker.RegistryCredentials{Username: "user", Password: "pass"}, nil
+		},
+	}
+	return srv, client
+}
+
+func TestRegistryClientMissingPlatforms(t *testing.T) {
+	srv, client := fakeRegistry(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+	image := strings.TrimPrefix(srv.URL, "https://") + "/heighliner/gaia"
+
+	platforms, exists, err := client.ImagePlatforms(ctx, image, "v1.0.0")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, []string{"linux/amd64", "linux/arm64/v8"}, platforms)
+
+	missing, exists, err := client.MissingPlatforms(ctx, image, "v1.0.0", []string{"linux/amd64", "linux/arm64"})
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Empty(t, missing)
+
+	missing, exists, err = client.MissingPlatforms(ctx, image, "v0.9.0", []string{"linux/amd64", "linux/arm64"})
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, []string{"linux/arm64"}, missing)
+
+	missing, exists, err = client.MissingPlatforms(ctx, image, "v0.8.0", []string{"linux/amd64"})
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.Equal(t, []string{"linux/amd64"}, missing)
+
+	missing, exists, err = client.MissingPlatforms(ctx, image, "v0.9.0", nil)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Empty(t, missing)
+}
+
+func TestRegistryClientPlatformDigests(t *testing.T) {
+	srv, client := fakeRegistry(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+	image := strings.TrimPrefix(srv.URL, "https://") + "/heighliner/gaia"
+
+	digests, exists, err := client.PlatformDigests(ctx, image, "v1.0.0")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, map[string]string{"linux/amd64": "sha256:amd64", "linux/arm64/v8": "sha256:arm64"}, digests)
+
+	// the digest of a single platform manifest is that of its content.
+	digests, exists, err = client.PlatformDigests(ctx, image, "v0.9.0")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Len(t, digests, 1)
+	require.Regexp(t, "^sha256:[0-9a-f]{64}$", digests["linux/amd64"])
+
+	_, exists, err = client.PlatformDigests(ctx, image, "v0.8.0")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
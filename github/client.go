@@ -0,0 +1,290 @@
+// Package github is the GitHub API client shared by release lookups and go.mod fetches.
+package github
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAPIURL = "https://api.github.com"
+	DefaultRawURL = "https://raw.githubusercontent.com"
+
+	// DefaultMaxWait is how long requests wait for the rate limit to reset before failing.
+	DefaultMaxWait = 5 * time.Minute
+
+	requestTimeout = 30 * time.Second
+)
+
+// Client performs GitHub requests. It authenticates with GITHUB_TOKEN, or GH_USER and GH_PAT,
+// waits for the rate limit to reset when it is exceeded, and caches responses on disk, revalidating
+// them with If-None-Match so that unchanged responses do not count against the rate limit.
+// A Client is safe for concurrent use.
+type Client struct {
+	HTTP     *http.Client
+	APIURL   string
+	RawURL   string
+	Token    string // bearer token, takes precedence over User and Password
+	User     string
+	Password string
+	CacheDir string        // responses are not cached if empty
+	MaxWait  time.Duration // longest wait for the rate limit to reset, fail immediately if 0
+	Progress io.Writer     // warnings and rate limit waits, kept off stdout which carries command output
+
+	mu        sync.Mutex
+	limit     int
+	remaining int // -1 if unknown
+	reset     time.Time
+}
+
+var (
+	sharedMu      sync.Mutex
+	sharedClients = make(map[string]*Client)
+)
+
+// NewClient returns a client for the API at apiURL, with credentials from the environment
+// and responses cached in the user cache directory.
+func NewClient(apiURL string) *Client {
+	c := &Client{
+		HTTP:      &http.Client{Timeout: requestTimeout},
+		APIURL:    apiURL,
+		RawURL:    DefaultRawURL,
+		Token:     os.Getenv("GITHUB_TOKEN"),
+		User:      os.Getenv("GH_USER"),
+		Password:  os.Getenv("GH_PAT"),
+		MaxWait:   DefaultMaxWait,
+		Progress:  os.Stderr,
+		remaining: -1,
+	}
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		c.CacheDir = filepath.Join(cacheDir, "heighliner", "github")
+	}
+	return c
+}
+
+// Shared returns the client for the API at apiURL shared by all callers, so that they share
+// the rate limit state.
+func Shared(apiURL string) *Client {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	c, ok := sharedClients[apiURL]
+	if !ok {
+		c = NewClient(apiURL)
+		sharedClients[apiURL] = c
+	}
+	return c
+}
+
+// RateLimitError is returned when the rate limit is exceeded and does not reset within MaxWait.
+type RateLimitError struct {
+	Limit int
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	msg := fmt.Sprintf("github API rate limit of %d requests per hour exceeded, resets at %s", e.Limit, e.Reset.Local().Format(time.Kitchen))
+	return msg + ". Set GITHUB_TOKEN to use the higher rate limit of authenticated requests"
+}
+
+// GetJSON requests path of the API and decodes the JSON response into v.
+func (c *Client) GetJSON(ctx context.Context, path string, v any) error {
+	body, err := c.Get(ctx, c.APIURL+path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error parsing response of %s: %s, error: %w", path, body, err)
+	}
+	return nil
+}
+
+// RawFileURL returns the url of the files of a repository at ref, e.g. for Get.
+func (c *Client) RawFileURL(organization, repo, ref string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", c.RawURL, organization, repo, ref)
+}
+
+// ErrNotFound is returned by Get for 404 responses.
+var ErrNotFound = errors.New("404 Not Found")
+
+// Get requests url and returns the body of the response, which must be 200 OK.
+func (c *Client) Get(ctx context.Context, url string) ([]byte, error) {
+	cached, _ := c.readCache(url)
+	for retried := false; ; retried = true {
+		if err := c.waitForRateLimit(ctx); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+		if err != nil {
+			return nil, fmt.Errorf("error building request for %s: %w", url, err)
+		}
+		switch {
+		case c.Token != "":
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		case c.User != "" && c.Password != "":
+			req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.User+":"+c.Password)))
+		}
+		if cached != nil {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+
+		res, err := c.HTTP.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error performing request for %s: %w", url, err)
+		}
+		body, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading response of %s: %w", url, err)
+		}
+		limited := c.updateRateLimit(res)
+
+		switch {
+		case res.StatusCode == http.StatusOK:
+			if etag := res.Header.Get("ETag"); etag != "" {
+				if err := c.writeCache(url, cacheEntry{ETag: etag, Body: body}); err != nil {
+					fmt.Fprintf(c.Progress, "Warning: failed to cache response of %s: %v\n", url, err)
+				}
+			}
+			return body, nil
+		case res.StatusCode == http.StatusNotModified && cached != nil:
+			return cached.Body, nil
+		case res.StatusCode == http.StatusNotFound:
+			return nil, fmt.Errorf("GET %s: %w", url, ErrNotFound)
+		case limited && !retried:
+			continue
+		case limited:
+			c.mu.Lock()
+			err := &RateLimitError{Limit: c.limit, Reset: c.reset}
+			c.mu.Unlock()
+			return nil, err
+		default:
+			return nil, fmt.Errorf("GET %s: %s", url, res.Status)
+		}
+	}
+}
+
+// updateRateLimit records the rate limit headers of res, and returns whether the request
+// was rejected because the rate limit is exceeded.
+func (c *Client) updateRateLimit(res *http.Response) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if limit, err := strconv.Atoi(res.Header.Get("X-RateLimit-Limit")); err == nil {
+		c.limit = limit
+	}
+	if remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining")); err == nil {
+		c.remaining = remaining
+	}
+	if reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		c.reset = time.Unix(reset, 0)
+	}
+
+	if res.StatusCode != http.StatusForbidden && res.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	// secondary rate limits ask to retry after a number of seconds.
+	if retryAfter, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		c.remaining = 0
+		c.reset = time.Now().Add(time.Duration(retryAfter) * time.Second)
+		return true
+	}
+	return c.remaining == 0
+}
+
+// waitForRateLimit waits for the rate limit to reset if it is exceeded, failing if it does not reset within MaxWait.
+func (c *Client) waitForRateLimit(ctx context.Context) error {
+	c.mu.Lock()
+	remaining, limit, reset := c.remaining, c.limit, c.reset
+	c.mu.Unlock()
+
+	wait := time.Until(reset)
+	if remaining != 0 || wait <= 0 {
+		return nil
+	}
+	if wait > c.MaxWait {
+		return &RateLimitError{Limit: limit, Reset: reset}
+	}
+
+	fmt.Fprintf(c.Progress, "GitHub API rate limit exceeded, waiting %s for it to reset\n", wait.Round(time.Second))
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+	}
+
+	c.mu.Lock()
+	if c.reset.Equal(reset) {
+		c.remaining = -1
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+type cacheEntry struct {
+	ETag string `json:"etag"`
+	Body []byte `json:"body"`
+}
+
+func (c *Client) cachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.CacheDir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *Client) readCache(url string) (*cacheEntry, error) {
+	if c.CacheDir == "" {
+		return nil, errors.New("cache disabled")
+	}
+	bz, err := os.ReadFile(c.cachePath(url))
+	if err != nil {
+		return nil, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(bz, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// writeCache writes the entry atomically, as the same url may be requested concurrently.
+func (c *Client) writeCache(url string, entry cacheEntry) error {
+	if c.CacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(c.CacheDir, 0700); err != nil {
+		return err
+	}
+	bz, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(c.CacheDir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bz)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.cachePath(url))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package github_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/strangelove-ventures/heighliner/github"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T, url string) *github.Client {
+	t.Helper()
+	c := github.NewClient(url)
+	c.RawURL = url
+	c.CacheDir = t.TempDir()
+	c.Token = "token"
+	c.User, c.Password = "", ""
+	return c
+}
+
+func TestClientETagCache(t *testing.T) {
+	var requests, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(`[{"tag_name":"v1.0.0"}]`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c := newTestClient(t, srv.URL)
+	for i := 0; i < 2; i++ {
+		var res []map[string]string
+		require.NoError(t, c.GetJSON(context.Background(), "/repos/org/chain/releases", &res))
+		require.Equal(t, "v1.0.0", res[0]["tag_name"])
+	}
+	require.EqualValues(t, 2, requests.Load())
+	require.EqualValues(t, 1, notModified.Load())
+
+	// the cache is shared through the cache directory.
+	other := newTestClient(t, srv.URL)
+	other.CacheDir = c.CacheDir
+	body, err := other.Get(context.Background(), srv.URL+"/repos/org/chain/releases")
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"tag_name":"v1.0.0"}]`, string(body))
+	require.EqualValues(t, 2, notModified.Load())
+}
+
+func TestClientRateLimit(t *testing.T) {
+	var limited atomic.Bool
+	var reset atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Load(), 10))
+		if limited.CompareAndSwap(true, false) {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "59")
+		_, _ = w.Write([]byte("module chain\n"))
+	}))
+	t.Cleanup(srv.Close)
+
+	// the rate limit has already reset by the time the request is retried.
+	c := newTestClient(t, srv.URL)
+	limited.Store(true)
+	reset.Store(time.Now().Add(-time.Second).Unix())
+	body, err := c.Get(context.Background(), c.RawFileURL("org", "chain", "main")+"/go.mod")
+	require.NoError(t, err)
+	require.Equal(t, "module chain\n", string(body))
+
+	// the rate limit does not reset within MaxWait.
+	c = newTestClient(t, srv.URL)
+	c.MaxWait = time.Second
+	limited.Store(true)
+	reset.Store(time.Now().Add(time.Hour).Unix())
+	_, err = c.Get(context.Background(), c.RawFileURL("org", "chain", "main")+"/go.mod")
+	var rateLimitErr *github.RateLimitError
+	require.ErrorAs(t, err, &rateLimitErr)
+	require.Equal(t, 60, rateLimitErr.Limit)
+	require.ErrorContains(t, err, "Set GITHUB_TOKEN")
+
+	// later requests fail without being sent until the rate limit resets.
+	limited.Store(true)
+	_, err = c.Get(context.Background(), c.RawFileURL("org", "chain", "main")+"/go.mod")
+	require.ErrorAs(t, err, &rateLimitErr)
+	require.True(t, limited.Load())
+}
@@ -0,0 +1,81 @@
+package releases
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/hashicorp/go-version"
+)
+
+const (
+	// perPage is the number of releases requested per page, the maximum of the GitHub API.
+	// Sources may return less, e.g. gitea and forgejo return at most 50 by default.
+	perPage = 100
+	// maxPages limits how many pages of releases are searched for matching releases.
+	maxPages = 10
+)
+
+// Filter selects which releases are built. Drafts are never selected.
+type Filter struct {
+	Prereleases bool                // include prereleases, e.g. release candidates
+	Constraint  version.Constraints // only semver tags matching the constraint, if set
+	TagPattern  *regexp.Regexp      // only tags matching the pattern, if set
+}
+
+// Match returns whether the release is selected by the filter.
+func (f Filter) Match(r Release) bool {
+	if r.Draft {
+		return false
+	}
+	if f.TagPattern != nil && !f.TagPattern.MatchString(r.Tag) {
+		return false
+	}
+	v, err := version.NewSemver(r.Tag)
+	if !f.Prereleases && (r.Prerelease || (err == nil && v.Prerelease() != "")) {
+		return false
+	}
+	if f.Constraint != nil && (err != nil || !f.Constraint.Check(v)) {
+		return false
+	}
+	return true
+}
+
+// Find returns up to number of the most recent releases of repo which match the filter, paging
+// through the releases as needed. latest is the index of the release with the highest stable semver
+// of all releases found, or -1 if that release is not among the returned releases.
+func Find(ctx context.Context, source Source, repo Repo, filter Filter, number int) (found []Release, latest int, err error) {
+	var highest *version.Version
+	var highestTag string
+	for page := 1; page <= maxPages && len(found) < number; page++ {
+		rels, err := source.Releases(ctx, repo, page, perPage)
+		if err != nil {
+			return nil, -1, err
+		}
+		for _, r := range rels {
+			if !filter.Match(r) {
+				continue
+			}
+			if v, err := version.NewSemver(r.Tag); err == nil && !r.Prerelease && v.Prerelease() == "" {
+				if highest == nil || v.GreaterThan(highest) {
+					highest, highestTag = v, r.Tag
+				}
+			}
+			if len(found) < number {
+				found = append(found, r)
+			}
+		}
+		// servers may return fewer releases than requested per page, e.g. gitea caps the page size at
+		// its MAX_RESPONSE_ITEMS, so only an empty page is the last one.
+		if len(rels) == 0 {
+			break
+		}
+	}
+
+	latest = -1
+	for i, r := range found {
+		if highest != nil && r.Tag == highestTag {
+			latest = i
+		}
+	}
+	return found, latest, nil
+}
@@ -0,0 +1,85 @@
+package releases_test
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/go-version"
+	"github.com/strangelove-ventures/heighliner/releases"
+	"github.com/stretchr/testify/require"
+)
+
+// pagedSource serves releases in pages, counting the pages requested.
+type pagedSource struct {
+	releases []releases.Release
+	requests int
+	maxLimit int // caps the page size if set, like gitea does
+}
+
+func (s *pagedSource) Releases(_ context.Context, _ releases.Repo, page int, perPage int) ([]releases.Release, error) {
+	s.requests++
+	if s.maxLimit > 0 {
+		perPage = min(perPage, s.maxLimit)
+	}
+	start := min((page-1)*perPage, len(s.releases))
+	end := min(start+perPage, len(s.releases))
+	return s.releases[start:end], nil
+}
+
+func TestFind(t *testing.T) {
+	source := &pagedSource{releases: []releases.Release{
+		{Tag: "v16.0.0-rc1", Prerelease: true},
+		{Tag: "v17.0.0", Draft: true},
+		{Tag: "v14.2.1"}, // backport released after v15.0.0
+		{Tag: "api/v1.0.0"},
+		{Tag: "v15.0.0-rc2"}, // not marked as a prerelease
+		{Tag: "v15.0.0"},
+	}}
+	// push older releases onto the second page.
+	for i := 99; i >= 0; i-- {
+		source.releases = append(source.releases, releases.Release{Tag: fmt.Sprintf("v1.%d.0", i)})
+	}
+
+	found, latest, err := releases.Find(context.Background(), source, repo, releases.Filter{}, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v14.2.1", "api/v1.0.0", "v15.0.0"}, tags(found))
+	require.Equal(t, 2, latest)
+	require.Equal(t, 1, source.requests)
+
+	found, latest, err = releases.Find(context.Background(), source, repo, releases.Filter{TagPattern: regexp.MustCompile(`^v`)}, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v14.2.1"}, tags(found))
+	require.Equal(t, -1, latest, "v15.0.0 is the highest stable release")
+
+	found, latest, err = releases.Find(context.Background(), source, repo, releases.Filter{Prereleases: true}, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v16.0.0-rc1", "v14.2.1"}, tags(found))
+	require.Equal(t, -1, latest)
+
+	source.requests = 0
+	constraint, err := version.NewConstraint("< v1.2.0")
+	require.NoError(t, err)
+	found, latest, err = releases.Find(context.Background(), source, repo, releases.Filter{Constraint: constraint}, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1.1.0", "v1.0.0"}, tags(found))
+	require.Equal(t, 0, latest)
+	// the last page is only known when an empty page is returned.
+	require.Equal(t, 3, source.requests)
+
+	// pages smaller than requested are not the last page.
+	source.maxLimit, source.requests = 50, 0
+	found, _, err = releases.Find(context.Background(), source, repo, releases.Filter{Constraint: constraint}, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1.1.0", "v1.0.0"}, tags(found))
+	require.Equal(t, 4, source.requests)
+}
+
+func tags(rels []releases.Release) []string {
+	tags := make([]string, len(rels))
+	for i, r := range rels {
+		tags[i] = r.Tag
+	}
+	return tags
+}
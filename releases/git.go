@@ -0,0 +1,68 @@
+package releases
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/hashicorp/go-version"
+)
+
+// Git finds releases of any git host by listing the tags of the repository with ls-remote.
+// Tags which are not semver versions are ignored, the rest are sorted by semver.
+// All tags are listed at once, so are returned as the first page.
+type Git struct {
+	BaseURL string // e.g. https://git.example.com
+	Auth    transport.AuthMethod
+}
+
+func (g *Git) Releases(ctx context.Context, repo Repo, page int, _ int) ([]Release, error) {
+	if page > 1 {
+		return nil, nil
+	}
+
+	url := fmt.Sprintf("%s/%s/%s.git", g.BaseURL, repo.Organization, repo.Name)
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: g.Auth})
+	if err != nil {
+		return nil, fmt.Errorf("error listing tags of %s: %w", url, err)
+	}
+
+	type semverTag struct {
+		tag     string
+		version *version.Version
+	}
+	var tags []semverTag
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if !ref.Name().IsTag() {
+			continue
+		}
+		tag := ref.Name().Short()
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		v, err := version.NewSemver(tag)
+		if err != nil {
+			continue
+		}
+		tags = append(tags, semverTag{tag, v})
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].version.GreaterThan(tags[j].version)
+	})
+
+	releases := make([]Release, len(tags))
+	for i, t := range tags {
+		releases[i] = Release{Tag: t.tag, Prerelease: t.version.Prerelease() != ""}
+	}
+	return releases, nil
+}
@@ -0,0 +1,40 @@
+package releases
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Gitea finds releases with the Gitea REST API, which is also served by Forgejo.
+type Gitea struct {
+	Client  *http.Client
+	BaseURL string // e.g. https://gitea.com
+	Token   string // optional access token
+}
+
+type giteaRelease struct {
+	TagName     string    `json:"tag_name"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+func (g *Gitea) Releases(ctx context.Context, repo Repo, page int, perPage int) ([]Release, error) {
+	url := fmt.Sprintf("%s/api/v1/repos/%s/%s/releases?limit=%d&page=%d", g.BaseURL, repo.Organization, repo.Name, perPage, page)
+	header := http.Header{}
+	if g.Token != "" {
+		header.Set("Authorization", "token "+g.Token)
+	}
+
+	var res []giteaRelease
+	if err := getJSON(ctx, g.Client, url, header, &res); err != nil {
+		return nil, err
+	}
+	releases := make([]Release, len(res))
+	for i, r := range res {
+		releases[i] = Release{Tag: r.TagName, Draft: r.Draft, Prerelease: r.Prerelease, PublishedAt: r.PublishedAt}
+	}
+	return releases, nil
+}
@@ -0,0 +1,34 @@
+package releases
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/strangelove-ventures/heighliner/github"
+)
+
+// GitHub finds releases with the GitHub REST API, of github.com or a GitHub Enterprise Server.
+type GitHub struct {
+	Client *github.Client
+}
+
+type githubRelease struct {
+	TagName     string    `json:"tag_name"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+func (g *GitHub) Releases(ctx context.Context, repo Repo, page int, perPage int) ([]Release, error) {
+	var res []githubRelease
+	path := fmt.Sprintf("/repos/%s/%s/releases?per_page=%d&page=%d", repo.Organization, repo.Name, perPage, page)
+	if err := g.Client.GetJSON(ctx, path, &res); err != nil {
+		return nil, err
+	}
+	releases := make([]Release, len(res))
+	for i, r := range res {
+		releases[i] = Release{Tag: r.TagName, Draft: r.Draft, Prerelease: r.Prerelease, PublishedAt: r.PublishedAt}
+	}
+	return releases, nil
+}
@@ -0,0 +1,41 @@
+package releases
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// GitLab finds releases with the GitLab REST API, of gitlab.com or a self-managed instance.
+type GitLab struct {
+	Client  *http.Client
+	BaseURL string // e.g. https://gitlab.com
+	Token   string // optional personal, project or group access token
+}
+
+type gitlabRelease struct {
+	TagName         string    `json:"tag_name"`
+	UpcomingRelease bool      `json:"upcoming_release"`
+	ReleasedAt      time.Time `json:"released_at"`
+}
+
+func (g *GitLab) Releases(ctx context.Context, repo Repo, page int, perPage int) ([]Release, error) {
+	u := fmt.Sprintf("%s/api/v4/projects/%s/releases?per_page=%d&page=%d", g.BaseURL, url.PathEscape(repo.String()), perPage, page)
+	header := http.Header{}
+	if g.Token != "" {
+		header.Set("PRIVATE-TOKEN", g.Token)
+	}
+
+	var res []gitlabRelease
+	if err := getJSON(ctx, g.Client, u, header, &res); err != nil {
+		return nil, err
+	}
+	releases := make([]Release, len(res))
+	for i, r := range res {
+		// GitLab has no drafts or prereleases, releases with a future date are upcoming.
+		releases[i] = Release{Tag: r.TagName, Prerelease: r.UpcomingRelease, PublishedAt: r.ReleasedAt}
+	}
+	return releases, nil
+}
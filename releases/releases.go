@@ -0,0 +1,150 @@
+// Package releases finds the releases of chain repositories on different git hosts.
+package releases
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/strangelove-ventures/heighliner/github"
+)
+
+// HostType selects the API used to find the releases of a repository.
+type HostType string
+
+const (
+	HostTypeGitHub HostType = "github"
+	HostTypeGitLab HostType = "gitlab"
+	HostTypeGitea  HostType = "gitea"
+	HostTypeGit    HostType = "git" // tags listed with git ls-remote, for any host
+)
+
+// HostTypes are the accepted values for `repo-type`.
+var HostTypes = []HostType{HostTypeGitHub, HostTypeGitLab, HostTypeGitea, HostTypeGit}
+
+const requestTimeout = 5 * time.Second
+
+// Release is a released version of a repository.
+type Release struct {
+	Tag         string
+	Prerelease  bool
+	Draft       bool
+	PublishedAt time.Time // zero if unknown, e.g. for git tags
+}
+
+// Repo is a repository on the host of a Source.
+type Repo struct {
+	Organization string
+	Name         string
+}
+
+func (r Repo) String() string {
+	return r.Organization + "/" + r.Name
+}
+
+// Source finds the releases of repositories on a git host.
+type Source interface {
+	// Releases returns a page of the releases of repo, newest first. Pages start at 1.
+	Releases(ctx context.Context, repo Repo, page int, perPage int) ([]Release, error)
+}
+
+// NewSource returns the release source for a repository host. If hostType is empty, it is derived
+// from the host: github for github.com (the default host), gitlab for gitlab.com, and git otherwise.
+// API tokens are read from the environment: GITHUB_TOKEN (or GH_USER and GH_PAT), GITLAB_TOKEN or GITEA_TOKEN.
+// Git sources list tags with auth if set, e.g. a clone key, over ssh for ssh auth.
+// GitHub sources share a client per host, see github.Shared.
+func NewSource(hostType HostType, host string, auth transport.AuthMethod) (Source, error) {
+	if host == "" {
+		host = "github.com"
+	}
+	if hostType == "" {
+		hostType = DefaultHostType(host)
+	}
+	client := &http.Client{Timeout: requestTimeout}
+	switch hostType {
+	case HostTypeGitHub:
+		apiURL := github.DefaultAPIURL
+		if host != "github.com" {
+			// GitHub Enterprise Server
+			apiURL = "https://" + host + "/api/v3"
+		}
+		return &GitHub{Client: github.Shared(apiURL)}, nil
+	case HostTypeGitLab:
+		return &GitLab{Client: client, BaseURL: "https://" + host, Token: os.Getenv("GITLAB_TOKEN")}, nil
+	case HostTypeGitea:
+		return &Gitea{Client: client, BaseURL: "https://" + host, Token: os.Getenv("GITEA_TOKEN")}, nil
+	case HostTypeGit:
+		if _, ok := auth.(ssh.AuthMethod); ok {
+			return &Git{BaseURL: "ssh://git@" + host, Auth: auth}, nil
+		}
+		return &Git{BaseURL: "https://" + host, Auth: auth}, nil
+	default:
+		return nil, fmt.Errorf("unknown repo type %q, must be one of: %s", hostType, JoinHostTypes())
+	}
+}
+
+// DefaultHostType returns the host type used for host when it is not configured.
+func DefaultHostType(host string) HostType {
+	switch host {
+	case "", "github.com":
+		return HostTypeGitHub
+	case "gitlab.com":
+		return HostTypeGitLab
+	default:
+		return HostTypeGit
+	}
+}
+
+// JoinHostTypes returns the accepted host types as a comma separated list.
+func JoinHostTypes() string {
+	s := make([]string, len(HostTypes))
+	for i, t := range HostTypes {
+		s[i] = string(t)
+	}
+	return strings.Join(s, ", ")
+}
+
+// ValidHostType returns whether t is a known host type. Empty is valid, as it selects the default for the host.
+func ValidHostType(t HostType) bool {
+	return t == "" || slices.Contains(HostTypes, t)
+}
+
+// getJSON performs a GET request for url and decodes the JSON response into v.
+func getJSON(ctx context.Context, client *http.Client, url string, header http.Header, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("error building releases request: %w", err)
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error performing releases request: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading body from releases request: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error parsing releases response: %s, error: %w", body, err)
+	}
+	return nil
+}
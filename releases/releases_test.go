@@ -0,0 +1,150 @@
+package releases_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/strangelove-ventures/heighliner/github"
+	"github.com/strangelove-ventures/heighliner/releases"
+	"github.com/stretchr/testify/require"
+)
+
+var repo = releases.Repo{Organization: "org", Name: "chain"}
+
+// jsonServer serves body for path, after checking the auth header.
+func jsonServer(t *testing.T, path, authHeader, auth, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != path {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get(authHeader) != auth {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGitHubReleases(t *testing.T) {
+	srv := jsonServer(t, "/repos/org/chain/releases?per_page=2&page=1", "Authorization", "Bearer token",
+		`[{"tag_name":"v2.0.0-rc1","prerelease":true},{"tag_name":"v1.0.0","published_at":"2024-01-02T03:04:05Z"}]`)
+
+	client := github.NewClient(srv.URL)
+	client.CacheDir = ""
+	client.Token = "token"
+	client.User, client.Password = "", ""
+	source := &releases.GitHub{Client: client}
+	rels, err := source.Releases(context.Background(), repo, 1, 2)
+	require.NoError(t, err)
+	require.Len(t, rels, 2)
+	require.Equal(t, "v2.0.0-rc1", rels[0].Tag)
+	require.True(t, rels[0].Prerelease)
+	require.Equal(t, "v1.0.0", rels[1].Tag)
+	require.Equal(t, 2024, rels[1].PublishedAt.Year())
+
+	client.Token = ""
+	_, err = source.Releases(context.Background(), repo, 1, 2)
+	require.ErrorContains(t, err, "401")
+}
+
+func TestGitLabReleases(t *testing.T) {
+	srv := jsonServer(t, "/api/v4/projects/org%2Fchain/releases?per_page=2&page=1", "PRIVATE-TOKEN", "token",
+		`[{"tag_name":"v1.1.0","upcoming_release":true},{"tag_name":"v1.0.0"}]`)
+
+	source := &releases.GitLab{Client: srv.Client(), BaseURL: srv.URL, Token: "token"}
+	rels, err := source.Releases(context.Background(), repo, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, []releases.Release{{Tag: "v1.1.0", Prerelease: true}, {Tag: "v1.0.0"}}, rels)
+}
+
+func TestGiteaReleases(t *testing.T) {
+	srv := jsonServer(t, "/api/v1/repos/org/chain/releases?limit=2&page=1", "Authorization", "token token",
+		`[{"tag_name":"v1.1.0","draft":true},{"tag_name":"v1.0.0"}]`)
+
+	source := &releases.Gitea{Client: srv.Client(), BaseURL: srv.URL, Token: "token"}
+	rels, err := source.Releases(context.Background(), repo, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, []releases.Release{{Tag: "v1.1.0", Draft: true}, {Tag: "v1.0.0"}}, rels)
+}
+
+func TestGitReleases(t *testing.T) {
+	// serves the refs advertisement of the git smart http protocol, which is all ls-remote reads.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/org/chain.git/info/refs" {
+			http.NotFound(w, r)
+			return
+		}
+		ar := packp.NewAdvRefs()
+		ar.Prefix = [][]byte{[]byte("# service=git-upload-pack"), pktline.Flush}
+		hash := plumbing.NewHash("1111111111111111111111111111111111111111")
+		ar.Head = &hash
+		for _, ref := range []string{"refs/heads/main", "refs/tags/v1.9.0", "refs/tags/v1.10.0", "refs/tags/v1.10.1-rc1",
+			"refs/tags/api/v1.0.0", "refs/tags/nightly", "refs/tags/v0.1.0"} {
+			require.NoError(t, ar.AddReference(plumbing.NewHashReference(plumbing.ReferenceName(ref), hash)))
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		require.NoError(t, ar.Encode(w))
+	}))
+	t.Cleanup(srv.Close)
+
+	source := &releases.Git{BaseURL: srv.URL}
+	rels, err := source.Releases(context.Background(), repo, 1, 100)
+	require.NoError(t, err)
+	require.Equal(t, []releases.Release{
+		{Tag: "v1.10.1-rc1", Prerelease: true},
+		{Tag: "v1.10.0"},
+		{Tag: "v1.9.0"},
+		{Tag: "v0.1.0"},
+	}, rels)
+
+	rels, err = source.Releases(context.Background(), repo, 2, 100)
+	require.NoError(t, err)
+	require.Empty(t, rels)
+}
+
+func TestNewSource(t *testing.T) {
+	for _, tt := range []struct {
+		hostType releases.HostType
+		host     string
+		want     releases.Source
+	}{
+		{"", "", &releases.GitHub{}},
+		{"", "github.com", &releases.GitHub{}},
+		{"", "gitlab.com", &releases.GitLab{}},
+		{"", "git.example.com", &releases.Git{}},
+		{releases.HostTypeGitea, "git.example.com", &releases.Gitea{}},
+		{releases.HostTypeGitHub, "github.example.com", &releases.GitHub{}},
+	} {
+		source, err := releases.NewSource(tt.hostType, tt.host, nil)
+		require.NoError(t, err)
+		require.IsType(t, tt.want, source, "%s %s", tt.hostType, tt.host)
+	}
+
+	// git sources list tags with the auth of the chain, over ssh for ssh auth.
+	tokenAuth := &githttp.BasicAuth{Username: "x-access-token", Password: "token"}
+	source, err := releases.NewSource(releases.HostTypeGit, "git.example.com", tokenAuth)
+	require.NoError(t, err)
+	require.Equal(t, &releases.Git{BaseURL: "https://git.example.com", Auth: tokenAuth}, source)
+	sshAuth := &gitssh.Password{User: "git", Password: "secret"}
+	source, err = releases.NewSource(releases.HostTypeGit, "git.example.com", sshAuth)
+	require.NoError(t, err)
+	require.Equal(t, &releases.Git{BaseURL: "ssh://git@git.example.com", Auth: sshAuth}, source)
+
+	source, err = releases.NewSource(releases.HostTypeGitHub, "github.example.com", nil)
+	require.NoError(t, err)
+	require.Equal(t, "https://github.example.com/api/v3", source.(*releases.GitHub).Client.APIURL)
+
+	_, err = releases.NewSource("bitbucket", "bitbucket.org", nil)
+	require.ErrorContains(t, err, "unknown repo type")
+}